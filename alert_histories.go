@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type AlertHistory struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func OpenAlertHistory(path string) (*AlertHistory, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &AlertHistory{file: file}, nil
+}
+
+func (a *AlertHistory) Append(record AlertRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	_, err = a.file.Write(append(line, '\n'))
+	return err
+}
+
+func (a *AlertHistory) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.file.Close()
+}
+
+type AlertHistoryQuery struct {
+	Since  time.Time
+	Until  time.Time
+	Rule   string
+	States []AlertState
+}
+
+func (a AlertHistoryQuery) Match(record AlertRecord) bool {
+	at := record.Transition.At
+	if !a.Since.IsZero() && at.Before(a.Since) || !a.Until.IsZero() && !at.Before(a.Until) {
+		return false
+	}
+	if a.Rule != "" && record.Transition.Alert != a.Rule && record.Transition.Alert != "rule:"+a.Rule {
+		return false
+	}
+	if len(a.States) == 0 {
+		return true
+	}
+	for _, state := range a.States {
+		if record.Transition.To == state {
+			return true
+		}
+	}
+	return false
+}
+
+func ReadAlertHistory(reader io.Reader, query AlertHistoryQuery, fn func(AlertRecord)) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var invalid error
+	for line := 1; scanner.Scan(); line++ {
+		if invalid != nil {
+			return invalid
+		}
+		var record AlertRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			invalid = fmt.Errorf("invalid alert record on line %d: %s", line, err.Error())
+			continue
+		}
+		if query.Match(record) {
+			fn(record)
+		}
+	}
+	return scanner.Err()
+}
+
+func ParseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return now.Add(-ago), nil
+	}
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s; expected RFC 3339 such as 2016-03-01T12:00:00Z or a duration before now such as 24h", value)
+	}
+	return at, nil
+}
+
+func WriteAlertRecords(writer io.Writer, records []AlertRecord) error {
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "TIME\tSTATE\tALERT\tSUBJECT\tVALUE\tTHRESHOLD\tWINDOW\tOUTCOME\tDETAILS")
+	for _, record := range records {
+		transition := record.Transition
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			transition.At.Format(time.RFC3339),
+			transition.To,
+			transition.Alert,
+			transition.Subject,
+			strconv.FormatFloat(transition.Value, 'g', 6, 64),
+			strconv.FormatFloat(transition.Threshold, 'g', 6, 64),
+			record.WindowEnd.Sub(record.WindowStart),
+			record.Outcome,
+			transition.Details,
+		)
+	}
+	return table.Flush()
+}
@@ -0,0 +1,133 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`AlertHistory`, func() {
+	var (
+		directory string
+		path      string
+		start     time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "alerts")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(directory, "alerts.jsonl")
+		start = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(directory)
+	})
+
+	read := func(query AlertHistoryQuery) []AlertRecord {
+		file, err := os.Open(path)
+		Expect(err).ToNot(HaveOccurred())
+		defer file.Close()
+		var records []AlertRecord
+		Expect(ReadAlertHistory(file, query, func(record AlertRecord) {
+			records = append(records, record)
+		})).To(Succeed())
+		return records
+	}
+
+	It(`records every transition of the alert manager with its labels, values and window`, func() {
+		history, err := OpenAlertHistory(path)
+		Expect(err).ToNot(HaveOccurred())
+		manager := NewAlertManager(new(notificationMock), ManagerHistory(history))
+		_, err = manager.AddSilence(Silence{Matchers: []string{"group=/users"}, StartsAt: start, EndsAt: start.Add(time.Hour)})
+		Expect(err).ToNot(HaveOccurred())
+
+		alert := NewTotalTrafficAlert(2, time.Minute, manager, AlertFor(10*time.Second), AlertLabel("/api"))
+		for _, at := range []time.Time{start, start, start.Add(10 * time.Second), start.Add(2 * time.Minute)} {
+			alert.Check(Event{Time: at})
+		}
+		NewTotalTrafficAlert(1, time.Minute, manager, AlertLabel("/users")).Check(Event{Time: start})
+		Expect(history.Close()).To(Succeed())
+
+		records := read(AlertHistoryQuery{})
+		Expect(records).To(HaveLen(4))
+		Expect(records[1].Transition.To).To(Equal(AlertFiring))
+		Expect(records[1].Transition.Value).To(Equal(3.0))
+		Expect(records[1].Transition.Threshold).To(Equal(2.0))
+		Expect(records[1].Labels).To(Equal(map[string]string{"alert": "traffic", "group": "/api", "subject": "traffic on /api"}))
+		Expect(records[1].WindowStart).To(Equal(start.Add(-50 * time.Second)))
+		Expect(records[1].WindowEnd).To(Equal(start.Add(10 * time.Second)))
+		Expect(records[1].Outcome).To(Equal(AlertNotified))
+		Expect(records[3].Outcome).To(Equal(AlertSilenced))
+
+		history, err = OpenAlertHistory(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(history.Append(NewAlertRecord(AlertTransition{Alert: "rule:api", To: AlertFiring, At: start.Add(time.Hour)}, AlertNotified))).To(Succeed())
+		Expect(history.Close()).To(Succeed())
+		Expect(read(AlertHistoryQuery{})).To(HaveLen(5))
+	})
+
+	It(`filters the history by time range, rule and state`, func() {
+		history, err := OpenAlertHistory(path)
+		Expect(err).ToNot(HaveOccurred())
+		for i, transition := range []AlertTransition{
+			{Alert: "traffic", To: AlertFiring, At: start},
+			{Alert: "rule:api", To: AlertPending, At: start.Add(time.Minute)},
+			{Alert: "rule:api", To: AlertFiring, At: start.Add(2 * time.Minute)},
+			{Alert: "rule:api", To: AlertResolved, At: start.Add(3 * time.Minute)},
+		} {
+			Expect(history.Append(NewAlertRecord(transition, AlertNotified))).To(Succeed(), "record %d", i)
+		}
+		Expect(history.Close()).To(Succeed())
+
+		Expect(read(AlertHistoryQuery{Rule: "api"})).To(HaveLen(3))
+		Expect(read(AlertHistoryQuery{Rule: "traffic"})).To(HaveLen(1))
+		Expect(read(AlertHistoryQuery{States: []AlertState{AlertFiring, AlertResolved}})).To(HaveLen(3))
+		records := read(AlertHistoryQuery{Since: start.Add(time.Minute), Until: start.Add(3 * time.Minute), Rule: "api", States: []AlertState{AlertFiring}})
+		Expect(records).To(HaveLen(1))
+		Expect(records[0].Transition.At).To(Equal(start.Add(2 * time.Minute)))
+	})
+
+	It(`skips a last line cut short and rejects other invalid lines`, func() {
+		history, err := OpenAlertHistory(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(history.Append(NewAlertRecord(AlertTransition{Alert: "traffic", To: AlertFiring, At: start}, AlertNotified))).To(Succeed())
+		Expect(history.Close()).To(Succeed())
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		Expect(err).ToNot(HaveOccurred())
+		file.WriteString(`{"transition": {"alert": "tra`)
+		file.Close()
+		Expect(read(AlertHistoryQuery{})).To(HaveLen(1))
+
+		err = ReadAlertHistory(strings.NewReader("{\n{}\n"), AlertHistoryQuery{}, func(AlertRecord) {})
+		Expect(err).To(MatchError(HavePrefix("invalid alert record on line 1: ")))
+	})
+
+	It(`parses the times of a range as RFC 3339 or a duration before now`, func() {
+		at, err := ParseHistoryTime("2h", start)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(at).To(Equal(start.Add(-2 * time.Hour)))
+		at, err = ParseHistoryTime("2016-03-01T11:00:00Z", start)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(at).To(Equal(start.Add(-time.Hour)))
+		_, err = ParseHistoryTime("yesterday", start)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It(`writes the records as a table`, func() {
+		var output bytes.Buffer
+		transition := AlertTransition{Alert: "traffic", To: AlertFiring, At: start, Subject: "traffic on /api", Value: 3, Threshold: 2, Details: "hits = 3", Window: time.Minute}
+		Expect(WriteAlertRecords(&output, []AlertRecord{NewAlertRecord(transition, AlertNotified)})).To(Succeed())
+		Expect(output.String()).To(Equal("" +
+			"TIME                  STATE   ALERT    SUBJECT          VALUE  THRESHOLD  WINDOW  OUTCOME   DETAILS\n" +
+			"2016-03-01T12:00:00Z  firing  traffic  traffic on /api  3      2          1m0s    notified  hits = 3\n"))
+	})
+})
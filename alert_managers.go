@@ -0,0 +1,483 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultMaxAlertRecords = 1000
+
+const (
+	AlertNotified     = "notified"
+	AlertSilenced     = "silenced"
+	AlertDeduplicated = "deduplicated"
+	AlertRecorded     = "recorded"
+)
+
+var alertLabels = map[string]bool{"alert": true, "group": true, "subject": true}
+
+type Silence struct {
+	ID       string    `json:"id"`
+	Matchers []string  `json:"matchers"`
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+	Comment  string    `json:"comment,omitempty"`
+
+	matchers []silenceMatcher
+}
+
+type silenceMatcher struct {
+	label  string
+	value  string
+	negate bool
+}
+
+func (s *Silence) compile() error {
+	if len(s.Matchers) == 0 {
+		return fmt.Errorf("silence has no matchers")
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return fmt.Errorf("silence ends at %s, before it starts at %s", s.EndsAt, s.StartsAt)
+	}
+	s.matchers = nil
+	for _, matcher := range s.Matchers {
+		separator := strings.Index(matcher, "=")
+		if separator <= 0 {
+			return fmt.Errorf("invalid silence matcher %s; expected label=value or label!=value", matcher)
+		}
+		compiled := silenceMatcher{label: matcher[:separator], value: matcher[separator+1:]}
+		if strings.HasSuffix(compiled.label, "!") {
+			compiled.label, compiled.negate = strings.TrimSuffix(compiled.label, "!"), true
+		}
+		if err := CheckAlertLabels([]string{compiled.label}); err != nil {
+			return err
+		}
+		s.matchers = append(s.matchers, compiled)
+	}
+	return nil
+}
+
+func (s *Silence) Active(at time.Time) bool {
+	return !at.Before(s.StartsAt) && at.Before(s.EndsAt)
+}
+
+func (s *Silence) Matches(labels map[string]string) bool {
+	for _, matcher := range s.matchers {
+		if (labels[matcher.label] == matcher.value) == matcher.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func CheckAlertLabels(labels []string) error {
+	for _, label := range labels {
+		if !alertLabels[label] {
+			return fmt.Errorf("unknown alert label %s; expected alert, group or subject", label)
+		}
+	}
+	return nil
+}
+
+type AlertRecord struct {
+	Transition  AlertTransition   `json:"transition"`
+	Labels      map[string]string `json:"labels"`
+	WindowStart time.Time         `json:"window_start"`
+	WindowEnd   time.Time         `json:"window_end"`
+	Outcome     string            `json:"outcome"`
+	Silence     string            `json:"silence,omitempty"`
+}
+
+func NewAlertRecord(transition AlertTransition, outcome string) AlertRecord {
+	return AlertRecord{
+		Transition:  transition,
+		Labels:      transition.Labels(),
+		WindowStart: transition.At.Add(-transition.Window),
+		WindowEnd:   transition.At,
+		Outcome:     outcome,
+	}
+}
+
+type AlertManagerOption func(*AlertManager)
+
+func ManagerGroupBy(labels ...string) AlertManagerOption {
+	return func(a *AlertManager) {
+		a.groupBy = labels
+	}
+}
+
+func GroupWait(wait time.Duration) AlertManagerOption {
+	return func(a *AlertManager) {
+		a.groupWait = wait
+	}
+}
+
+func GroupInterval(interval time.Duration) AlertManagerOption {
+	return func(a *AlertManager) {
+		a.groupInterval = interval
+	}
+}
+
+func RepeatInterval(interval time.Duration) AlertManagerOption {
+	return func(a *AlertManager) {
+		a.repeatInterval = interval
+	}
+}
+
+func ManagerClock(clock Clock) AlertManagerOption {
+	return func(a *AlertManager) {
+		a.clock = clock
+	}
+}
+
+func ManagerHistory(history *AlertHistory) AlertManagerOption {
+	return func(a *AlertManager) {
+		a.history = history
+	}
+}
+
+func MaxAlertRecords(maxRecords int) AlertManagerOption {
+	return func(a *AlertManager) {
+		a.maxRecords = maxRecords
+	}
+}
+
+type AlertManager struct {
+	mutex          sync.Mutex
+	notification   Notification
+	clock          Clock
+	groupBy        []string
+	groupWait      time.Duration
+	groupInterval  time.Duration
+	repeatInterval time.Duration
+	maxRecords     int
+	history        *AlertHistory
+
+	now         time.Time
+	groups      map[string]*alertGroup
+	silences    []*Silence
+	nextSilence int
+	records     []AlertRecord
+}
+
+type alertGroup struct {
+	alerts   map[string]AlertTransition
+	changed  time.Time
+	notified time.Time
+}
+
+func NewAlertManager(notification Notification, options ...AlertManagerOption) *AlertManager {
+	manager := &AlertManager{
+		notification: notification,
+		clock:        WallClock,
+		maxRecords:   DefaultMaxAlertRecords,
+		groups:       map[string]*alertGroup{},
+	}
+	for _, option := range options {
+		option(manager)
+	}
+	return manager
+}
+
+func (a *AlertManager) AddSilence(silence Silence) (string, error) {
+	if err := silence.compile(); err != nil {
+		return "", err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.nextSilence++
+	silence.ID = strconv.Itoa(a.nextSilence)
+	a.silences = append(a.silences, &silence)
+	return silence.ID, nil
+}
+
+func (a *AlertManager) ExpireSilence(id string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, silence := range a.silences {
+		if silence.ID == id {
+			if now := a.clock.Now(); silence.EndsAt.After(now) {
+				silence.EndsAt = now
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AlertManager) Silences() []Silence {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	silences := make([]Silence, len(a.silences))
+	for i, silence := range a.silences {
+		silences[i] = *silence
+	}
+	return silences
+}
+
+func (a *AlertManager) Records() []AlertRecord {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]AlertRecord(nil), a.records...)
+}
+
+func (a *AlertManager) Send(message Message) {
+	a.notification.Send(message)
+}
+
+func (a *AlertManager) SendTransition(transition AlertTransition) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.advance(transition.At)
+
+	record := NewAlertRecord(transition, AlertRecorded)
+	defer func() {
+		a.record(record)
+	}()
+	if transition.To != AlertFiring && transition.To != AlertResolved {
+		return
+	}
+
+	group := a.group(record.Labels)
+	fingerprint := transition.Alert + "\x00" + transition.Label + "\x00" + transition.Subject
+	previous, known := group.alerts[fingerprint]
+	if silence := a.silencedBy(record.Labels, transition.At); silence != nil {
+		record.Outcome, record.Silence = AlertSilenced, silence.ID
+		if transition.To == AlertResolved && known {
+			delete(group.alerts, fingerprint)
+		}
+		return
+	}
+	if transition.To == AlertFiring && known && previous.To == AlertFiring || transition.To == AlertResolved && !known {
+		record.Outcome = AlertDeduplicated
+		return
+	}
+	group.alerts[fingerprint] = transition
+	if group.changed.IsZero() {
+		group.changed = a.now
+	}
+	record.Outcome = AlertNotified
+}
+
+func (a *AlertManager) silencedBy(labels map[string]string, at time.Time) *Silence {
+	for _, silence := range a.silences {
+		if silence.Active(at) && silence.Matches(labels) {
+			return silence
+		}
+	}
+	return nil
+}
+
+func (a *AlertManager) Check(event Event) {
+	a.Tick(event.Time)
+}
+
+func (a *AlertManager) Tick(now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.advance(now)
+
+	keys := make([]string, 0, len(a.groups))
+	for key := range a.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		group := a.groups[key]
+		if !group.changed.IsZero() {
+			if a.now.Sub(group.changed) >= a.groupWait && (group.notified.IsZero() || a.now.Sub(group.notified) >= a.groupInterval) {
+				a.notify(key, group, false)
+			}
+		} else if a.repeatInterval > 0 && len(group.alerts) > 0 && a.now.Sub(group.notified) >= a.repeatInterval {
+			a.notify(key, group, true)
+		}
+		if len(group.alerts) == 0 {
+			delete(a.groups, key)
+		}
+	}
+}
+
+func (a *AlertManager) Flush() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	keys := make([]string, 0, len(a.groups))
+	for key := range a.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if group := a.groups[key]; !group.changed.IsZero() {
+			a.notify(key, group, false)
+		}
+	}
+}
+
+func (a *AlertManager) advance(now time.Time) {
+	if now.After(a.now) {
+		a.now = now
+	}
+}
+
+func (a *AlertManager) group(labels map[string]string) *alertGroup {
+	values := make([]string, len(a.groupBy))
+	for i, label := range a.groupBy {
+		values[i] = label + "=" + labels[label]
+	}
+	key := strings.Join(values, " ")
+	group, ok := a.groups[key]
+	if !ok {
+		group = &alertGroup{alerts: map[string]AlertTransition{}}
+		a.groups[key] = group
+	}
+	return group
+}
+
+func (a *AlertManager) notify(key string, group *alertGroup, reminder bool) {
+	fingerprints := make([]string, 0, len(group.alerts))
+	for fingerprint := range group.alerts {
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	sort.Strings(fingerprints)
+
+	var firing, resolved []AlertTransition
+	for _, fingerprint := range fingerprints {
+		transition := group.alerts[fingerprint]
+		if reminder && a.silencedBy(transition.Labels(), a.now) != nil {
+			continue
+		}
+		if transition.To == AlertFiring {
+			firing = append(firing, transition)
+		} else {
+			resolved = append(resolved, transition)
+			delete(group.alerts, fingerprint)
+		}
+	}
+	group.changed, group.notified = time.Time{}, a.now
+	if len(firing)+len(resolved) == 0 {
+		return
+	}
+
+	message := Message{
+		Kind:     AlertMessage,
+		Severity: SeverityInfo,
+		Values:   map[string]float64{"firing": float64(len(firing)), "resolved": float64(len(resolved))},
+		At:       a.now,
+		Reminder: reminder,
+	}
+	if len(firing) > 0 {
+		message.Severity = SeverityCritical
+	}
+	if len(a.groupBy) > 0 {
+		labels := append(append([]AlertTransition{}, firing...), resolved...)[0].Labels()
+		message.Labels = map[string]string{}
+		for _, label := range a.groupBy {
+			message.Labels[label] = labels[label]
+		}
+	}
+	scope := ""
+	if key != "" {
+		scope = " for " + key
+	}
+	switch {
+	case reminder:
+		message.Title = fmt.Sprintf("Reminder: %d alerts still firing%s", len(firing), scope)
+		message.Transitions = firing
+	case len(firing)+len(resolved) == 1:
+		message.Transitions = append(firing, resolved...)
+		message.Title = message.Transitions[0].Title()
+	default:
+		message.Title = fmt.Sprintf("%d alerts firing, %d resolved%s", len(firing), len(resolved), scope)
+		message.Transitions = append(firing, resolved...)
+	}
+	for _, transition := range message.Transitions {
+		if message.Start.IsZero() || transition.At.Before(message.Start) {
+			message.Start = transition.At
+		}
+		if transition.At.After(message.End) {
+			message.End = transition.At
+		}
+	}
+	a.notification.Send(message)
+}
+
+func (a *AlertManager) record(record AlertRecord) {
+	if a.history != nil {
+		if err := a.history.Append(record); err != nil {
+			a.notification.Send(NewErrorMessage("Could not record the alert in the history: %s", err.Error()))
+		}
+	}
+	a.records = append(a.records, record)
+	if a.maxRecords > 0 && len(a.records) > a.maxRecords {
+		a.records = append(a.records[:0], a.records[len(a.records)-a.maxRecords:]...)
+	}
+}
+
+func (a *AlertManager) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/alerts", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, a.Records())
+	})
+	mux.HandleFunc("/silences", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, a.Silences())
+		case http.MethodPost:
+			var request struct {
+				Silence
+				Duration string `json:"duration"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			silence := request.Silence
+			if silence.StartsAt.IsZero() {
+				silence.StartsAt = a.clock.Now()
+			}
+			if request.Duration != "" {
+				duration, err := time.ParseDuration(request.Duration)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				silence.EndsAt = silence.StartsAt.Add(duration)
+			}
+			id, err := a.AddSilence(silence)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusCreated, map[string]string{"id": id})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/silences/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !a.ExpireSilence(strings.TrimPrefix(r.URL.Path, "/silences/")) {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
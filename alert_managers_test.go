@@ -0,0 +1,263 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`AlertManager`, func() {
+	var (
+		notification *notificationMock
+		start        time.Time
+	)
+
+	BeforeEach(func() {
+		notification = new(notificationMock)
+		start = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	transition := func(alert, label string, to AlertState, at time.Time) AlertTransition {
+		subject := alert
+		if label != "" {
+			subject += " on " + label
+		}
+		return AlertTransition{Alert: alert, Label: label, To: to, At: at, Condition: "High " + subject, Subject: subject}
+	}
+
+	It(`groups the alerts of one check into one notification`, func() {
+		manager := NewAlertManager(notification)
+		manager.SendTransition(transition("traffic", "/api", AlertFiring, start))
+		manager.SendTransition(transition("error-rate", "/api", AlertFiring, start))
+		manager.SendTransition(transition("traffic", "/users", AlertFiring, start))
+		manager.Tick(start)
+		Expect(notification.messages).To(Equal([]string{"3 alerts firing, 0 resolved\n" +
+			"High error-rate on /api generated an alert, triggered at 2016-03-01 12:00:00 +0000 UTC\n" +
+			"High traffic on /api generated an alert, triggered at 2016-03-01 12:00:00 +0000 UTC\n" +
+			"High traffic on /users generated an alert, triggered at 2016-03-01 12:00:00 +0000 UTC\n",
+		}))
+	})
+
+	It(`sends a single alert as it is`, func() {
+		manager := NewAlertManager(notification)
+		manager.SendTransition(transition("traffic", "", AlertFiring, start))
+		manager.Tick(start)
+		Expect(notification.messages).To(Equal([]string{"High traffic generated an alert, triggered at 2016-03-01 12:00:00 +0000 UTC\n"}))
+
+		manager.SendTransition(transition("traffic", "", AlertResolved, start.Add(time.Minute)))
+		manager.Tick(start.Add(time.Minute))
+		Expect(notification.message).To(Equal("Traffic returned to normal, triggered at 2016-03-01 12:01:00 +0000 UTC\n"))
+	})
+
+	It(`groups the alerts by labels`, func() {
+		manager := NewAlertManager(notification, ManagerGroupBy("group"))
+		manager.SendTransition(transition("traffic", "/api", AlertFiring, start))
+		manager.SendTransition(transition("error-rate", "/api", AlertFiring, start))
+		manager.SendTransition(transition("traffic", "/users", AlertFiring, start))
+		manager.Tick(start)
+		Expect(notification.messages).To(HaveLen(2))
+		Expect(notification.messages[0]).To(HavePrefix("2 alerts firing, 0 resolved for group=/api\n"))
+		Expect(notification.messages[1]).To(HavePrefix("High traffic on /users generated an alert"))
+	})
+
+	It(`waits for more alerts of a group before the first notification`, func() {
+		manager := NewAlertManager(notification, GroupWait(30*time.Second), GroupInterval(time.Minute))
+		manager.SendTransition(transition("traffic", "/api", AlertFiring, start))
+		manager.Tick(start.Add(10 * time.Second))
+		manager.SendTransition(transition("traffic", "/users", AlertFiring, start.Add(20*time.Second)))
+		manager.Tick(start.Add(20 * time.Second))
+		Expect(notification.messages).To(BeEmpty())
+		manager.Tick(start.Add(30 * time.Second))
+		Expect(notification.messages).To(HaveLen(1))
+		Expect(notification.message).To(HavePrefix("2 alerts firing, 0 resolved\n"))
+
+		manager.SendTransition(transition("traffic", "/api", AlertResolved, start.Add(40*time.Second)))
+		manager.Tick(start.Add(80 * time.Second))
+		Expect(notification.messages).To(HaveLen(1))
+		manager.Tick(start.Add(90 * time.Second))
+		Expect(notification.messages).To(HaveLen(2))
+		Expect(notification.message).To(Equal("1 alerts firing, 1 resolved\n" +
+			"High traffic on /users generated an alert, triggered at 2016-03-01 12:00:20 +0000 UTC\n" +
+			"Traffic on /api returned to normal, triggered at 2016-03-01 12:00:40 +0000 UTC\n",
+		))
+	})
+
+	It(`flushes the alerts still waiting in their groups`, func() {
+		manager := NewAlertManager(notification, ManagerGroupBy("alert"), GroupWait(time.Minute))
+		manager.SendTransition(transition("traffic", "", AlertFiring, start))
+		manager.SendTransition(transition("error-rate", "", AlertFiring, start))
+		manager.Tick(start.Add(time.Second))
+		Expect(notification.messages).To(BeEmpty())
+
+		manager.Flush()
+		Expect(notification.messages).To(Equal([]string{
+			"High error-rate generated an alert, triggered at 2016-03-01 12:00:00 +0000 UTC\n",
+			"High traffic generated an alert, triggered at 2016-03-01 12:00:00 +0000 UTC\n",
+		}))
+		manager.Flush()
+		Expect(notification.messages).To(HaveLen(2))
+	})
+
+	It(`dedupes repeats and reminds of the alerts still firing`, func() {
+		manager := NewAlertManager(notification, RepeatInterval(time.Hour))
+		manager.SendTransition(transition("traffic", "", AlertFiring, start))
+		manager.Tick(start)
+		manager.SendTransition(transition("traffic", "", AlertFiring, start.Add(time.Minute)))
+		manager.Tick(start.Add(59 * time.Minute))
+		Expect(notification.messages).To(HaveLen(1))
+
+		manager.Tick(start.Add(time.Hour))
+		Expect(notification.messages).To(HaveLen(2))
+		Expect(notification.message).To(Equal("Reminder: 1 alerts still firing\nHigh traffic generated an alert, triggered at 2016-03-01 12:00:00 +0000 UTC\n"))
+		Expect(manager.Records()[1].Outcome).To(Equal(AlertDeduplicated))
+	})
+
+	It(`silences the matching alerts for the time of the silence and still records them`, func() {
+		manager := NewAlertManager(notification)
+		id, err := manager.AddSilence(Silence{Matchers: []string{"group=/api", "alert!=traffic"}, StartsAt: start, EndsAt: start.Add(time.Hour)})
+		Expect(err).ToNot(HaveOccurred())
+
+		manager.SendTransition(transition("error-rate", "/api", AlertFiring, start))
+		manager.SendTransition(transition("traffic", "/api", AlertFiring, start))
+		manager.Tick(start)
+		Expect(notification.messages).To(Equal([]string{"High traffic on /api generated an alert, triggered at 2016-03-01 12:00:00 +0000 UTC\n"}))
+
+		manager.SendTransition(transition("error-rate", "/users", AlertFiring, start.Add(time.Hour)))
+		manager.SendTransition(transition("error-rate", "/api", AlertFiring, start.Add(time.Hour)))
+		manager.Tick(start.Add(time.Hour))
+		Expect(notification.messages).To(HaveLen(2))
+		Expect(notification.message).To(HavePrefix("3 alerts firing, 0 resolved\n"))
+
+		records := manager.Records()
+		Expect(records).To(HaveLen(4))
+		Expect(records[0].Outcome).To(Equal(AlertSilenced))
+		Expect(records[0].Silence).To(Equal(id))
+		Expect(records[1].Outcome).To(Equal(AlertNotified))
+	})
+
+	It(`does not remind of the silenced alerts`, func() {
+		manager := NewAlertManager(notification, RepeatInterval(time.Hour))
+		manager.SendTransition(transition("traffic", "", AlertFiring, start))
+		manager.Tick(start)
+		_, err := manager.AddSilence(Silence{Matchers: []string{"alert=traffic"}, StartsAt: start, EndsAt: start.Add(90 * time.Minute)})
+		Expect(err).ToNot(HaveOccurred())
+
+		manager.Tick(start.Add(time.Hour))
+		Expect(notification.messages).To(HaveLen(1))
+		manager.Tick(start.Add(2 * time.Hour))
+		Expect(notification.messages).To(HaveLen(2))
+		Expect(notification.message).To(HavePrefix("Reminder: 1 alerts still firing\n"))
+	})
+
+	It(`forgets the alerts that resolve while they are silenced`, func() {
+		manager := NewAlertManager(notification, RepeatInterval(time.Hour))
+		manager.SendTransition(transition("traffic", "", AlertFiring, start))
+		manager.Tick(start)
+		_, err := manager.AddSilence(Silence{Matchers: []string{"alert=traffic"}, StartsAt: start, EndsAt: start.Add(90 * time.Minute)})
+		Expect(err).ToNot(HaveOccurred())
+
+		manager.SendTransition(transition("traffic", "", AlertResolved, start.Add(30*time.Minute)))
+		manager.Tick(start.Add(30 * time.Minute))
+		Expect(manager.Records()[1].Outcome).To(Equal(AlertSilenced))
+		for _, at := range []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour} {
+			manager.Tick(start.Add(at))
+		}
+		Expect(notification.messages).To(HaveLen(1))
+
+		manager.SendTransition(transition("traffic", "", AlertFiring, start.Add(4*time.Hour)))
+		manager.Tick(start.Add(4 * time.Hour))
+		Expect(notification.messages).To(HaveLen(2))
+		Expect(notification.message).To(HavePrefix("High traffic generated an alert"))
+	})
+
+	It(`rejects invalid silences`, func() {
+		manager := NewAlertManager(notification)
+		_, err := manager.AddSilence(Silence{Matchers: []string{"colour=red"}, StartsAt: start, EndsAt: start.Add(time.Hour)})
+		Expect(err).To(MatchError("unknown alert label colour; expected alert, group or subject"))
+		_, err = manager.AddSilence(Silence{Matchers: []string{"group"}, StartsAt: start, EndsAt: start.Add(time.Hour)})
+		Expect(err).To(MatchError("invalid silence matcher group; expected label=value or label!=value"))
+		_, err = manager.AddSilence(Silence{Matchers: []string{"group=/api"}, StartsAt: start, EndsAt: start})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It(`records the transitions that do not notify`, func() {
+		manager := NewAlertManager(notification, MaxAlertRecords(2))
+		manager.SendTransition(transition("traffic", "", AlertPending, start))
+		manager.SendTransition(transition("traffic", "", AlertFiring, start))
+		manager.SendTransition(transition("traffic", "", AlertResolved, start))
+		records := manager.Records()
+		Expect(records).To(HaveLen(2))
+		Expect(records[0].Transition.To).To(Equal(AlertFiring))
+		Expect(records[1].Transition.To).To(Equal(AlertResolved))
+	})
+
+	Describe(`#Handler`, func() {
+		var (
+			clock   *FakeClock
+			manager *AlertManager
+			server  *httptest.Server
+		)
+
+		BeforeEach(func() {
+			clock = NewFakeClock(start)
+			manager = NewAlertManager(notification, ManagerClock(clock))
+			server = httptest.NewServer(manager.Handler())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It(`adds, lists and expires silences`, func() {
+			response, err := http.Post(server.URL+"/silences", "application/json", strings.NewReader(`{"matchers": ["group=/api"], "duration": "2h", "comment": "deploy"}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusCreated))
+			response.Body.Close()
+
+			response, err = http.Get(server.URL + "/silences")
+			Expect(err).ToNot(HaveOccurred())
+			var silences []Silence
+			Expect(json.NewDecoder(response.Body).Decode(&silences)).To(Succeed())
+			response.Body.Close()
+			Expect(silences).To(HaveLen(1))
+			Expect(silences[0].ID).To(Equal("1"))
+			Expect(silences[0].EndsAt).To(Equal(start.Add(2 * time.Hour)))
+
+			manager.SendTransition(transition("traffic", "/api", AlertFiring, start))
+			Expect(manager.Records()[0].Outcome).To(Equal(AlertSilenced))
+
+			clock.Advance(time.Minute)
+			request, _ := http.NewRequest(http.MethodDelete, server.URL+"/silences/1", nil)
+			response, err = http.DefaultClient.Do(request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusNoContent))
+			Expect(manager.Silences()[0].EndsAt).To(Equal(start.Add(time.Minute)))
+
+			manager.SendTransition(transition("error-rate", "/api", AlertFiring, start.Add(time.Minute)))
+			Expect(manager.Records()[1].Outcome).To(Equal(AlertNotified))
+		})
+
+		It(`rejects invalid silences`, func() {
+			response, err := http.Post(server.URL+"/silences", "application/json", strings.NewReader(`{"matchers": ["colour=red"], "duration": "2h"}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+
+		It(`lists the alert records`, func() {
+			manager.SendTransition(transition("traffic", "", AlertFiring, start))
+			response, err := http.Get(server.URL + "/alerts")
+			Expect(err).ToNot(HaveOccurred())
+			var records []AlertRecord
+			Expect(json.NewDecoder(response.Body).Decode(&records)).To(Succeed())
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].Transition.To).To(Equal(AlertFiring))
+			Expect(records[0].Outcome).To(Equal(AlertNotified))
+		})
+	})
+})
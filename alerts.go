@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -9,52 +11,509 @@ type Alert interface {
 	Check(Event)
 }
 
+type TickingAlert interface {
+	Alert
+	Tick(time.Time)
+}
+
+type AlertOption func(*alertOptions)
+
+type alertOptions struct {
+	clock      Clock
+	label      string
+	pendingFor time.Duration
+	minFiring  time.Duration
+	clearRatio float64
+	resolution time.Duration
+}
+
+func AlertClock(clock Clock) AlertOption {
+	return func(a *alertOptions) {
+		a.clock = clock
+	}
+}
+
+func AlertLabel(label string) AlertOption {
+	return func(a *alertOptions) {
+		a.label = label
+	}
+}
+
+func AlertFor(duration time.Duration) AlertOption {
+	return func(a *alertOptions) {
+		a.pendingFor = duration
+	}
+}
+
+func AlertMinFiring(duration time.Duration) AlertOption {
+	return func(a *alertOptions) {
+		a.minFiring = duration
+	}
+}
+
+func AlertHysteresis(clearRatio float64) AlertOption {
+	return func(a *alertOptions) {
+		a.clearRatio = clearRatio
+	}
+}
+
+func AlertResolution(resolution time.Duration) AlertOption {
+	return func(a *alertOptions) {
+		a.resolution = resolution
+	}
+}
+
+func newAlertOptions(options []AlertOption) alertOptions {
+	alertOptions := alertOptions{clock: NewEventClock(), clearRatio: 1, resolution: DefaultCounterResolution}
+	for _, option := range options {
+		option(&alertOptions)
+	}
+	return alertOptions
+}
+
+type GroupedAlert struct {
+	key       EventKey
+	newAlert  NewAlertFn
+	maxGroups int
+
+	alerts    map[string]Alert
+	lastSeen  map[string]uint64
+	checked   uint64
+	untracked uint64
+}
+
+type NewAlertFn func(group string) Alert
+
+type GroupedAlertOption func(*GroupedAlert)
+
+func MaxGroups(maxGroups int) GroupedAlertOption {
+	return func(g *GroupedAlert) {
+		g.maxGroups = maxGroups
+	}
+}
+
+func NewGroupedAlert(key EventKey, fn NewAlertFn, options ...GroupedAlertOption) *GroupedAlert {
+	alert := &GroupedAlert{
+		key:      key,
+		newAlert: fn,
+		alerts:   map[string]Alert{},
+		lastSeen: map[string]uint64{},
+	}
+	for _, option := range options {
+		option(alert)
+	}
+	return alert
+}
+
+func NewKeyedTrafficAlert(key EventKey, hits int, duration time.Duration, notification Notification, maxGroups int, options ...AlertOption) *GroupedAlert {
+	return NewGroupedAlert(key, func(group string) Alert {
+		groupOptions := append([]AlertOption{AlertLabel(group)}, options...)
+		return NewTotalTrafficAlert(hits, duration, notification, groupOptions...)
+	}, MaxGroups(maxGroups))
+}
+
+func (g *GroupedAlert) Check(event Event) {
+	group := g.key(event)
+	if group == "" {
+		return
+	}
+	alert, ok := g.alerts[group]
+	if !ok {
+		if g.maxGroups > 0 && len(g.alerts) >= g.maxGroups && !g.evictIdleGroup() {
+			g.untracked++
+			return
+		}
+		alert = g.newAlert(group)
+		g.alerts[group] = alert
+	}
+	g.checked++
+	g.lastSeen[group] = g.checked
+	alert.Check(event)
+}
+
+func (g *GroupedAlert) Tick(now time.Time) {
+	for _, alert := range g.alerts {
+		if ticking, ok := alert.(TickingAlert); ok {
+			ticking.Tick(now)
+		}
+	}
+}
+
+func (g *GroupedAlert) Groups() int {
+	return len(g.alerts)
+}
+
+func (g *GroupedAlert) Untracked() uint64 {
+	return g.untracked
+}
+
+func (g *GroupedAlert) evictIdleGroup() bool {
+	idle, idleSeen := "", uint64(0)
+	for group, alert := range g.alerts {
+		if triggered, ok := alert.(interface{ Triggered() bool }); ok && triggered.Triggered() {
+			continue
+		}
+		if idle == "" || g.lastSeen[group] < idleSeen {
+			idle, idleSeen = group, g.lastSeen[group]
+		}
+	}
+	if idle == "" {
+		return false
+	}
+	delete(g.alerts, idle)
+	delete(g.lastSeen, idle)
+	return true
+}
+
 type TotalTrafficAlert struct {
-	hits         int
-	duration     time.Duration
-	notification Notification
+	hits       int
+	clock      Clock
+	label      string
+	clearRatio float64
+	lifecycle  *AlertLifecycle
 
-	alertTriggered bool
-	events         []Event
+	events *WindowCounter
 }
 
-func NewTotalTrafficAlert(hits int, duration time.Duration, notification Notification) *TotalTrafficAlert {
+func NewTotalTrafficAlert(hits int, duration time.Duration, notification Notification, options ...AlertOption) *TotalTrafficAlert {
+	alertOptions := newAlertOptions(options)
 	return &TotalTrafficAlert{
-		hits:         hits,
-		duration:     duration,
-		notification: notification,
+		hits:       hits,
+		clock:      alertOptions.clock,
+		label:      alertOptions.label,
+		clearRatio: alertOptions.clearRatio,
+		lifecycle:  newAlertLifecycle("traffic", duration, notification, alertOptions),
+		events:     NewWindowCounter(duration, alertOptions.resolution),
 	}
 }
 
 func (t *TotalTrafficAlert) Check(event Event) {
-	t.add(event)
+	if observer, ok := t.clock.(EventTimeObserver); ok {
+		observer.Observe(event.Time)
+	}
+	t.events.Add(event.Time, 1)
+
+	hits := int(t.events.Count(t.clock.Now()))
+	breached := hits > 0 && hits >= t.hits
+	cleared := float64(hits) < float64(t.hits)*t.clearRatio
+	t.lifecycle.Evaluate(event.Time, breached, cleared, func(transition *AlertTransition) {
+		transition.Subject = labeled("traffic", t.label)
+		transition.Condition = "High " + transition.Subject
+		if transition.To != AlertResolved {
+			transition.Details = fmt.Sprintf("hits = %d", hits)
+		}
+		transition.Value, transition.Threshold = float64(hits), float64(t.hits)
+	})
+}
+
+func (t *TotalTrafficAlert) Triggered() bool {
+	return t.lifecycle.Firing()
+}
+
+func (t *TotalTrafficAlert) State() AlertState {
+	return t.lifecycle.State()
+}
+
+func labeled(subject, label string) string {
+	if label == "" {
+		return subject
+	}
+	return subject + " on " + label
+}
+
+type CompositeAlert []Alert
+
+func (c CompositeAlert) Check(event Event) {
+	for _, alert := range c {
+		alert.Check(event)
+	}
+}
+
+func (c CompositeAlert) Tick(now time.Time) {
+	for _, alert := range c {
+		if ticking, ok := alert.(TickingAlert); ok {
+			ticking.Tick(now)
+		}
+	}
+}
+
+type StatusMatcher struct {
+	spec    string
+	classes map[string]bool
+	codes   map[int]bool
+}
+
+func ParseStatusMatcher(spec string) (*StatusMatcher, error) {
+	matcher := &StatusMatcher{spec: spec, classes: map[string]bool{}, codes: map[int]bool{}}
+	for _, status := range strings.Split(spec, ",") {
+		status = strings.ToLower(strings.TrimSpace(status))
+		if len(status) == 3 && strings.HasSuffix(status, "xx") && status[0] >= '1' && status[0] <= '5' {
+			matcher.classes[status] = true
+			continue
+		}
+		statusCode, err := strconv.Atoi(status)
+		if err != nil || statusCode < 100 || statusCode > 599 {
+			return nil, fmt.Errorf("invalid status class or code: %s", status)
+		}
+		matcher.codes[statusCode] = true
+	}
+	return matcher, nil
+}
+
+func (s *StatusMatcher) Match(statusCode int) bool {
+	return s.codes[statusCode] || s.classes[StatusClass(statusCode)]
+}
+
+func (s *StatusMatcher) String() string {
+	return s.spec
+}
+
+type ErrorRateAlert struct {
+	statuses    *StatusMatcher
+	rate        float64
+	minRequests int
+	duration    time.Duration
+	clock       Clock
+	label       string
+	clearRatio  float64
+	lifecycle   *AlertLifecycle
+
+	requests *WindowCounter
+	errors   *WindowCounter
+}
 
-	if t.isExceeded() && !t.alertTriggered {
-		t.notification.Send(fmt.Sprintf("High traffic generated an alert - hits = %d, triggered at %s\n", len(t.events), event.Time.String()))
-		t.alertTriggered = true
-	} else if !t.isExceeded() && t.alertTriggered {
-		reason := fmt.Sprintf("Traffic returned to normal, triggered at %s\n", event.Time.String())
-		t.notification.Send(reason)
-		t.alertTriggered = false
+func NewErrorRateAlert(statuses *StatusMatcher, rate float64, minRequests int, duration time.Duration, notification Notification, options ...AlertOption) *ErrorRateAlert {
+	alertOptions := newAlertOptions(options)
+	return &ErrorRateAlert{
+		statuses:    statuses,
+		rate:        rate,
+		minRequests: minRequests,
+		duration:    duration,
+		clock:       alertOptions.clock,
+		label:       alertOptions.label,
+		clearRatio:  alertOptions.clearRatio,
+		lifecycle:   newAlertLifecycle("error-rate", duration, notification, alertOptions),
+		requests:    NewWindowCounter(duration, alertOptions.resolution),
+		errors:      NewWindowCounter(duration, alertOptions.resolution),
 	}
 }
 
-func (t *TotalTrafficAlert) add(event Event) {
-	t.events = append(t.events, event)
-	t.pruneUpTo(event.Time)
+func (e *ErrorRateAlert) Check(event Event) {
+	if observer, ok := e.clock.(EventTimeObserver); ok {
+		observer.Observe(event.Time)
+	}
+	e.requests.Add(event.Time, 1)
+	if e.statuses.Match(event.StatusCode) {
+		e.errors.Add(event.Time, 1)
+	}
+
+	now := e.clock.Now()
+	requests, errors := int(e.requests.Count(now)), int(e.errors.Count(now))
+	errorRate := 0.0
+	if requests > 0 {
+		errorRate = float64(errors) / float64(requests)
+	}
+	breached := requests >= e.minRequests && requests > 0 && errorRate > e.rate
+	cleared := errorRate <= e.rate*e.clearRatio
+	e.lifecycle.Evaluate(event.Time, breached, cleared, func(transition *AlertTransition) {
+		transition.Subject = labeled("error rate", e.label)
+		transition.Condition = "High " + transition.Subject
+		transition.Details = fmt.Sprintf("errors = %d of %d requests (%.1f%%)", errors, requests, errorRate*100)
+		transition.Value, transition.Threshold = errorRate, e.rate
+	})
+}
+
+func (e *ErrorRateAlert) Triggered() bool {
+	return e.lifecycle.Firing()
+}
+
+type LatencyAlert struct {
+	quantile   float64
+	slo        time.Duration
+	clock      Clock
+	label      string
+	clearRatio float64
+	lifecycle  *AlertLifecycle
+
+	latencies *SlidingSketch
+}
+
+func NewLatencyAlert(quantile float64, slo time.Duration, window time.Duration, notification Notification, options ...AlertOption) *LatencyAlert {
+	alertOptions := newAlertOptions(options)
+	return &LatencyAlert{
+		quantile:   quantile,
+		slo:        slo,
+		clock:      alertOptions.clock,
+		label:      alertOptions.label,
+		clearRatio: alertOptions.clearRatio,
+		lifecycle:  newAlertLifecycle("latency", window, notification, alertOptions),
+		latencies:  NewSlidingSketch(window, 12, DefaultSketchAccuracy, DefaultSketchMaxBuckets),
+	}
+}
+
+func (l *LatencyAlert) Check(event Event) {
+	if event.Duration <= 0 {
+		return
+	}
+	if observer, ok := l.clock.(EventTimeObserver); ok {
+		observer.Observe(event.Time)
+	}
+	l.latencies.Add(event.Time, float64(event.Duration))
+	window := l.latencies.Window(l.clock.Now())
+	latency := time.Duration(window.Quantile(l.quantile))
+
+	breached := latency > l.slo
+	cleared := float64(latency) <= float64(l.slo)*l.clearRatio
+	l.lifecycle.Evaluate(event.Time, breached, cleared, func(transition *AlertTransition) {
+		name := QuantileName(l.quantile)
+		transition.Subject = labeled(name+" latency", l.label)
+		transition.Condition = "High " + transition.Subject
+		transition.Details = fmt.Sprintf("%s = %s over %d requests", name, latency.Round(time.Millisecond), window.Count())
+		transition.Value, transition.Threshold = latency.Seconds(), l.slo.Seconds()
+	})
+}
+
+func (l *LatencyAlert) Triggered() bool {
+	return l.lifecycle.Firing()
 }
 
-func (t *TotalTrafficAlert) isExceeded() bool {
-	return len(t.events) > 0 && len(t.events) >= t.hits
+func QuantileName(quantile float64) string {
+	return "p" + strconv.FormatFloat(quantile*100, 'g', -1, 64)
 }
 
-func (t *TotalTrafficAlert) pruneUpTo(currentTime time.Time) {
-	var lastGreatestIndex int
-	for i := len(t.events) - 1; i >= 0; i-- {
-		if currentTime.Sub(t.events[i].Time) > t.duration {
-			lastGreatestIndex = i + 1
-			break
+type ClientRateAlert struct {
+	key          EventKey
+	hits         uint64
+	top          int
+	window       time.Duration
+	capacity     int
+	notification Notification
+	options      alertOptions
+
+	clients   *SlidingHeavyHitters
+	offenders map[string]*AlertLifecycle
+}
+
+func NewClientRateAlert(key EventKey, hits int, window time.Duration, capacity int, top int, notification Notification, options ...AlertOption) *ClientRateAlert {
+	return &ClientRateAlert{
+		key:          key,
+		hits:         uint64(hits),
+		top:          top,
+		window:       window,
+		capacity:     capacity,
+		notification: notification,
+		options:      newAlertOptions(options),
+		clients:      NewSlidingHeavyHitters(window, 6, capacity),
+		offenders:    map[string]*AlertLifecycle{},
+	}
+}
+
+func (c *ClientRateAlert) Check(event Event) {
+	client := c.key(event)
+	if client == "" {
+		return
+	}
+	if observer, ok := c.options.clock.(EventTimeObserver); ok {
+		observer.Observe(event.Time)
+	}
+	c.clients.Add(event.Time, client)
+	now := c.options.clock.Now()
+
+	if _, ok := c.offenders[client]; !ok && len(c.offenders) < c.capacity && c.clients.Guaranteed(now, client) >= c.hits {
+		offenderOptions := c.options
+		offenderOptions.label = client
+		c.offenders[client] = newAlertLifecycle("client-rate", c.window, c.notification, offenderOptions)
+	}
+	for offender, lifecycle := range c.offenders {
+		hits := c.clients.Guaranteed(now, offender)
+		lifecycle.Evaluate(event.Time, hits >= c.hits, float64(hits) < float64(c.hits)*c.options.clearRatio, func(transition *AlertTransition) {
+			transition.Subject = "traffic from " + offender
+			transition.Condition = "High " + transition.Subject
+			if transition.To != AlertResolved {
+				transition.Details = fmt.Sprintf("hits = %d, top offenders: %s", hits, c.topOffenders(now))
+			}
+			transition.Value, transition.Threshold = float64(hits), float64(c.hits)
+		})
+		if state := lifecycle.State(); state == AlertInactive || state == AlertResolved {
+			delete(c.offenders, offender)
 		}
 	}
-	t.events = t.events[lastGreatestIndex:]
+}
+
+func (c *ClientRateAlert) Triggered() bool {
+	for _, lifecycle := range c.offenders {
+		if lifecycle.Firing() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ClientRateAlert) topOffenders(now time.Time) string {
+	top := c.clients.Top(now, c.top)
+	offenders := make([]string, len(top))
+	for i, offender := range top {
+		offenders[i] = fmt.Sprintf("%s = %d", offender.Key, offender.Count)
+	}
+	return strings.Join(offenders, ", ")
+}
+
+type AbsenceAlert struct {
+	timeout   time.Duration
+	filter    Filter
+	clock     Clock
+	label     string
+	lifecycle *AlertLifecycle
+
+	lastSeen time.Time
+}
+
+func NewAbsenceAlert(timeout time.Duration, filter Filter, notification Notification, options ...AlertOption) *AbsenceAlert {
+	alertOptions := newAlertOptions(options)
+	return &AbsenceAlert{
+		timeout:   timeout,
+		filter:    filter,
+		clock:     alertOptions.clock,
+		label:     alertOptions.label,
+		lifecycle: newAlertLifecycle("absence", timeout, notification, alertOptions),
+	}
+}
+
+func (a *AbsenceAlert) Check(event Event) {
+	if a.filter != nil && !a.filter.Allow(event) {
+		return
+	}
+	now := a.clock.Now()
+	if _, ok := a.clock.(EventTimeObserver); ok {
+		now = event.Time
+	}
+	silence := now.Sub(a.lastSeen)
+	a.lifecycle.Evaluate(now, false, true, func(transition *AlertTransition) {
+		a.describe(transition, fmt.Sprintf("events resumed after %s", silence), silence)
+	})
+	if now.After(a.lastSeen) {
+		a.lastSeen = now
+	}
+}
+
+func (a *AbsenceAlert) Tick(now time.Time) {
+	if a.lastSeen.IsZero() {
+		a.lastSeen = now
+		return
+	}
+	silence := now.Sub(a.lastSeen)
+	a.lifecycle.Evaluate(now, silence >= a.timeout, silence < a.timeout, func(transition *AlertTransition) {
+		a.describe(transition, fmt.Sprintf("no events for %s", silence), silence)
+	})
+}
+
+func (a *AbsenceAlert) Triggered() bool {
+	return a.lifecycle.Firing()
+}
+
+func (a *AbsenceAlert) describe(transition *AlertTransition, details string, silence time.Duration) {
+	transition.Subject = labeled("traffic", a.label)
+	transition.Condition = "No " + transition.Subject
+	transition.Details = details
+	transition.Value, transition.Threshold = silence.Seconds(), a.timeout.Seconds()
 }
@@ -28,7 +28,7 @@ var _ = Describe(`TotalTrafficAlert`, func() {
 			var currentTime, twoMinutesAgo time.Time
 
 			BeforeEach(func() {
-				currentTime = time.Now()
+				currentTime = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
 				twoMinutesAgo = currentTime.Add(-2 * time.Minute)
 			})
 
@@ -47,7 +47,7 @@ var _ = Describe(`TotalTrafficAlert`, func() {
 			var currentTime, fourMinutesAgo time.Time
 
 			BeforeEach(func() {
-				currentTime = time.Now()
+				currentTime = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
 				fourMinutesAgo = currentTime.Add(-4 * time.Minute)
 			})
 
@@ -65,7 +65,7 @@ var _ = Describe(`TotalTrafficAlert`, func() {
 			var currentTime, threeMinutesAgo, fourMinutesAgo time.Time
 
 			BeforeEach(func() {
-				currentTime = time.Now()
+				currentTime = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
 				threeMinutesAgo = currentTime.Add(-3 * time.Minute)
 				fourMinutesAgo = currentTime.Add(-4 * time.Minute)
 			})
@@ -85,4 +85,405 @@ var _ = Describe(`TotalTrafficAlert`, func() {
 			})
 		})
 	})
+
+	Context(`with a clock`, func() {
+		var (
+			clock       *FakeClock
+			currentTime time.Time
+		)
+
+		BeforeEach(func() {
+			notification = new(notificationMock)
+			currentTime = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+			clock = NewFakeClock(currentTime)
+		})
+
+		JustBeforeEach(func() {
+			alert = NewTotalTrafficAlert(2, 2*time.Minute, notification, AlertClock(clock))
+		})
+
+		It(`counts the events within the duration before the clock time`, func() {
+			alert.Check(Event{Time: currentTime.Add(-3 * time.Minute)})
+			alert.Check(Event{Time: currentTime.Add(-3 * time.Minute)})
+			Expect(notification.message).To(BeEmpty())
+
+			alert.Check(Event{Time: currentTime.Add(-1 * time.Minute)})
+			alert.Check(Event{Time: currentTime})
+			Expect(notification.message).To(HavePrefix("High traffic generated an alert - hits = 2"))
+		})
+	})
+})
+
+var _ = Describe(`GroupedAlert`, func() {
+	var (
+		alert         Alert
+		notifications map[string]*notificationMock
+	)
+
+	BeforeEach(func() {
+		notifications = map[string]*notificationMock{}
+		alert = NewGroupedAlert(ASNKey, func(group string) Alert {
+			notifications[group] = new(notificationMock)
+			return NewTotalTrafficAlert(2, 2*time.Minute, notifications[group])
+		})
+	})
+
+	Describe(`#Check`, func() {
+		var currentTime time.Time
+
+		BeforeEach(func() {
+			currentTime = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+		})
+
+		JustBeforeEach(func() {
+			alert.Check(Event{Time: currentTime, ASN: 15169})
+			alert.Check(Event{Time: currentTime, ASN: 15169})
+			alert.Check(Event{Time: currentTime, ASN: 7018})
+			alert.Check(Event{Time: currentTime})
+		})
+
+		It(`alerts on the traffic of each group separately`, func() {
+			Expect(notifications).To(HaveLen(2))
+			Expect(notifications["asn:15169"].message).To(Equal(fmt.Sprintf("High traffic generated an alert - hits = 2, triggered at %s\n", currentTime.String())))
+			Expect(notifications["asn:7018"].message).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe(`NewKeyedTrafficAlert`, func() {
+	var (
+		alert        *GroupedAlert
+		notification *notificationMock
+		currentTime  time.Time
+	)
+
+	BeforeEach(func() {
+		notification = new(notificationMock)
+		alert = NewKeyedTrafficAlert(SectionKey, 2, 2*time.Minute, notification, 2)
+		currentTime = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	It(`fires and resolves each section independently`, func() {
+		alert.Check(Event{Time: currentTime, Path: "/api/users"})
+		alert.Check(Event{Time: currentTime, Path: "/api/orders"})
+		alert.Check(Event{Time: currentTime, Path: "/static/app.js"})
+		Expect(notification.messages).To(Equal([]string{
+			fmt.Sprintf("High traffic on /api generated an alert - hits = 2, triggered at %s\n", currentTime.String()),
+		}))
+
+		later := currentTime.Add(3 * time.Minute)
+		alert.Check(Event{Time: later, Path: "/api/users"})
+		Expect(notification.messages).To(HaveLen(2))
+		Expect(notification.message).To(Equal(fmt.Sprintf("Traffic on /api returned to normal, triggered at %s\n", later.String())))
+	})
+
+	It(`makes room for a new section by evicting the least recently seen quiet one`, func() {
+		alert.Check(Event{Time: currentTime, Path: "/api/users"})
+		alert.Check(Event{Time: currentTime, Path: "/static/app.js"})
+		alert.Check(Event{Time: currentTime, Path: "/api/orders"})
+		alert.Check(Event{Time: currentTime, Path: "/images/logo.png"})
+		Expect(alert.Groups()).To(Equal(2))
+		Expect(alert.Untracked()).To(BeZero())
+
+		alert.Check(Event{Time: currentTime, Path: "/static/app.css"})
+		Expect(notification.messages).To(HaveLen(1))
+	})
+
+	It(`drops events of new sections while every tracked section is alerting`, func() {
+		for _, path := range []string{"/api/users", "/api/orders", "/static/app.js", "/static/app.css"} {
+			alert.Check(Event{Time: currentTime, Path: path})
+		}
+		alert.Check(Event{Time: currentTime, Path: "/images/logo.png"})
+		Expect(alert.Groups()).To(Equal(2))
+		Expect(alert.Untracked()).To(Equal(uint64(1)))
+		Expect(notification.messages).To(HaveLen(2))
+	})
+})
+
+var _ = Describe(`ErrorRateAlert`, func() {
+	var (
+		alert        *ErrorRateAlert
+		notification *notificationMock
+		currentTime  time.Time
+	)
+
+	BeforeEach(func() {
+		statuses, err := ParseStatusMatcher("5xx,429")
+		Expect(err).NotTo(HaveOccurred())
+		notification = new(notificationMock)
+		alert = NewErrorRateAlert(statuses, 0.5, 4, 2*time.Minute, notification)
+		currentTime = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	check := func(at time.Time, statusCodes ...int) {
+		for _, statusCode := range statusCodes {
+			alert.Check(Event{Time: at, StatusCode: statusCode})
+		}
+	}
+
+	It(`does not alert before the window has the minimum number of requests`, func() {
+		check(currentTime, 500, 503, 429)
+		Expect(notification.messages).To(BeEmpty())
+	})
+
+	It(`alerts when the fraction of errors is above the threshold and resolves with the counts`, func() {
+		check(currentTime, 200, 500, 503, 429)
+		Expect(notification.messages).To(Equal([]string{
+			fmt.Sprintf("High error rate generated an alert - errors = 3 of 4 requests (75.0%%), triggered at %s\n", currentTime.String()),
+		}))
+		Expect(alert.Triggered()).To(BeTrue())
+
+		check(currentTime, 200, 404)
+		Expect(notification.message).To(Equal(fmt.Sprintf("Error rate returned to normal - errors = 3 of 6 requests (50.0%%), triggered at %s\n", currentTime.String())))
+		Expect(alert.Triggered()).To(BeFalse())
+	})
+
+	It(`forgets the errors that leave the window`, func() {
+		check(currentTime, 500, 500, 500, 200)
+		later := currentTime.Add(3 * time.Minute)
+		check(later, 200)
+		Expect(notification.message).To(Equal(fmt.Sprintf("Error rate returned to normal - errors = 0 of 1 requests (0.0%%), triggered at %s\n", later.String())))
+	})
+
+	It(`names the group it alerts on`, func() {
+		statuses, _ := ParseStatusMatcher("5xx")
+		alert = NewErrorRateAlert(statuses, 0.5, 1, 2*time.Minute, notification, AlertLabel("/api"))
+		check(currentTime, 502)
+		Expect(notification.message).To(HavePrefix("High error rate on /api generated an alert - errors = 1 of 1 requests"))
+	})
+})
+
+var _ = Describe(`ParseStatusMatcher`, func() {
+	It(`matches status classes and codes`, func() {
+		statuses, err := ParseStatusMatcher("4xx, 503")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(statuses.Match(404)).To(BeTrue())
+		Expect(statuses.Match(503)).To(BeTrue())
+		Expect(statuses.Match(500)).To(BeFalse())
+		Expect(statuses.Match(200)).To(BeFalse())
+	})
+
+	It(`rejects what is neither a status class nor a code`, func() {
+		_, err := ParseStatusMatcher("5xx,oops")
+		Expect(err).To(MatchError("invalid status class or code: oops"))
+		_, err = ParseStatusMatcher("9xx")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe(`CompositeAlert`, func() {
+	It(`checks each event against every alert`, func() {
+		first, second := new(notificationMock), new(notificationMock)
+		alert := CompositeAlert{NewTotalTrafficAlert(1, time.Minute, first), NewTotalTrafficAlert(1, time.Minute, second)}
+		alert.Check(Event{Time: time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)})
+		Expect(first.messages).To(HaveLen(1))
+		Expect(second.messages).To(HaveLen(1))
+	})
+})
+
+var _ = Describe(`LatencyAlert`, func() {
+	var (
+		alert        *LatencyAlert
+		notification *notificationMock
+		currentTime  time.Time
+	)
+
+	BeforeEach(func() {
+		notification = new(notificationMock)
+		alert = NewLatencyAlert(0.95, 500*time.Millisecond, time.Minute, notification, AlertFor(2*time.Minute))
+		currentTime = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	check := func(from time.Time, minutes int, latency time.Duration) time.Time {
+		at := from
+		for i := 0; i < minutes*6; i++ {
+			at = at.Add(10 * time.Second)
+			alert.Check(Event{Time: at, Duration: latency})
+		}
+		return at
+	}
+
+	It(`alerts once the quantile stays above the SLO long enough and resolves when it drops`, func() {
+		at := check(currentTime, 1, 100*time.Millisecond)
+		at = check(at, 1, 800*time.Millisecond)
+		Expect(notification.messages).To(BeEmpty())
+
+		at = check(at, 2, 800*time.Millisecond)
+		Expect(notification.messages).To(HaveLen(1))
+		Expect(notification.message).To(HavePrefix("High p95 latency generated an alert - p95 = 8"))
+		Expect(alert.Triggered()).To(BeTrue())
+
+		check(at, 2, 100*time.Millisecond)
+		Expect(notification.messages).To(HaveLen(2))
+		Expect(notification.message).To(HavePrefix("P95 latency returned to normal - p95 = 10"))
+	})
+
+	It(`starts over when the quantile dips under the SLO`, func() {
+		at := check(currentTime, 1, 800*time.Millisecond)
+		at = check(at, 1, 100*time.Millisecond)
+		check(at, 1, 800*time.Millisecond)
+		Expect(notification.messages).To(BeEmpty())
+	})
+
+	It(`ignores events without a request duration`, func() {
+		alert = NewLatencyAlert(0.99, 500*time.Millisecond, time.Minute, notification, AlertLabel("/api"))
+		alert.Check(Event{Time: currentTime})
+		Expect(notification.messages).To(BeEmpty())
+		alert.Check(Event{Time: currentTime, Duration: time.Second})
+		Expect(notification.message).To(HavePrefix("High p99 latency on /api generated an alert - p99 = 1s over 1 requests"))
+	})
+})
+
+var _ = Describe(`ClientRateAlert`, func() {
+	var (
+		alert        *ClientRateAlert
+		notification *notificationMock
+		currentTime  time.Time
+	)
+
+	BeforeEach(func() {
+		notification = new(notificationMock)
+		alert = NewClientRateAlert(ClientKey, 3, time.Minute, 100, 2, notification)
+		currentTime = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	It(`alerts on the clients going over the threshold with the top offenders`, func() {
+		for _, client := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.1"} {
+			alert.Check(Event{Time: currentTime, Client: client})
+		}
+		Expect(notification.messages).To(Equal([]string{
+			fmt.Sprintf("High traffic from client:10.0.0.1 generated an alert - hits = 3, top offenders: client:10.0.0.1 = 3, client:10.0.0.2 = 2, triggered at %s\n", currentTime.String()),
+		}))
+		Expect(alert.Triggered()).To(BeTrue())
+
+		later := currentTime.Add(2 * time.Minute)
+		alert.Check(Event{Time: later, Client: "10.0.0.2"})
+		Expect(notification.message).To(Equal(fmt.Sprintf("Traffic from client:10.0.0.1 returned to normal, triggered at %s\n", later.String())))
+		Expect(alert.Triggered()).To(BeFalse())
+	})
+
+	It(`does not alert on a flood of distinct clients`, func() {
+		alert = NewClientRateAlert(ClientKey, 100, time.Minute, 100, 2, notification)
+		for i := 0; i < 20000; i++ {
+			alert.Check(Event{Time: currentTime.Add(time.Duration(i) * time.Millisecond), Client: fmt.Sprintf("10.%d.%d.%d", i/65536, i/256%256, i%256)})
+		}
+		Expect(notification.messages).To(BeEmpty())
+		Expect(alert.Triggered()).To(BeFalse())
+	})
+
+	It(`still alerts on a heavy client among a flood of distinct clients`, func() {
+		alert = NewClientRateAlert(ClientKey, 100, time.Minute, 100, 2, notification)
+		for i := 0; i < 20000; i++ {
+			alert.Check(Event{Time: currentTime.Add(time.Duration(i) * time.Millisecond), Client: fmt.Sprintf("10.%d.%d.%d", i/65536, i/256%256, i%256)})
+			if i%10 == 0 {
+				alert.Check(Event{Time: currentTime.Add(time.Duration(i) * time.Millisecond), Client: "192.168.0.1"})
+			}
+		}
+		Expect(notification.messages).To(HaveLen(1))
+		Expect(notification.message).To(HavePrefix("High traffic from client:192.168.0.1 generated an alert"))
+	})
+
+	It(`tracks at most as many offenders as its capacity`, func() {
+		alert = NewClientRateAlert(ClientKey, 3, time.Minute, 2, 2, notification)
+		for i, client := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+			for j := 0; j < 3; j++ {
+				alert.Check(Event{Time: currentTime.Add(time.Duration(i) * 10 * time.Second), Client: client})
+			}
+		}
+		Expect(notification.messages).To(HaveLen(2))
+		Expect(notification.messages[1]).To(HavePrefix("High traffic from client:10.0.0.2 generated an alert"))
+	})
+
+	It(`counts each client and section separately when the keys are joined`, func() {
+		alert = NewClientRateAlert(JoinKeys(ClientKey, SectionKey), 2, time.Minute, 100, 2, notification)
+		alert.Check(Event{Time: currentTime, Client: "10.0.0.1", Path: "/product.screen?id=1"})
+		alert.Check(Event{Time: currentTime, Client: "10.0.0.1", Path: "/cart"})
+		Expect(notification.messages).To(BeEmpty())
+
+		alert.Check(Event{Time: currentTime, Client: "10.0.0.1", Path: "/product.screen?id=2"})
+		Expect(notification.message).To(HavePrefix("High traffic from client:10.0.0.1 /product.screen generated an alert - hits = 2"))
+	})
+})
+
+var _ = Describe(`AbsenceAlert`, func() {
+	var (
+		alert        *AbsenceAlert
+		notification *notificationMock
+		clock        *FakeClock
+		start        time.Time
+	)
+
+	BeforeEach(func() {
+		start = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+		clock = NewFakeClock(start)
+		notification = new(notificationMock)
+		alert = NewAbsenceAlert(time.Minute, nil, notification, AlertClock(clock))
+	})
+
+	It(`alerts once when no events are seen for the timeout and resolves when they come back`, func() {
+		alert.Check(Event{Time: start})
+		alert.Tick(start.Add(30 * time.Second))
+		Expect(notification.messages).To(BeEmpty())
+
+		alert.Tick(start.Add(time.Minute))
+		alert.Tick(start.Add(2 * time.Minute))
+		Expect(notification.messages).To(Equal([]string{
+			fmt.Sprintf("No traffic generated an alert - no events for 1m0s, triggered at %s\n", start.Add(time.Minute).String()),
+		}))
+
+		clock.Set(start.Add(3 * time.Minute))
+		alert.Check(Event{Time: start.Add(3 * time.Minute)})
+		Expect(notification.message).To(Equal(fmt.Sprintf("Traffic returned to normal - events resumed after 3m0s, triggered at %s\n", start.Add(3*time.Minute).String())))
+		Expect(alert.Triggered()).To(BeFalse())
+	})
+
+	It(`counts the silence from the first tick when no events were ever seen`, func() {
+		alert.Tick(start)
+		alert.Tick(start.Add(time.Minute))
+		Expect(notification.message).To(HavePrefix("No traffic generated an alert - no events for 1m0s"))
+	})
+
+	It(`only counts the events allowed by its filter`, func() {
+		filter, err := ParseKeyFilter("section=/api")
+		Expect(err).NotTo(HaveOccurred())
+		alert = NewAbsenceAlert(time.Minute, filter, notification, AlertClock(clock), AlertLabel("/api"))
+		alert.Check(Event{Time: start, Path: "/api/users"})
+		clock.Set(start.Add(time.Minute))
+		alert.Check(Event{Time: start.Add(time.Minute), Path: "/static/app.js"})
+		alert.Tick(start.Add(time.Minute))
+		Expect(notification.message).To(HavePrefix("No traffic on /api generated an alert - no events for 1m0s"))
+	})
+
+	It(`is ticked by the application while no events arrive`, func() {
+		messages := make(chan string, 10)
+		alert = NewAbsenceAlert(time.Minute, nil, NewNotificationSender(func(message string) {
+			messages <- message
+		}), AlertClock(clock))
+		reader := &channelLogReader{logs: make(chan Event)}
+		monitor := NewSummaryStatsTrafficMonitor(time.Hour, new(notificationMock), MonitorClock(clock))
+		app := NewApplication(reader, monitor, CompositeAlert{alert})
+		app.SetClock(clock)
+		app.SetAlertTick(10 * time.Second)
+		done := make(chan struct{})
+		go func() {
+			app.Run()
+			close(done)
+		}()
+
+		reader.logs <- Event{Time: start}
+		var message string
+		Eventually(func() bool {
+			clock.Advance(10 * time.Second)
+			select {
+			case message = <-messages:
+				return true
+			default:
+				return false
+			}
+		}).Should(BeTrue())
+		Expect(message).To(HavePrefix("No traffic generated an alert - no events for 1m"))
+
+		close(reader.logs)
+		Eventually(done).Should(BeClosed())
+	})
 })
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAnomalySmoothing = 0.3
+	DefaultAnomalyWarmup    = 3
+)
+
+type AnomalyMetric func(Event) float64
+
+var anomalyMetrics = map[string]AnomalyMetric{
+	"hits":   func(Event) float64 { return 1 },
+	"errors": ErrorsMetric,
+	"bytes":  func(event Event) float64 { return float64(event.PayloadSize) },
+}
+
+func AnomalyMetricByName(name string) (AnomalyMetric, error) {
+	metric, ok := anomalyMetrics[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown anomaly metric: %s", name)
+	}
+	return metric, nil
+}
+
+type AnomalySpec struct {
+	Name        string
+	Metric      AnomalyMetric
+	Interval    time.Duration
+	Season      time.Duration
+	Sensitivity float64
+}
+
+func ParseAnomalySpecs(specs string, defaults AnomalySpec) ([]AnomalySpec, error) {
+	var parsed []AnomalySpec
+	names := map[string]bool{}
+	for _, spec := range strings.Split(specs, ",") {
+		anomalySpec, err := ParseAnomalySpec(strings.TrimSpace(spec), defaults)
+		if err != nil {
+			return nil, err
+		}
+		if names[anomalySpec.Name] {
+			return nil, fmt.Errorf("anomaly metric %s is defined more than once", anomalySpec.Name)
+		}
+		names[anomalySpec.Name] = true
+		parsed = append(parsed, anomalySpec)
+	}
+	return parsed, nil
+}
+
+func ParseAnomalySpec(spec string, defaults AnomalySpec) (AnomalySpec, error) {
+	fields := strings.Split(spec, ":")
+	if len(fields) > 4 {
+		return AnomalySpec{}, fmt.Errorf("invalid anomaly metric %s: expected metric:interval:season:sensitivity", spec)
+	}
+	fields = append(fields, make([]string, 4-len(fields))...)
+	anomalySpec := defaults
+	anomalySpec.Name = fields[0]
+
+	var err error
+	if anomalySpec.Metric, err = AnomalyMetricByName(fields[0]); err != nil {
+		return AnomalySpec{}, err
+	}
+	if fields[1] != "" {
+		if anomalySpec.Interval, err = ParseConfigDuration(fields[1]); err != nil {
+			return AnomalySpec{}, fmt.Errorf("invalid anomaly interval of %s: %s", anomalySpec.Name, fields[1])
+		}
+	}
+	if fields[2] != "" {
+		if anomalySpec.Season, err = ParseConfigDuration(fields[2]); err != nil {
+			return AnomalySpec{}, fmt.Errorf("invalid anomaly season of %s: %s", anomalySpec.Name, fields[2])
+		}
+	}
+	if fields[3] != "" {
+		if anomalySpec.Sensitivity, err = strconv.ParseFloat(fields[3], 64); err != nil {
+			return AnomalySpec{}, fmt.Errorf("invalid anomaly sensitivity of %s: %s", anomalySpec.Name, fields[3])
+		}
+	}
+	if anomalySpec.Interval <= 0 || anomalySpec.Season < anomalySpec.Interval {
+		return AnomalySpec{}, fmt.Errorf("the anomaly season of %s should be at least its interval: %s < %s", anomalySpec.Name, anomalySpec.Season, anomalySpec.Interval)
+	}
+	if anomalySpec.Sensitivity <= 0 {
+		return AnomalySpec{}, fmt.Errorf("the anomaly sensitivity of %s should be positive: %g", anomalySpec.Name, anomalySpec.Sensitivity)
+	}
+	return anomalySpec, nil
+}
+
+func ErrorsMetric(event Event) float64 {
+	if StatusClass(event.StatusCode) == "5xx" {
+		return 1
+	}
+	return 0
+}
+
+type Baseline struct {
+	Interval time.Duration  `json:"interval"`
+	Period   time.Duration  `json:"period"`
+	Slots    []BaselineSlot `json:"slots"`
+}
+
+type BaselineSlot struct {
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	Count    int     `json:"count"`
+}
+
+func NewBaseline(interval, period time.Duration) *Baseline {
+	slots := int(period / interval)
+	if slots < 1 {
+		slots = 1
+	}
+	return &Baseline{Interval: interval, Period: period, Slots: make([]BaselineSlot, slots)}
+}
+
+func (b *Baseline) Slot(at time.Time) *BaselineSlot {
+	offset := at.UnixNano() % int64(b.Period)
+	if offset < 0 {
+		offset += int64(b.Period)
+	}
+	return &b.Slots[int(offset/int64(b.Interval))%len(b.Slots)]
+}
+
+func (b *Baseline) Learn(at time.Time, value float64, smoothing float64) {
+	slot := b.Slot(at)
+	if slot.Count == 0 {
+		slot.Mean = value
+	} else {
+		deviation := value - slot.Mean
+		slot.Mean += smoothing * deviation
+		slot.Variance = (1 - smoothing) * (slot.Variance + smoothing*deviation*deviation)
+	}
+	slot.Count++
+}
+
+func (b *BaselineSlot) StdDev() float64 {
+	return math.Sqrt(b.Variance)
+}
+
+type BaselineStore struct {
+	mutex     sync.Mutex
+	path      string
+	baselines map[string]*Baseline
+}
+
+func LoadBaselineStore(path string) (*BaselineStore, error) {
+	store := &BaselineStore{path: path, baselines: map[string]*Baseline{}}
+	if path == "" {
+		return store, nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, &store.baselines); err != nil {
+		return nil, fmt.Errorf("could not load %s: %s", path, err.Error())
+	}
+	return store, nil
+}
+
+func (b *BaselineStore) Baseline(name string, interval, period time.Duration) *Baseline {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	baseline, ok := b.baselines[name]
+	if !ok || baseline.Interval != interval || baseline.Period != period || len(baseline.Slots) != len(NewBaseline(interval, period).Slots) {
+		baseline = NewBaseline(interval, period)
+		b.baselines[name] = baseline
+	}
+	return baseline
+}
+
+func (b *BaselineStore) Save() error {
+	if b.path == "" {
+		return nil
+	}
+	b.mutex.Lock()
+	contents, err := json.Marshal(b.baselines)
+	b.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	temporary, err := ioutil.TempFile(filepath.Dir(b.path), filepath.Base(b.path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+	if _, err := temporary.Write(contents); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporary.Name(), b.path)
+}
+
+type AnomalyAlert struct {
+	name        string
+	metric      AnomalyMetric
+	sensitivity float64
+	store       *BaselineStore
+	baseline    *Baseline
+	clock       Clock
+	label       string
+	clearRatio  float64
+	lifecycle   *AlertLifecycle
+
+	notification Notification
+	interval     time.Time
+	value        float64
+	observed     bool
+}
+
+func NewAnomalyAlert(name string, metric AnomalyMetric, store *BaselineStore, interval, period time.Duration, sensitivity float64, notification Notification, options ...AlertOption) *AnomalyAlert {
+	alertOptions := newAlertOptions(options)
+	return &AnomalyAlert{
+		name:         name,
+		metric:       metric,
+		sensitivity:  sensitivity,
+		store:        store,
+		baseline:     store.Baseline(name, interval, period),
+		clock:        alertOptions.clock,
+		label:        alertOptions.label,
+		clearRatio:   alertOptions.clearRatio,
+		lifecycle:    newAlertLifecycle("anomaly", interval, notification, alertOptions),
+		notification: notification,
+	}
+}
+
+func (a *AnomalyAlert) Check(event Event) {
+	if observer, ok := a.clock.(EventTimeObserver); ok {
+		observer.Observe(event.Time)
+	}
+	interval := event.Time.Truncate(a.baseline.Interval)
+	if a.interval.IsZero() {
+		a.interval = interval
+	}
+	if interval.Before(a.interval) {
+		return
+	}
+	if interval.After(a.interval) {
+		a.closeIntervals(interval, true)
+	}
+	a.value += a.metric(event)
+	a.observed = true
+}
+
+func (a *AnomalyAlert) Tick(now time.Time) {
+	if _, ok := a.clock.(EventTimeObserver); ok {
+		now = a.clock.Now()
+	}
+	interval := now.Truncate(a.baseline.Interval)
+	if a.interval.IsZero() || !interval.After(a.interval) {
+		return
+	}
+	a.closeIntervals(interval, false)
+}
+
+func (a *AnomalyAlert) Triggered() bool {
+	return a.lifecycle.Firing()
+}
+
+func (a *AnomalyAlert) closeIntervals(next time.Time, learnEmpty bool) {
+	learned := false
+	for a.interval.Before(next) {
+		a.evaluate(a.interval, a.value)
+		if learnEmpty || a.observed {
+			a.baseline.Learn(a.interval, a.value, DefaultAnomalySmoothing)
+			learned = true
+		}
+		a.interval = a.interval.Add(a.baseline.Interval)
+		a.value = 0
+		a.observed = false
+		if next.Sub(a.interval) > a.baseline.Period {
+			a.interval = next.Add(-a.baseline.Period)
+		}
+	}
+	if !learned {
+		return
+	}
+	if err := a.store.Save(); err != nil {
+		a.notification.Send(NewErrorMessage("Could not save the anomaly baselines: %s", err.Error()))
+	}
+}
+
+func (a *AnomalyAlert) evaluate(interval time.Time, value float64) {
+	slot := *a.baseline.Slot(interval)
+	if slot.Count < DefaultAnomalyWarmup {
+		return
+	}
+
+	spread := a.sensitivity * math.Max(slot.StdDev(), math.Sqrt(slot.Mean))
+	deviation := math.Abs(value - slot.Mean)
+	a.lifecycle.Evaluate(interval.Add(a.baseline.Interval), deviation > spread, deviation <= spread*a.clearRatio, func(transition *AlertTransition) {
+		direction := "above"
+		if value < slot.Mean {
+			direction = "below"
+		}
+		transition.Subject = labeled(a.name, a.label)
+		transition.Condition = fmt.Sprintf("Anomalous %s %s the expected range", transition.Subject, direction)
+		transition.Details = fmt.Sprintf("observed = %.0f, expected = %.0f ± %.0f", value, slot.Mean, spread)
+		transition.Value, transition.Threshold = value, slot.Mean
+	})
+}
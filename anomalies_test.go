@@ -0,0 +1,204 @@
+package main_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`AnomalyAlert`, func() {
+	var (
+		alert        *AnomalyAlert
+		store        *BaselineStore
+		notification *notificationMock
+		options      []AlertOption
+		start        time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		store, err = LoadBaselineStore("")
+		Expect(err).NotTo(HaveOccurred())
+		notification = new(notificationMock)
+		options = nil
+		start = time.Date(2016, time.March, 1, 0, 0, 0, 0, time.UTC)
+	})
+
+	JustBeforeEach(func() {
+		alert = NewAnomalyAlert("hits", func(Event) float64 { return 1 }, store, time.Hour, 4*time.Hour, 3, notification, options...)
+	})
+
+	learned := func() int {
+		count := 0
+		for _, slot := range store.Baseline("hits", time.Hour, 4*time.Hour).Slots {
+			count += slot.Count
+		}
+		return count
+	}
+
+	traffic := func(from time.Time, hourly ...int) time.Time {
+		for hour, hits := range hourly {
+			for i := 0; i < hits; i++ {
+				alert.Check(Event{Time: from.Add(time.Duration(hour)*time.Hour + time.Duration(i)*time.Second)})
+			}
+		}
+		return from.Add(time.Duration(len(hourly)) * time.Hour)
+	}
+
+	It(`learns a different expected range for each interval of the season`, func() {
+		at := start
+		for day := 0; day < 4; day++ {
+			at = traffic(at, 10, 100, 100, 10)
+		}
+		Expect(notification.messages).To(BeEmpty())
+
+		traffic(at, 100, 100)
+		Expect(notification.messages).To(HaveLen(1))
+		Expect(notification.message).To(Equal(fmt.Sprintf("Anomalous hits above the expected range generated an alert - observed = 100, expected = 10 ± 9, triggered at %s\n", at.Add(time.Hour).String())))
+	})
+
+	It(`alerts when the traffic drops and resolves when it is back`, func() {
+		at := start
+		for day := 0; day < 4; day++ {
+			at = traffic(at, 50, 50, 50, 50)
+		}
+		at = traffic(at, 5, 50, 50)
+		Expect(notification.messages).To(HaveLen(2))
+		Expect(notification.messages[0]).To(HavePrefix("Anomalous hits below the expected range generated an alert - observed = 5, expected = 50"))
+		Expect(notification.messages[1]).To(HavePrefix("Hits returned to normal - observed = 50, expected = 50"))
+		Expect(alert.Triggered()).To(BeFalse())
+	})
+
+	Context(`when it ticks on the clock of the live traffic`, func() {
+		BeforeEach(func() {
+			options = []AlertOption{AlertClock(NewFakeClock(start))}
+		})
+
+		It(`alerts on ticks when the traffic stops without learning the empty intervals`, func() {
+			at := start
+			for day := 0; day < 4; day++ {
+				at = traffic(at, 50, 50, 50, 50)
+			}
+			alert.Tick(at.Add(30 * time.Minute))
+			Expect(notification.messages).To(BeEmpty())
+			Expect(learned()).To(Equal(16))
+
+			alert.Tick(at.Add(time.Hour))
+			Expect(notification.messages).To(HaveLen(1))
+			Expect(notification.message).To(HavePrefix("Anomalous hits below the expected range generated an alert - observed = 0, expected = 50"))
+			Expect(alert.Triggered()).To(BeTrue())
+			Expect(learned()).To(Equal(16))
+		})
+	})
+
+	It(`keeps learning a backfill of older events when it ticks on the wall clock`, func() {
+		traffic(start, 50)
+		alert.Tick(time.Now())
+		Expect(learned()).To(BeZero())
+
+		at := start.Add(time.Hour)
+		for day := 0; day < 4; day++ {
+			at = traffic(at, 50, 50, 50, 50)
+			alert.Tick(time.Now())
+		}
+		Expect(learned()).To(Equal(16))
+		Expect(notification.messages).To(BeEmpty())
+	})
+
+	It(`does not alert before each interval has enough history`, func() {
+		traffic(start, 10, 10, 10, 10, 1000, 10)
+		Expect(notification.messages).To(BeEmpty())
+	})
+
+	Context(`when the baselines are persisted`, func() {
+		var directory string
+
+		BeforeEach(func() {
+			var err error
+			directory, err = ioutil.TempDir("", "redwood")
+			Expect(err).NotTo(HaveOccurred())
+			store, err = LoadBaselineStore(filepath.Join(directory, "baselines.json"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(directory)
+		})
+
+		It(`keeps learning where it left off after a restart`, func() {
+			at := start
+			for day := 0; day < 4; day++ {
+				at = traffic(at, 50, 50, 50, 50)
+			}
+
+			restored, err := LoadBaselineStore(filepath.Join(directory, "baselines.json"))
+			Expect(err).NotTo(HaveOccurred())
+			baseline := restored.Baseline("hits", time.Hour, 4*time.Hour)
+			Expect(baseline.Slot(start).Count).To(Equal(4))
+			Expect(baseline.Slot(start).Mean).To(BeNumerically("~", 50, 0.01))
+
+			store = restored
+			alert = NewAnomalyAlert("hits", func(Event) float64 { return 1 }, store, time.Hour, 4*time.Hour, 3, notification)
+			traffic(at, 500, 50)
+			Expect(notification.message).To(HavePrefix("Anomalous hits above the expected range"))
+		})
+	})
+})
+
+var _ = Describe(`ParseAnomalySpecs`, func() {
+	defaults := AnomalySpec{Interval: 5 * time.Minute, Season: 7 * 24 * time.Hour, Sensitivity: 3}
+
+	It(`parses the settings of each metric`, func() {
+		specs, err := ParseAnomalySpecs("hits:1m:1d:4, errors::24h, bytes", defaults)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(specs).To(HaveLen(3))
+		for i, expected := range []AnomalySpec{
+			{Name: "hits", Interval: time.Minute, Season: 24 * time.Hour, Sensitivity: 4},
+			{Name: "errors", Interval: 5 * time.Minute, Season: 24 * time.Hour, Sensitivity: 3},
+			{Name: "bytes", Interval: 5 * time.Minute, Season: 7 * 24 * time.Hour, Sensitivity: 3},
+		} {
+			Expect(specs[i].Name).To(Equal(expected.Name))
+			Expect(specs[i].Interval).To(Equal(expected.Interval))
+			Expect(specs[i].Season).To(Equal(expected.Season))
+			Expect(specs[i].Sensitivity).To(Equal(expected.Sensitivity))
+			Expect(specs[i].Metric).NotTo(BeNil())
+		}
+	})
+
+	It(`rejects invalid settings`, func() {
+		for _, invalid := range []struct{ specs, message string }{
+			{"latency", "unknown anomaly metric: latency"},
+			{"hits:1m:1d:4:5", "invalid anomaly metric hits:1m:1d:4:5: expected metric:interval:season:sensitivity"},
+			{"hits:soon", "invalid anomaly interval of hits: soon"},
+			{"hits::weekly", "invalid anomaly season of hits: weekly"},
+			{"hits:::high", "invalid anomaly sensitivity of hits: high"},
+			{"hits:1h:30m", "the anomaly season of hits should be at least its interval: 30m0s < 1h0m0s"},
+			{"hits:::0", "the anomaly sensitivity of hits should be positive: 0"},
+			{"hits,hits:1m", "anomaly metric hits is defined more than once"},
+		} {
+			_, err := ParseAnomalySpecs(invalid.specs, defaults)
+			Expect(err).To(MatchError(invalid.message))
+		}
+	})
+})
+
+var _ = Describe(`AnomalyMetricByName`, func() {
+	It(`measures hits, errors and bytes`, func() {
+		event := Event{StatusCode: 503, PayloadSize: 512}
+		for name, value := range map[string]float64{"hits": 1, "errors": 1, "bytes": 512} {
+			metric, err := AnomalyMetricByName(name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metric(event)).To(Equal(value))
+		}
+		Expect(ErrorsMetric(Event{StatusCode: 404})).To(BeZero())
+
+		_, err := AnomalyMetricByName("latency")
+		Expect(err).To(MatchError("unknown anomaly metric: latency"))
+	})
+})
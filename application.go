@@ -1,9 +1,18 @@
 package main
 
+import "time"
+
 type Application struct {
 	logReader      LogReader
 	trafficMonitor TrafficMonitor
 	alert          Alert
+
+	enrichers []Enricher
+	filters   []Filter
+
+	alertQueue *EventQueue
+	alertTick  time.Duration
+	clock      Clock
 }
 
 func NewApplication(logReader LogReader, monitor TrafficMonitor, alert Alert) *Application {
@@ -11,12 +20,97 @@ func NewApplication(logReader LogReader, monitor TrafficMonitor, alert Alert) *A
 		logReader:      logReader,
 		trafficMonitor: monitor,
 		alert:          alert,
+		alertQueue:     NewEventQueue("alert", QueueConfig{}),
+		alertTick:      time.Second,
+		clock:          WallClock,
+	}
+}
+
+func (a *Application) AddEnricher(enricher Enricher) {
+	a.enrichers = append(a.enrichers, enricher)
+}
+
+func (a *Application) AddFilter(filter Filter) {
+	a.filters = append(a.filters, filter)
+}
+
+func (a *Application) SetAlertQueue(config QueueConfig) {
+	a.alertQueue = NewEventQueue("alert", config)
+}
+
+func (a *Application) SetAlertTick(interval time.Duration) {
+	a.alertTick = interval
+}
+
+func (a *Application) SetClock(clock Clock) {
+	a.clock = clock
+}
+
+func (a *Application) QueueStats() []QueueStats {
+	var stats []QueueStats
+	for _, component := range []interface{}{a.logReader, a.trafficMonitor} {
+		if reporter, ok := component.(QueueReporter); ok {
+			stats = append(stats, reporter.QueueStats()...)
+		}
 	}
+	return append(stats, a.alertQueue.Stats())
 }
 
 func (a *Application) Run() {
+	alertsChecked := make(chan struct{})
+	go a.checkAlerts(alertsChecked)
+
+	observer, observesEventTime := a.clock.(EventTimeObserver)
 	for event := range a.logReader.Read() {
+		for _, enricher := range a.enrichers {
+			enricher.Enrich(&event)
+		}
+		if !a.allow(event) {
+			continue
+		}
+		if observesEventTime {
+			observer.Observe(event.Time)
+		}
 		a.trafficMonitor.Monitor(event)
-		a.alert.Check(event)
+		a.alertQueue.Push(event)
+	}
+
+	a.alertQueue.Close()
+	<-alertsChecked
+	a.trafficMonitor.Stop()
+}
+
+func (a *Application) checkAlerts(done chan<- struct{}) {
+	defer close(done)
+	ticking, ok := a.alert.(TickingAlert)
+	if !ok || a.alertTick <= 0 {
+		for event := range a.alertQueue.Events() {
+			a.alert.Check(event)
+		}
+		return
+	}
+
+	ticker := a.clock.NewTicker(a.alertTick)
+	defer ticker.Stop()
+	events := a.alertQueue.Events()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			ticking.Check(event)
+		case now := <-ticker.C():
+			ticking.Tick(now)
+		}
+	}
+}
+
+func (a *Application) allow(event Event) bool {
+	for _, filter := range a.filters {
+		if !filter.Allow(event) {
+			return false
+		}
 	}
+	return true
 }
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	NewTicker(time.Duration) Ticker
+	After(time.Duration) <-chan time.Time
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type EventTimeObserver interface {
+	Observe(time.Time)
+}
+
+var WallClock Clock = wallClock{}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) NewTicker(duration time.Duration) Ticker {
+	return &wallTicker{ticker: time.NewTicker(duration)}
+}
+
+func (wallClock) After(duration time.Duration) <-chan time.Time {
+	return time.After(duration)
+}
+
+type wallTicker struct {
+	ticker *time.Ticker
+}
+
+func (w *wallTicker) C() <-chan time.Time {
+	return w.ticker.C
+}
+
+func (w *wallTicker) Stop() {
+	w.ticker.Stop()
+}
+
+func ClockByName(name string) (Clock, error) {
+	switch name {
+	case "wall":
+		return WallClock, nil
+	case "event":
+		return NewEventClock(), nil
+	}
+	return nil, fmt.Errorf("unknown clock: %s", name)
+}
+
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers map[*manualTicker]struct{}
+	timers  []manualTimer
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:     now,
+		tickers: map[*manualTicker]struct{}{},
+	}
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *FakeClock) NewTicker(duration time.Duration) Ticker {
+	if duration <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ticker := &manualTicker{
+		clock:    f,
+		duration: duration,
+		next:     f.now.Add(duration),
+		ticks:    make(chan time.Time, 1),
+		stopped:  make(chan struct{}),
+	}
+	f.tickers[ticker] = struct{}{}
+	return ticker
+}
+
+func (f *FakeClock) After(duration time.Duration) <-chan time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	timer := manualTimer{at: f.now.Add(duration), fired: make(chan time.Time, 1)}
+	if duration <= 0 {
+		timer.fired <- timer.at
+		return timer.fired
+	}
+	f.timers = append(f.timers, timer)
+	return timer.fired
+}
+
+func (f *FakeClock) Timers() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.timers)
+}
+
+func (f *FakeClock) Advance(duration time.Duration) {
+	f.Set(f.Now().Add(duration))
+}
+
+func (f *FakeClock) Set(now time.Time) {
+	f.mutex.Lock()
+	if !now.After(f.now) {
+		f.mutex.Unlock()
+		return
+	}
+	f.now = now
+	var ticks []tick
+	for ticker := range f.tickers {
+		for !ticker.next.After(now) {
+			ticks = append(ticks, tick{ticker: ticker, at: ticker.next})
+			ticker.next = ticker.next.Add(ticker.duration)
+		}
+	}
+	pending := f.timers[:0]
+	for _, timer := range f.timers {
+		if timer.at.After(now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.fired <- timer.at
+	}
+	f.timers = pending
+	f.mutex.Unlock()
+
+	sort.Sort(ticksByTime(ticks))
+	for _, tick := range ticks {
+		tick.ticker.send(tick.at)
+	}
+}
+
+func (f *FakeClock) stop(ticker *manualTicker) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.tickers, ticker)
+}
+
+type EventClock struct {
+	*FakeClock
+}
+
+func NewEventClock() *EventClock {
+	return &EventClock{FakeClock: NewFakeClock(time.Time{})}
+}
+
+func (e *EventClock) Observe(eventTime time.Time) {
+	e.mutex.Lock()
+	if e.now.IsZero() {
+		e.now = eventTime
+		for ticker := range e.tickers {
+			ticker.next = eventTime.Add(ticker.duration)
+		}
+		e.mutex.Unlock()
+		return
+	}
+	e.mutex.Unlock()
+	e.Set(eventTime)
+}
+
+type manualTicker struct {
+	clock    *FakeClock
+	duration time.Duration
+	next     time.Time
+	ticks    chan time.Time
+
+	once    sync.Once
+	stopped chan struct{}
+}
+
+func (m *manualTicker) C() <-chan time.Time {
+	return m.ticks
+}
+
+func (m *manualTicker) Stop() {
+	m.once.Do(func() {
+		close(m.stopped)
+		m.clock.stop(m)
+	})
+}
+
+func (m *manualTicker) send(at time.Time) {
+	select {
+	case m.ticks <- at:
+	case <-m.stopped:
+	}
+}
+
+type manualTimer struct {
+	at    time.Time
+	fired chan time.Time
+}
+
+type tick struct {
+	ticker *manualTicker
+	at     time.Time
+}
+
+type ticksByTime []tick
+
+func (t ticksByTime) Len() int           { return len(t) }
+func (t ticksByTime) Less(i, j int) bool { return t[i].at.Before(t[j].at) }
+func (t ticksByTime) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
@@ -0,0 +1,88 @@
+package main_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`FakeClock`, func() {
+	var (
+		clock *FakeClock
+		start time.Time
+	)
+
+	BeforeEach(func() {
+		start = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+		clock = NewFakeClock(start)
+	})
+
+	It(`only moves when advanced`, func() {
+		Expect(clock.Now()).To(Equal(start))
+		clock.Advance(5 * time.Second)
+		Expect(clock.Now()).To(Equal(start.Add(5 * time.Second)))
+	})
+
+	It(`never moves backwards`, func() {
+		clock.Set(start.Add(-time.Second))
+		Expect(clock.Now()).To(Equal(start))
+	})
+
+	It(`ticks at each interval the clock passes`, func() {
+		ticker := clock.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		ticks := make(chan time.Time, 3)
+		go func() {
+			for i := 0; i < 3; i++ {
+				ticks <- <-ticker.C()
+			}
+		}()
+		clock.Advance(500 * time.Millisecond)
+		Consistently(ticks).ShouldNot(Receive())
+
+		clock.Advance(2500 * time.Millisecond)
+		Eventually(ticks).Should(Receive(Equal(start.Add(1 * time.Second))))
+		Eventually(ticks).Should(Receive(Equal(start.Add(2 * time.Second))))
+		Eventually(ticks).Should(Receive(Equal(start.Add(3 * time.Second))))
+	})
+
+	It(`stops ticking once the ticker is stopped`, func() {
+		ticker := clock.NewTicker(time.Second)
+		ticker.Stop()
+		clock.Advance(3 * time.Second)
+		Expect(ticker.C()).NotTo(Receive())
+	})
+})
+
+var _ = Describe(`EventClock`, func() {
+	var (
+		clock     *EventClock
+		eventTime time.Time
+	)
+
+	BeforeEach(func() {
+		clock = NewEventClock()
+		eventTime = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	It(`follows the latest observed event time`, func() {
+		clock.Observe(eventTime)
+		Expect(clock.Now()).To(Equal(eventTime))
+
+		clock.Observe(eventTime.Add(time.Minute))
+		clock.Observe(eventTime.Add(30 * time.Second))
+		Expect(clock.Now()).To(Equal(eventTime.Add(time.Minute)))
+	})
+
+	It(`ticks relative to the first observed event`, func() {
+		ticker := clock.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+
+		clock.Observe(eventTime)
+		clock.Observe(eventTime.Add(10 * time.Second))
+		Expect(ticker.C()).To(Receive(Equal(eventTime.Add(10 * time.Second))))
+	})
+})
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var commands = map[string]func(args []string){
+	"replay":       replay,
+	"report":       report,
+	"generate":     generate,
+	"check-format": checkFormat,
+	"alerts":       listAlerts,
+}
+
+func replay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	registerFlags(flags)
+	speed := flags.Float64("speed", 0, "Playback speed of the event times: 1 for real time, N for N times faster, 0 for as fast as possible")
+	flags.Parse(args)
+
+	fileLogReader, err := NewLogFileReader(file, append(readerOptions(), StopAtEOF())...)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	log.Printf("Replaying the %s file for http logs", file)
+	log.Printf("Replaying traffic; will alert if traffic surpasses %d requests in %d seconds", traffic, duration)
+	app, closeApplication := newApplication(NewReplayLogReader(fileLogReader, WallClock, *speed), NewEventClock())
+	defer closeApplication()
+	app.Run()
+}
+
+func report(args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	format := flags.String("format", "text", "Format of the report: text, json or html")
+	output := flags.String("output", "", "File name to write the report to; writes to stdout when empty")
+	top := flags.Int("top", 10, "Number of entries in each of the top lists of the report")
+	parserName := flags.String("parser", "fast", "Parser of the log lines: fast for the single pass Common/Combined Log Format parser, regexp for the regular expression parser, jsonl for JSON lines")
+	logFormatSpec := flags.String("log-format", "", "nginx log_format specification of the log lines, such as '$remote_addr - $remote_user [$time_local] \"$request\" $status $body_bytes_sent'; overrides -parser")
+	workers := flags.Int("parse-workers", runtime.NumCPU(), "Number of workers parsing the log lines in parallel")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("usage: redwood report [flags] <log file>")
+	}
+
+	writeReport, err := ReportWriterByName(*format)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	fileLogReader, err := NewLogFileReader(flags.Arg(0), StopAtEOF(), ParseWith(lineParser(*parserName, *logFormatSpec)), ParseWorkers(*workers))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer fileLogReader.Close()
+
+	builder := NewReportBuilder(*top)
+	for event := range fileLogReader.Read() {
+		builder.Add(event)
+	}
+
+	var writer io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer file.Close()
+		writer = file
+	}
+	if err := writeReport(writer, builder.Report()); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+type spikeFlags []TrafficSpike
+
+func (s *spikeFlags) String() string {
+	var spikes []string
+	for _, spike := range *s {
+		spikes = append(spikes, fmt.Sprintf("%s,%s,%g,%s", spike.Offset, spike.Duration, spike.Multiplier, spike.Section))
+	}
+	return strings.Join(spikes, " ")
+}
+
+func (s *spikeFlags) Set(value string) error {
+	spike, err := ParseTrafficSpike(value)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, spike)
+	return nil
+}
+
+func generate(args []string) {
+	var spikes spikeFlags
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	format := flags.String("format", "clf", "Format of the generated lines: clf for the Combined Log Format, jsonl for JSON lines")
+	output := flags.String("output", "", "File name to write the generated lines to; writes to stdout when empty")
+	seed := flags.Int64("seed", 1, "Seed of the random traffic; the same seed and flags generate the same lines")
+	start := flags.String("start", "", "RFC 3339 time of the first generated line; the current time when empty")
+	length := flags.Duration("duration", time.Hour, "Time span of the generated traffic")
+	rate := flags.Float64("rate", 10, "Average number of requests per second")
+	diurnal := flags.Float64("diurnal", 0, "Amplitude between 0 and 1 of the daily traffic curve peaking in the afternoon")
+	clients := flags.Int("clients", 100, "Number of distinct clients sending the traffic")
+	sectionWeights := flags.String("sections", "/api:5,/product:4,/static:3,/cart:2", "Comma separated sections and their weights")
+	statusWeights := flags.String("statuses", "200:90,301:2,304:3,404:4,500:1", "Comma separated status codes and their weights")
+	flags.Var(&spikes, "spike", "Scripted spike as offset,duration,multiplier[,section], such as 10m,2m,5,/api; a multiplier of 0 is an outage; can be repeated")
+	flags.Parse(args)
+
+	formatLine, err := LineFormatterByName(*format)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	sections, err := ParseWeightedSections(*sectionWeights)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	statuses, err := ParseWeightedStatuses(*statusWeights)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if *diurnal < 0 || *diurnal > 1 {
+		log.Fatal("-diurnal must be between 0 and 1")
+	}
+	startTime := time.Now().Truncate(time.Second)
+	if *start != "" {
+		if startTime, err = time.Parse(time.RFC3339, *start); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	var writer io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer file.Close()
+		writer = file
+	}
+	buffered := bufio.NewWriter(writer)
+	defer buffered.Flush()
+
+	generator := NewTrafficGenerator(TrafficProfile{
+		Seed:     *seed,
+		Start:    startTime,
+		Duration: *length,
+		Rate:     *rate,
+		Diurnal:  *diurnal,
+		Clients:  *clients,
+		Sections: sections,
+		Statuses: statuses,
+		Spikes:   spikes,
+	})
+	for event, ok := generator.Next(); ok; event, ok = generator.Next() {
+		fmt.Fprintln(buffered, formatLine(event))
+	}
+}
+
+func checkFormat(args []string) {
+	flags := flag.NewFlagSet("check-format", flag.ExitOnError)
+	parserName := flags.String("parser", "fast", "Parser of the log lines when no -log-format is set: fast, regexp or jsonl")
+	logFormatSpec := flags.String("log-format", "", "nginx log_format specification of the log lines to check")
+	threshold := flags.Float64("threshold", 1, "Fraction of the lines between 0 and 1 that must parse for the check to pass")
+	failuresOnly := flags.Bool("failures-only", false, "Only print the lines that failed to parse")
+	flags.Parse(args)
+
+	parse := lineParser(*parserName, *logFormatSpec)
+	var lines io.Reader = os.Stdin
+	if flags.NArg() > 0 {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer file.Close()
+		lines = file
+	}
+
+	check, err := CheckLogFormat(lines, os.Stdout, parse, *failuresOnly)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	fmt.Println(check.String())
+	if check.Ratio() < *threshold {
+		fmt.Printf("below the threshold of %.1f%%\n", *threshold*100)
+		os.Exit(1)
+	}
+}
+
+func listAlerts(args []string) {
+	flags := flag.NewFlagSet("alerts", flag.ExitOnError)
+	history := flags.String("history", "", "File name of the alert history written by -alert-history")
+	since := flags.String("since", "", "Only list the transitions at or after a time, as RFC 3339 such as 2016-03-01T12:00:00Z or a duration before now such as 24h")
+	until := flags.String("until", "", "Only list the transitions before a time, as RFC 3339 or a duration before now")
+	rule := flags.String("rule", "", "Only list the transitions of one alert, such as traffic or error-rate, or of one rule of the config")
+	states := flags.String("state", "", "Comma separated states to list the transitions into: pending, firing, resolved, inactive; lists all when empty")
+	format := flags.String("format", "text", "Format of the list: text for a table, jsonl for the records as they are stored")
+	flags.Parse(args)
+	if *history == "" {
+		log.Fatal("usage: redwood alerts -history <alert history> [flags]")
+	}
+
+	query := AlertHistoryQuery{Rule: *rule}
+	var err error
+	now := time.Now()
+	if query.Since, err = ParseHistoryTime(*since, now); err != nil {
+		log.Fatal(err.Error())
+	}
+	if query.Until, err = ParseHistoryTime(*until, now); err != nil {
+		log.Fatal(err.Error())
+	}
+	if *states != "" {
+		for _, name := range strings.Split(*states, ",") {
+			var state AlertState
+			if err := state.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+				log.Fatal(err.Error())
+			}
+			query.States = append(query.States, state)
+		}
+	}
+	if *format != "text" && *format != "jsonl" {
+		log.Fatalf("unknown format: %s", *format)
+	}
+
+	file, err := os.Open(*history)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer file.Close()
+
+	var records []AlertRecord
+	encoder := json.NewEncoder(os.Stdout)
+	err = ReadAlertHistory(file, query, func(record AlertRecord) {
+		if *format == "jsonl" {
+			encoder.Encode(record)
+		} else {
+			records = append(records, record)
+		}
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if *format == "text" {
+		WriteAlertRecords(os.Stdout, records)
+	}
+}
+
+func lineParser(parserName string, logFormatSpec string) LineParser {
+	if logFormatSpec != "" {
+		logFormat, err := CompileLogFormat(logFormatSpec)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if ignored := logFormat.Ignored(); len(ignored) > 0 {
+			log.Printf("Ignoring the log format variables: $%s", strings.Join(ignored, ", $"))
+		}
+		return logFormat.Parse
+	}
+	parser, err := LineParserByName(parserName)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	return parser
+}
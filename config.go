@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var Notifiers = []string{"console", "webhook"}
+
+type Config struct {
+	Rules     []RuleConfig                 `json:"rules"`
+	Silences  []Silence                    `json:"silences"`
+	SLOs      []SLOConfig                  `json:"slos"`
+	Templates map[string]map[string]string `json:"templates"`
+
+	templates map[string]Templates
+}
+
+type RuleConfig struct {
+	Name    string   `json:"name"`
+	Expr    string   `json:"expr"`
+	For     string   `json:"for"`
+	GroupBy []string `json:"group_by"`
+}
+
+type SLOConfig struct {
+	Name      string           `json:"name"`
+	Total     string           `json:"total"`
+	Good      string           `json:"good"`
+	Target    float64          `json:"target"`
+	Period    string           `json:"period"`
+	BurnRates []BurnRateConfig `json:"burn_rates"`
+}
+
+type BurnRateConfig struct {
+	Long   string  `json:"long"`
+	Short  string  `json:"short"`
+	Factor float64 `json:"factor"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(contents)
+}
+
+func ParseConfig(contents []byte) (*Config, error) {
+	var config Config
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err.Error())
+	}
+	if err := config.compileTemplates(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (c *Config) compileTemplates() error {
+	c.templates = map[string]Templates{}
+	for notifier, sources := range c.Templates {
+		known := false
+		for _, name := range Notifiers {
+			known = known || name == notifier
+		}
+		if !known {
+			return fmt.Errorf("templates: unknown notifier: %s", notifier)
+		}
+		templates, err := ParseTemplates(notifier, sources)
+		if err != nil {
+			return err
+		}
+		c.templates[notifier] = templates
+	}
+	return nil
+}
+
+func (c *Config) NotifierTemplates(notifier string) Templates {
+	if templates, ok := c.templates[notifier]; ok {
+		return templates
+	}
+	return DefaultTemplates
+}
+
+func (c *Config) CompileRules() ([]*Rule, error) {
+	rules := make([]*Rule, 0, len(c.Rules))
+	names := map[string]bool{}
+	for i, ruleConfig := range c.Rules {
+		if ruleConfig.Name == "" {
+			return nil, fmt.Errorf("rule %d has no name", i+1)
+		}
+		if names[ruleConfig.Name] {
+			return nil, fmt.Errorf("rule %s is defined more than once", ruleConfig.Name)
+		}
+		names[ruleConfig.Name] = true
+
+		var pendingFor time.Duration
+		if ruleConfig.For != "" {
+			var err error
+			if pendingFor, err = ParseConfigDuration(ruleConfig.For); err != nil || pendingFor < 0 {
+				return nil, fmt.Errorf("rule %s: invalid for: %s", ruleConfig.Name, ruleConfig.For)
+			}
+		}
+		rule, err := CompileRule(ruleConfig.Name, ruleConfig.Expr, pendingFor, ruleConfig.GroupBy)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (c *Config) CompileSLOs() ([]*SLO, error) {
+	slos := make([]*SLO, 0, len(c.SLOs))
+	names := map[string]bool{}
+	for i, sloConfig := range c.SLOs {
+		if sloConfig.Name == "" {
+			return nil, fmt.Errorf("slo %d has no name", i+1)
+		}
+		if names[sloConfig.Name] {
+			return nil, fmt.Errorf("slo %s is defined more than once", sloConfig.Name)
+		}
+		names[sloConfig.Name] = true
+		slo, err := sloConfig.compile()
+		if err != nil {
+			return nil, fmt.Errorf("slo %s: %s", sloConfig.Name, err.Error())
+		}
+		slos = append(slos, slo)
+	}
+	return slos, nil
+}
+
+func (s SLOConfig) compile() (*SLO, error) {
+	if s.Target <= 0 || s.Target >= 1 {
+		return nil, fmt.Errorf("target must be between 0 and 1, such as 0.999: %g", s.Target)
+	}
+	if s.Good == "" {
+		return nil, fmt.Errorf("good needs a selector such as status<500")
+	}
+	total := s.Total
+	if total == "" {
+		total = "all"
+	}
+	slo := &SLO{Name: s.Name, Target: s.Target, Period: DefaultSLOPeriod, BurnRates: DefaultBurnRates}
+
+	var err error
+	if slo.Total, err = CompileSelector(total); err != nil {
+		return nil, fmt.Errorf("total: %s", err.Error())
+	}
+	if slo.Good, err = CompileSelector(s.Good); err != nil {
+		return nil, fmt.Errorf("good: %s", err.Error())
+	}
+	if s.Period != "" {
+		if slo.Period, err = ParseConfigDuration(s.Period); err != nil || slo.Period <= 0 {
+			return nil, fmt.Errorf("invalid period: %s", s.Period)
+		}
+	}
+	if len(s.BurnRates) > 0 {
+		slo.BurnRates = nil
+	}
+	for _, burnRateConfig := range s.BurnRates {
+		burnRate := BurnRate{Factor: burnRateConfig.Factor}
+		if burnRate.Long, err = ParseConfigDuration(burnRateConfig.Long); err != nil || burnRate.Long <= 0 {
+			return nil, fmt.Errorf("invalid long window: %s", burnRateConfig.Long)
+		}
+		if burnRate.Short, err = ParseConfigDuration(burnRateConfig.Short); err != nil || burnRate.Short <= 0 || burnRate.Short > burnRate.Long {
+			return nil, fmt.Errorf("invalid short window: %s", burnRateConfig.Short)
+		}
+		if burnRate.Factor <= 0 {
+			return nil, fmt.Errorf("burn rate factor must be positive: %g", burnRate.Factor)
+		}
+		slo.BurnRates = append(slo.BurnRates, burnRate)
+	}
+	return slo, nil
+}
+
+func ParseConfigDuration(value string) (time.Duration, error) {
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		count, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
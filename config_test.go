@@ -0,0 +1,98 @@
+package main_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`Config`, func() {
+	It(`compiles the rules of the config`, func() {
+		config, err := ParseConfig([]byte(`{"rules": [
+			{"name": "api", "expr": "rate(hits{section=\"/api\"}[2m]) > 50", "for": "1m", "group_by": ["country"]}
+		]}`))
+		Expect(err).ToNot(HaveOccurred())
+		rules, err := config.CompileRules()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rules).To(HaveLen(1))
+		Expect(rules[0].Name).To(Equal("api"))
+		Expect(rules[0].For).To(Equal(time.Minute))
+		Expect(rules[0].Key()(Event{Country: "US"})).To(Equal("US"))
+	})
+
+	It(`loads the silences of the config`, func() {
+		config, err := ParseConfig([]byte(`{"silences": [
+			{"matchers": ["group=/api"], "starts_at": "2016-03-01T12:00:00Z", "ends_at": "2016-03-01T14:00:00Z", "comment": "deploy"}
+		]}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Silences).To(HaveLen(1))
+		Expect(config.Silences[0].Matchers).To(Equal([]string{"group=/api"}))
+		Expect(config.Silences[0].EndsAt).To(Equal(time.Date(2016, time.March, 1, 14, 0, 0, 0, time.UTC)))
+
+		manager := NewAlertManager(new(notificationMock))
+		_, err = manager.AddSilence(config.Silences[0])
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It(`compiles the slos of the config`, func() {
+		config, err := ParseConfig([]byte(`{"slos": [
+			{"name": "cart", "total": "hits{section=\"/cart.do\"}", "good": "status<500", "target": 0.999, "period": "28d", "burn_rates": [{"long": "1h", "short": "5m", "factor": 14.4}]}
+		]}`))
+		Expect(err).ToNot(HaveOccurred())
+		slos, err := config.CompileSLOs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(slos).To(HaveLen(1))
+		Expect(slos[0].Period).To(Equal(28 * 24 * time.Hour))
+		Expect(slos[0].BurnRates).To(Equal([]BurnRate{{Long: time.Hour, Short: 5 * time.Minute, Factor: 14.4}}))
+		Expect(slos[0].Total.Allow(Event{Path: "/cart.do"})).To(BeTrue())
+		Expect(slos[0].Good.Allow(Event{Path: "/cart.do", StatusCode: 503})).To(BeFalse())
+
+		config, err = ParseConfig([]byte(`{"slos": [{"name": "all", "good": "status<500", "target": 0.99}]}`))
+		Expect(err).ToNot(HaveOccurred())
+		slos, err = config.CompileSLOs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(slos[0].Period).To(Equal(DefaultSLOPeriod))
+		Expect(slos[0].BurnRates).To(Equal(DefaultBurnRates))
+	})
+
+	It(`compiles the templates of each notifier`, func() {
+		config, err := ParseConfig([]byte(`{"templates": {"webhook": {"alert": "{{.Title}}"}}}`))
+		Expect(err).ToNot(HaveOccurred())
+		message := NewErrorMessage("failure")
+		Expect(config.NotifierTemplates("webhook").Render(Message{Kind: AlertMessage, Title: "High traffic"})).To(Equal("High traffic"))
+		Expect(config.NotifierTemplates("webhook").Render(message)).To(Equal("failure\n"))
+		Expect(config.NotifierTemplates("console")).To(Equal(DefaultTemplates))
+	})
+
+	It(`rejects invalid configs`, func() {
+		for _, invalid := range []struct{ contents, message string }{
+			{`{"rules": [}`, `invalid config: invalid character '}' looking for beginning of value`},
+			{`{"rules": [{"expr": "count(hits[1m]) > 1"}]}`, `rule 1 has no name`},
+			{`{"rules": [{"name": "a", "expr": "count(hits[1m]) > 1"}, {"name": "a", "expr": "count(hits[1m]) > 2"}]}`, `rule a is defined more than once`},
+			{`{"rules": [{"name": "a", "expr": "count(hits[1m]) > 1", "for": "soon"}]}`, `rule a: invalid for: soon`},
+			{`{"rules": [{"name": "a", "expr": "count(hits[1m])"}]}`, `rule a: at character 1: the rule is a number instead of a condition; compare it with >, >=, <, <=, == or !=`},
+			{`{"slos": [{"good": "status<500", "target": 0.99}]}`, `slo 1 has no name`},
+			{`{"slos": [{"name": "a", "good": "status<500", "target": 99.9}]}`, `slo a: target must be between 0 and 1, such as 0.999: 99.9`},
+			{`{"slos": [{"name": "a", "target": 0.99}]}`, `slo a: good needs a selector such as status<500`},
+			{`{"slos": [{"name": "a", "good": "status<500", "total": "hits[5m]", "target": 0.99}]}`, `slo a: total: at character 1: a selector takes no window`},
+			{`{"slos": [{"name": "a", "good": "status<500", "target": 0.99, "period": "a month"}]}`, `slo a: invalid period: a month`},
+			{`{"slos": [{"name": "a", "good": "status<500", "target": 0.99, "burn_rates": [{"long": "5m", "short": "1h", "factor": 2}]}]}`, `slo a: invalid short window: 1h`},
+			{`{"templates": {"email": {"alert": "{{.Title}}"}}}`, `templates: unknown notifier: email`},
+			{`{"templates": {"console": {"page": "{{.Title}}"}}}`, `console templates: unknown message kind: page`},
+			{`{"templates": {"webhook": {"alert": "{{.Title"}}}`, `template: webhook alert:1: unclosed action`},
+			{`{"templates": {"console": {"summary": "{{.Sections}}"}}}`, `template: console summary:1:2: executing "console summary" at <.Sections>: can't evaluate field Sections in type main.Message`},
+			{`{"templates": {"console": {"error": "{{humanize .Title}}"}}}`, `template: console error:1: function "humanize" not defined`},
+		} {
+			config, err := ParseConfig([]byte(invalid.contents))
+			if err == nil {
+				_, err = config.CompileRules()
+			}
+			if err == nil {
+				_, err = config.CompileSLOs()
+			}
+			Expect(err).To(MatchError(invalid.message))
+		}
+	})
+})
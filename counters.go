@@ -0,0 +1,95 @@
+package main
+
+import "time"
+
+const DefaultCounterResolution = time.Second
+
+type WindowCounter struct {
+	window     time.Duration
+	resolution time.Duration
+
+	buckets []uint64
+	head    int64
+	total   uint64
+	started bool
+}
+
+func NewWindowCounter(window, resolution time.Duration) *WindowCounter {
+	if resolution <= 0 || resolution > window {
+		resolution = window
+	}
+	if resolution <= 0 {
+		resolution = 1
+	}
+	size := int64(window / resolution)
+	if window%resolution != 0 {
+		size++
+	}
+	return &WindowCounter{
+		window:     window,
+		resolution: resolution,
+		buckets:    make([]uint64, size+1),
+	}
+}
+
+func (w *WindowCounter) Add(at time.Time, count uint64) {
+	bucket := w.bucket(at)
+	w.advance(bucket)
+	if bucket <= w.head-int64(len(w.buckets)) {
+		return
+	}
+	w.buckets[w.index(bucket)] += count
+	w.total += count
+}
+
+func (w *WindowCounter) Count(now time.Time) uint64 {
+	w.advance(w.bucket(now))
+	if !w.started {
+		return 0
+	}
+	oldest := w.head - int64(len(w.buckets)) + 1
+	if now.Sub(time.Unix(0, oldest*int64(w.resolution))) > w.window {
+		return w.total - w.buckets[w.index(oldest)]
+	}
+	return w.total
+}
+
+func (w *WindowCounter) bucket(at time.Time) int64 {
+	nanoseconds := at.UnixNano()
+	bucket := nanoseconds / int64(w.resolution)
+	if nanoseconds < 0 && nanoseconds%int64(w.resolution) != 0 {
+		bucket--
+	}
+	return bucket
+}
+
+func (w *WindowCounter) index(bucket int64) int {
+	index := bucket % int64(len(w.buckets))
+	if index < 0 {
+		index += int64(len(w.buckets))
+	}
+	return int(index)
+}
+
+func (w *WindowCounter) advance(bucket int64) {
+	if !w.started {
+		w.head, w.started = bucket, true
+		return
+	}
+	if bucket <= w.head {
+		return
+	}
+	if bucket-w.head >= int64(len(w.buckets)) {
+		for i := range w.buckets {
+			w.buckets[i] = 0
+		}
+		w.total = 0
+	} else {
+		for expired := w.head + 1; expired <= bucket; expired++ {
+			index := w.index(expired)
+			w.total -= w.buckets[index]
+			w.buckets[index] = 0
+		}
+	}
+	w.head = bucket
+}
@@ -0,0 +1,117 @@
+package main_test
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`WindowCounter`, func() {
+	var (
+		counter *WindowCounter
+		start   time.Time
+	)
+
+	BeforeEach(func() {
+		counter = NewWindowCounter(time.Minute, time.Second)
+		start = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	It(`counts the events of the window, including its first instant`, func() {
+		counter.Add(start, 1)
+		counter.Add(start.Add(30*time.Second), 2)
+		Expect(counter.Count(start.Add(time.Minute))).To(Equal(uint64(3)))
+		Expect(counter.Count(start.Add(time.Minute + time.Second))).To(Equal(uint64(2)))
+		Expect(counter.Count(start.Add(2 * time.Minute))).To(BeZero())
+	})
+
+	It(`counts out of order events that are still in the window`, func() {
+		counter.Add(start.Add(time.Minute), 1)
+		counter.Add(start.Add(10*time.Second), 1)
+		counter.Add(start.Add(-time.Minute), 1)
+		Expect(counter.Count(start.Add(time.Minute))).To(Equal(uint64(2)))
+	})
+
+	It(`forgets everything after a gap longer than the window`, func() {
+		counter.Add(start, 5)
+		counter.Add(start.Add(time.Hour), 1)
+		Expect(counter.Count(start.Add(time.Hour))).To(Equal(uint64(1)))
+	})
+
+	It(`rounds the window to its resolution`, func() {
+		coarse := NewWindowCounter(time.Minute, 10*time.Second)
+		coarse.Add(start.Add(5*time.Second), 1)
+		Expect(coarse.Count(start.Add(time.Minute))).To(Equal(uint64(1)))
+		Expect(coarse.Count(start.Add(time.Minute + time.Second))).To(BeZero())
+	})
+})
+
+type sliceWindow struct {
+	duration time.Duration
+	events   []Event
+}
+
+func (s *sliceWindow) add(event Event) int {
+	s.events = append(s.events, event)
+	var lastGreatestIndex int
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if event.Time.Sub(s.events[i].Time) > s.duration {
+			lastGreatestIndex = i + 1
+			break
+		}
+	}
+	s.events = s.events[lastGreatestIndex:]
+	return len(s.events)
+}
+
+const (
+	benchmarkEventsPerSecond = 1000
+	benchmarkWindow          = 5 * time.Minute
+	benchmarkWindowEvents    = int(benchmarkWindow / time.Second * benchmarkEventsPerSecond)
+)
+
+func benchmarkEvents(i int) Event {
+	start := time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	return Event{Time: start.Add(time.Duration(i) * time.Second / benchmarkEventsPerSecond), Path: "/section1/page1"}
+}
+
+func BenchmarkWindowCounter(b *testing.B) {
+	counter := NewWindowCounter(benchmarkWindow, DefaultCounterResolution)
+	for i := 0; i < benchmarkWindowEvents; i++ {
+		counter.Add(benchmarkEvents(i).Time, 1)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := benchmarkWindowEvents; i < benchmarkWindowEvents+b.N; i++ {
+		event := benchmarkEvents(i)
+		counter.Add(event.Time, 1)
+		counter.Count(event.Time)
+	}
+	b.ReportMetric(float64((benchmarkWindow/DefaultCounterResolution+1)*8), "retained-B")
+}
+
+func BenchmarkSliceWindow(b *testing.B) {
+	window := &sliceWindow{duration: benchmarkWindow, events: make([]Event, benchmarkWindowEvents)}
+	for i := range window.events {
+		window.events[i] = benchmarkEvents(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := benchmarkWindowEvents; i < benchmarkWindowEvents+b.N; i++ {
+		window.add(benchmarkEvents(i))
+	}
+	b.ReportMetric(float64(uintptr(len(window.events))*unsafe.Sizeof(Event{})), "retained-B")
+}
+
+func BenchmarkTotalTrafficAlert(b *testing.B) {
+	alert := NewTotalTrafficAlert(1000000, benchmarkWindow, new(notificationMock))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		alert.Check(benchmarkEvents(i))
+	}
+}
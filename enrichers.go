@@ -0,0 +1,195 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+)
+
+const (
+	DesktopDevice = "desktop"
+	MobileDevice  = "mobile"
+	TabletDevice  = "tablet"
+	BotDevice     = "bot"
+
+	UnknownUserAgent = "Other"
+)
+
+type Enricher interface {
+	Enrich(*Event)
+}
+
+type UserAgent struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	Device         string
+	Bot            bool
+}
+
+type userAgentSignature struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+
+	matcher *regexp.Regexp
+}
+
+func (s *userAgentSignature) match(userAgent string) (bool, string) {
+	matches := s.matcher.FindStringSubmatch(userAgent)
+	if matches == nil {
+		return false, ""
+	}
+	if len(matches) > 1 {
+		return true, matches[1]
+	}
+	return true, ""
+}
+
+type UserAgentDatabase struct {
+	Bots     []*userAgentSignature `json:"bots"`
+	Browsers []*userAgentSignature `json:"browsers"`
+	OS       []*userAgentSignature `json:"os"`
+	Devices  []*userAgentSignature `json:"devices"`
+}
+
+func LoadUserAgentDatabase(filename string) (*UserAgentDatabase, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	database := &UserAgentDatabase{}
+	if err := json.NewDecoder(file).Decode(database); err != nil {
+		return nil, fmt.Errorf("could not decode user agent database %s: %s", filename, err.Error())
+	}
+	for _, signatures := range [][]*userAgentSignature{database.Bots, database.Browsers, database.OS, database.Devices} {
+		for _, signature := range signatures {
+			if signature.matcher, err = regexp.Compile(signature.Pattern); err != nil {
+				return nil, fmt.Errorf("invalid pattern for %s: %s", signature.Name, err.Error())
+			}
+		}
+	}
+	return database, nil
+}
+
+func (d *UserAgentDatabase) Parse(userAgent string) UserAgent {
+	parsed := UserAgent{
+		Browser: UnknownUserAgent,
+		OS:      UnknownUserAgent,
+		Device:  DesktopDevice,
+	}
+	for _, signature := range d.OS {
+		if ok, _ := signature.match(userAgent); ok {
+			parsed.OS = signature.Name
+			break
+		}
+	}
+	for _, signature := range d.Bots {
+		if ok, version := signature.match(userAgent); ok {
+			parsed.Browser = signature.Name
+			parsed.BrowserVersion = version
+			parsed.Device = BotDevice
+			parsed.Bot = true
+			return parsed
+		}
+	}
+	for _, signature := range d.Browsers {
+		if ok, version := signature.match(userAgent); ok {
+			parsed.Browser = signature.Name
+			parsed.BrowserVersion = version
+			break
+		}
+	}
+	for _, signature := range d.Devices {
+		if ok, _ := signature.match(userAgent); ok {
+			parsed.Device = signature.Name
+			break
+		}
+	}
+	return parsed
+}
+
+type UserAgentEnricher struct {
+	database *UserAgentDatabase
+	cache    *lruCache
+}
+
+func NewUserAgentEnricher(database *UserAgentDatabase, cacheSize int) *UserAgentEnricher {
+	return &UserAgentEnricher{
+		database: database,
+		cache:    newLRUCache(cacheSize),
+	}
+}
+
+func (u *UserAgentEnricher) Enrich(event *Event) {
+	var userAgent UserAgent
+	if cached, ok := u.cache.get(event.UserAgent); ok {
+		userAgent = cached.(UserAgent)
+	} else {
+		userAgent = u.database.Parse(event.UserAgent)
+		u.cache.add(event.UserAgent, userAgent)
+	}
+
+	event.Browser = userAgent.Browser
+	event.BrowserVersion = userAgent.BrowserVersion
+	event.OS = userAgent.OS
+	event.Device = userAgent.Device
+	event.Bot = userAgent.Bot
+}
+
+type lruCache struct {
+	size int
+
+	mutex   sync.Mutex
+	entries *list.List
+	index   map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		entries: list.New(),
+		index:   map[string]*list.Element{},
+	}
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.index[key]
+	if !ok {
+		return nil, false
+	}
+	c.entries.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+func (c *lruCache) add(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.size <= 0 {
+		return
+	}
+	if element, ok := c.index[key]; ok {
+		element.Value.(*lruEntry).value = value
+		c.entries.MoveToFront(element)
+		return
+	}
+	c.index[key] = c.entries.PushFront(&lruEntry{key: key, value: value})
+	if c.entries.Len() > c.size {
+		oldest := c.entries.Back()
+		c.entries.Remove(oldest)
+		delete(c.index, oldest.Value.(*lruEntry).key)
+	}
+}
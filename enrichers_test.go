@@ -0,0 +1,107 @@
+package main_test
+
+import (
+	"log"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`UserAgentEnricher`, func() {
+	var (
+		enricher Enricher
+		database *UserAgentDatabase
+		err      error
+	)
+
+	BeforeEach(func() {
+		database, err = LoadUserAgentDatabase("user_agents.json")
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		enricher = NewUserAgentEnricher(database, 2)
+	})
+
+	Describe(`#Enrich`, func() {
+		DescribeTable(`parses the user agent into browser, os, device and bot flags`,
+			func(userAgent string, expected UserAgent) {
+				event := Event{UserAgent: userAgent}
+				enricher.Enrich(&event)
+				Expect(UserAgent{
+					Browser:        event.Browser,
+					BrowserVersion: event.BrowserVersion,
+					OS:             event.OS,
+					Device:         event.Device,
+					Bot:            event.Bot,
+				}).To(Equal(expected))
+			},
+			Entry(`desktop chrome`,
+				"Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/536.5 (KHTML, like Gecko) Chrome/19.0.1084.46 Safari/536.5",
+				UserAgent{Browser: "Chrome", BrowserVersion: "19.0.1084.46", OS: "Windows", Device: DesktopDevice},
+			),
+			Entry(`mobile safari`,
+				"Mozilla/5.0 (iPhone; CPU iPhone OS 9_1 like Mac OS X) AppleWebKit/601.1.46 (KHTML, like Gecko) Version/9.0 Mobile/13B143 Safari/601.1",
+				UserAgent{Browser: "Safari", BrowserVersion: "9.0", OS: "iOS", Device: MobileDevice},
+			),
+			Entry(`tablet safari`,
+				"Mozilla/5.0 (iPad; CPU OS 9_1 like Mac OS X) AppleWebKit/601.1.46 (KHTML, like Gecko) Version/9.0 Mobile/13B143 Safari/601.1",
+				UserAgent{Browser: "Safari", BrowserVersion: "9.0", OS: "iOS", Device: TabletDevice},
+			),
+			Entry(`firefox on linux`,
+				"Mozilla/5.0 (X11; Linux x86_64; rv:43.0) Gecko/20100101 Firefox/43.0",
+				UserAgent{Browser: "Firefox", BrowserVersion: "43.0", OS: "Linux", Device: DesktopDevice},
+			),
+			Entry(`googlebot`,
+				"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+				UserAgent{Browser: "Googlebot", BrowserVersion: "2.1", OS: UnknownUserAgent, Device: BotDevice, Bot: true},
+			),
+			Entry(`bingbot`,
+				"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)",
+				UserAgent{Browser: "bingbot", BrowserVersion: "2.0", OS: UnknownUserAgent, Device: BotDevice, Bot: true},
+			),
+			Entry(`curl`,
+				"curl/7.43.0",
+				UserAgent{Browser: "curl", BrowserVersion: "7.43.0", OS: UnknownUserAgent, Device: BotDevice, Bot: true},
+			),
+			Entry(`python-requests`,
+				"python-requests/2.9.1",
+				UserAgent{Browser: "python-requests", BrowserVersion: "2.9.1", OS: UnknownUserAgent, Device: BotDevice, Bot: true},
+			),
+			Entry(`unknown`,
+				"",
+				UserAgent{Browser: UnknownUserAgent, OS: UnknownUserAgent, Device: DesktopDevice},
+			),
+		)
+
+		Context(`when the user agent has been parsed before`, func() {
+			var first, second Event
+
+			BeforeEach(func() {
+				first = Event{UserAgent: "curl/7.43.0"}
+				second = Event{UserAgent: "curl/7.43.0"}
+			})
+
+			JustBeforeEach(func() {
+				enricher.Enrich(&first)
+				enricher.Enrich(&Event{UserAgent: "python-requests/2.9.1"})
+				enricher.Enrich(&Event{UserAgent: "Wget/1.17"})
+				enricher.Enrich(&second)
+			})
+
+			It(`enriches the event the same way after eviction from the cache`, func() {
+				Expect(second).To(Equal(first))
+			})
+		})
+	})
+})
+
+var _ = Describe(`LoadUserAgentDatabase`, func() {
+	Context(`when the database does not exist`, func() {
+		It(`returns an error`, func() {
+			_, err := LoadUserAgentDatabase("missing.json")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -1,6 +1,12 @@
 package main
 
-import "time"
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Event struct {
 	Client      string
@@ -15,4 +21,142 @@ type Event struct {
 	UserAgent   string
 	Referer     string
 	Host        string
+	Duration    time.Duration
+
+	Browser        string
+	BrowserVersion string
+	OS             string
+	Device         string
+	Bot            bool
+
+	Country      string
+	City         string
+	ASN          uint
+	Organization string
+}
+
+type EventKey func(Event) string
+
+var eventKeys = map[string]EventKey{
+	"section": SectionKey,
+	"browser": BrowserKey,
+	"os":      OSKey,
+	"device":  DeviceKey,
+	"bot":     BotKey,
+	"country": CountryKey,
+	"city":    CityKey,
+	"asn":     ASNKey,
+	"org":     OrganizationKey,
+	"client":  ClientKey,
+}
+
+func EventKeyByName(name string) (EventKey, error) {
+	key, ok := eventKeys[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown event key: %s", name)
+	}
+	return key, nil
+}
+
+func StatusClass(statusCode int) string {
+	switch {
+	case statusCode >= 500:
+		return "5xx"
+	case statusCode >= 400:
+		return "4xx"
+	case statusCode >= 300:
+		return "3xx"
+	case statusCode >= 200:
+		return "2xx"
+	case statusCode >= 100:
+		return "1xx"
+	}
+	return ""
+}
+
+func SectionKey(event Event) string {
+	eventURL, err := url.Parse(event.Path)
+	if err != nil {
+		return ""
+	}
+	pathSections := strings.Split(eventURL.Path, "/")
+	if len(pathSections) < 2 {
+		return ""
+	}
+	return strings.Join(pathSections[0:2], "/")
+}
+
+func BrowserKey(event Event) string {
+	if event.Browser == "" {
+		return ""
+	}
+	return "browser:" + event.Browser
+}
+
+func OSKey(event Event) string {
+	if event.OS == "" {
+		return ""
+	}
+	return "os:" + event.OS
+}
+
+func DeviceKey(event Event) string {
+	if event.Device == "" {
+		return ""
+	}
+	return "device:" + event.Device
+}
+
+func BotKey(event Event) string {
+	if event.Device == "" {
+		return ""
+	}
+	return "bot:" + strconv.FormatBool(event.Bot)
+}
+
+func CountryKey(event Event) string {
+	if event.Country == "" {
+		return ""
+	}
+	return "country:" + event.Country
+}
+
+func CityKey(event Event) string {
+	if event.City == "" {
+		return ""
+	}
+	return "city:" + event.City
+}
+
+func ASNKey(event Event) string {
+	if event.ASN == 0 {
+		return ""
+	}
+	return "asn:" + strconv.FormatUint(uint64(event.ASN), 10)
+}
+
+func OrganizationKey(event Event) string {
+	if event.Organization == "" {
+		return ""
+	}
+	return "org:" + event.Organization
+}
+
+func ClientKey(event Event) string {
+	if event.Client == "" {
+		return ""
+	}
+	return "client:" + event.Client
+}
+
+func JoinKeys(keys ...EventKey) EventKey {
+	return func(event Event) string {
+		groups := make([]string, len(keys))
+		for i, key := range keys {
+			if groups[i] = key(event); groups[i] == "" {
+				return ""
+			}
+		}
+		return strings.Join(groups, " ")
+	}
 }
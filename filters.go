@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Filter interface {
+	Allow(Event) bool
+}
+
+var BotFilter = NewEventFilter(func(event Event) bool {
+	return !event.Bot
+})
+
+type EventFilter struct {
+	allowFn AllowFn
+}
+
+type AllowFn func(Event) bool
+
+func NewEventFilter(fn AllowFn) *EventFilter {
+	return &EventFilter{allowFn: fn}
+}
+
+func (e *EventFilter) Allow(event Event) bool {
+	return e.allowFn(event)
+}
+
+func NewKeyFilter(key EventKey, group string) *EventFilter {
+	return NewEventFilter(func(event Event) bool {
+		return key(event) == group
+	})
+}
+
+func ParseKeyFilter(spec string) (*EventFilter, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid key filter, expected key=group: %s", spec)
+	}
+	key, err := EventKeyByName(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	name, group := parts[0], parts[1]
+	return NewEventFilter(func(event Event) bool {
+		value := key(event)
+		return value == group || value == name+":"+group
+	}), nil
+}
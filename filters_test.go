@@ -0,0 +1,35 @@
+package main_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`BotFilter`, func() {
+	Describe(`#Allow`, func() {
+		It(`drops events from bots`, func() {
+			Expect(BotFilter.Allow(Event{Bot: true})).To(BeFalse())
+		})
+
+		It(`allows events from browsers`, func() {
+			Expect(BotFilter.Allow(Event{Bot: false})).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe(`ParseKeyFilter`, func() {
+	It(`allows the events of one group`, func() {
+		filter, err := ParseKeyFilter("country=US")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filter.Allow(Event{Country: "US"})).To(BeTrue())
+		Expect(filter.Allow(Event{Country: "FR"})).To(BeFalse())
+	})
+
+	It(`rejects unknown keys and missing groups`, func() {
+		_, err := ParseKeyFilter("planet=earth")
+		Expect(err).To(MatchError("unknown event key: planet"))
+		_, err = ParseKeyFilter("country")
+		Expect(err).To(HaveOccurred())
+	})
+})
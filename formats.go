@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const CombinedLogFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`
+
+type FormatError struct {
+	Offset   int
+	Variable string
+	Reason   string
+}
+
+func (f *FormatError) Error() string {
+	if f.Variable == "" {
+		return fmt.Sprintf("at character %d: %s", f.Offset+1, f.Reason)
+	}
+	return fmt.Sprintf("at character %d: $%s %s", f.Offset+1, f.Variable, f.Reason)
+}
+
+type formatField func(*Event, string) error
+
+var formatFields = map[string]formatField{
+	"remote_addr":     func(e *Event, value string) error { e.Client = value; return nil },
+	"remote_user":     func(e *Event, value string) error { e.User = dashless(value); return nil },
+	"time_local":      parseFormatTime(commonLogTimeFormat, logDateFormatWithoutTimezone),
+	"time_iso8601":    parseFormatTime(time.RFC3339),
+	"msec":            parseMsec,
+	"request":         parseRequest,
+	"request_method":  func(e *Event, value string) error { e.Method = value; return nil },
+	"request_uri":     func(e *Event, value string) error { e.Path = value; return nil },
+	"uri":             func(e *Event, value string) error { e.Path = value; return nil },
+	"server_protocol": func(e *Event, value string) error { e.Protocol = value; return nil },
+	"status":          parseFormatInt(func(e *Event, value int) { e.StatusCode = value }),
+	"body_bytes_sent": parseFormatInt(func(e *Event, value int) { e.PayloadSize = value }),
+	"bytes_sent":      parseFormatInt(func(e *Event, value int) { e.PayloadSize = value }),
+	"http_referer":    func(e *Event, value string) error { e.Referer = dashless(value); return nil },
+	"http_user_agent": func(e *Event, value string) error { e.UserAgent = dashless(value); return nil },
+	"host":            func(e *Event, value string) error { e.Host = value; return nil },
+	"http_host":       func(e *Event, value string) error { e.Host = value; return nil },
+	"server_name":     func(e *Event, value string) error { e.Host = value; return nil },
+	"request_time":    parseRequestTime,
+}
+
+type formatToken struct {
+	literal  string
+	variable string
+}
+
+type LogFormat struct {
+	spec    string
+	tokens  []formatToken
+	ignored []string
+}
+
+func CompileLogFormat(spec string) (*LogFormat, error) {
+	format := &LogFormat{spec: spec}
+	var literal strings.Builder
+	for i := 0; i < len(spec); {
+		if spec[i] != '$' {
+			literal.WriteByte(spec[i])
+			i++
+			continue
+		}
+
+		name, next, err := scanFormatVariable(spec, i)
+		if err != nil {
+			return nil, err
+		}
+		if literal.Len() > 0 {
+			format.tokens = append(format.tokens, formatToken{literal: literal.String()})
+			literal.Reset()
+		}
+		if len(format.tokens) > 0 && format.tokens[len(format.tokens)-1].variable != "" {
+			return nil, fmt.Errorf("log format variables $%s and $%s need text between them", format.tokens[len(format.tokens)-1].variable, name)
+		}
+		if _, ok := formatFields[name]; !ok {
+			format.ignored = append(format.ignored, name)
+		}
+		format.tokens = append(format.tokens, formatToken{variable: name})
+		i = next
+	}
+	if literal.Len() > 0 {
+		format.tokens = append(format.tokens, formatToken{literal: literal.String()})
+	}
+	if len(format.tokens) == 0 {
+		return nil, fmt.Errorf("empty log format")
+	}
+	return format, nil
+}
+
+func scanFormatVariable(spec string, start int) (string, int, error) {
+	if start+1 < len(spec) && spec[start+1] == '{' {
+		end := strings.IndexByte(spec[start:], '}')
+		if end < 0 {
+			return "", 0, fmt.Errorf("unterminated log format variable at character %d", start+1)
+		}
+		return spec[start+2 : start+end], start + end + 1, nil
+	}
+	end := start + 1
+	for end < len(spec) && isVariableCharacter(spec[end]) {
+		end++
+	}
+	if end == start+1 {
+		return "", 0, fmt.Errorf("missing log format variable name at character %d", start+1)
+	}
+	return spec[start+1 : end], end, nil
+}
+
+func isVariableCharacter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || isDigit(c) || c == '_'
+}
+
+func (l *LogFormat) String() string {
+	return l.spec
+}
+
+func (l *LogFormat) Ignored() []string {
+	return l.ignored
+}
+
+func (l *LogFormat) Parse(line string) (Event, error) {
+	var event Event
+	position := 0
+	for i, token := range l.tokens {
+		if token.literal != "" {
+			if !strings.HasPrefix(line[position:], token.literal) {
+				return Event{}, &FormatError{Offset: position, Reason: fmt.Sprintf("expected %q", token.literal)}
+			}
+			position += len(token.literal)
+			continue
+		}
+
+		end := len(line)
+		if i+1 < len(l.tokens) {
+			find := strings.Index
+			if i+2 == len(l.tokens) {
+				find = strings.LastIndex
+			}
+			next := find(line[position:], l.tokens[i+1].literal)
+			if next < 0 {
+				return Event{}, &FormatError{Offset: position, Variable: token.variable, Reason: fmt.Sprintf("is not followed by %q", l.tokens[i+1].literal)}
+			}
+			end = position + next
+		}
+		if field, ok := formatFields[token.variable]; ok {
+			if err := field(&event, line[position:end]); err != nil {
+				return Event{}, &FormatError{Offset: position, Variable: token.variable, Reason: err.Error()}
+			}
+		}
+		position = end
+	}
+	if position != len(line) {
+		return Event{}, &FormatError{Offset: position, Reason: "unexpected text at the end of the line"}
+	}
+	if event.Time.IsZero() {
+		return Event{}, errTimeNotPresent
+	}
+	return event, nil
+}
+
+func dashless(value string) string {
+	if value == "-" {
+		return ""
+	}
+	return value
+}
+
+func parseFormatTime(layouts ...string) formatField {
+	return func(e *Event, value string) error {
+		for _, layout := range layouts {
+			if eventTime, err := time.Parse(layout, value); err == nil {
+				e.Time = eventTime
+				return nil
+			}
+		}
+		return fmt.Errorf("is not a time like %s", layouts[0])
+	}
+}
+
+func parseMsec(e *Event, value string) error {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("is not seconds since the epoch")
+	}
+	whole, fraction := math.Modf(seconds)
+	e.Time = time.Unix(int64(whole), int64(math.Round(fraction*1e3))*int64(time.Millisecond)).UTC()
+	return nil
+}
+
+func parseRequest(e *Event, value string) error {
+	parts := strings.SplitN(value, " ", 3)
+	if len(parts) < 2 {
+		return fmt.Errorf("is not a method, path and protocol")
+	}
+	e.Method, e.Path = parts[0], parts[1]
+	if len(parts) == 3 {
+		e.Protocol = parts[2]
+	}
+	return nil
+}
+
+func parseFormatInt(set func(*Event, int)) formatField {
+	return func(e *Event, value string) error {
+		if value == "-" {
+			return nil
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("is not an integer")
+		}
+		set(e, number)
+		return nil
+	}
+}
+
+func parseRequestTime(e *Event, value string) error {
+	if value == "-" {
+		return nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("is not a number of seconds")
+	}
+	e.Duration = time.Duration(math.Round(seconds*1e6)) * time.Microsecond
+	return nil
+}
+
+type FormatCheck struct {
+	Parsed int
+	Total  int
+}
+
+func (f FormatCheck) Ratio() float64 {
+	if f.Total == 0 {
+		return 0
+	}
+	return float64(f.Parsed) / float64(f.Total)
+}
+
+func (f FormatCheck) String() string {
+	return fmt.Sprintf("parsed %d of %d lines (%.1f%%)", f.Parsed, f.Total, f.Ratio()*100)
+}
+
+func CheckLogFormat(lines io.Reader, output io.Writer, parse LineParser, failuresOnly bool) (FormatCheck, error) {
+	var check FormatCheck
+	scanner := bufio.NewScanner(lines)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		check.Total++
+		event, err := parse(line)
+		if err != nil {
+			fmt.Fprintf(output, "line %d: %s\n", check.Total, err)
+			fmt.Fprintf(output, "  %s\n", line)
+			if formatError, ok := err.(*FormatError); ok {
+				fmt.Fprintf(output, "  %s^\n", strings.Repeat(" ", formatError.Offset))
+			}
+			continue
+		}
+		check.Parsed++
+		if !failuresOnly {
+			fmt.Fprintf(output, "line %d: ok\n", check.Total)
+			writeEventFields(output, event)
+		}
+	}
+	return check, scanner.Err()
+}
+
+func writeEventFields(output io.Writer, event Event) {
+	fields := tabwriter.NewWriter(output, 0, 8, 1, ' ', 0)
+	for _, field := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"Client", event.Client},
+		{"User", event.User},
+		{"Time", event.Time},
+		{"Method", event.Method},
+		{"Path", event.Path},
+		{"Protocol", event.Protocol},
+		{"StatusCode", event.StatusCode},
+		{"PayloadSize", event.PayloadSize},
+		{"Referer", event.Referer},
+		{"UserAgent", event.UserAgent},
+		{"Host", event.Host},
+		{"Duration", event.Duration},
+	} {
+		fmt.Fprintf(fields, "  %s:\t%v\n", field.name, field.value)
+	}
+	fields.Flush()
+}
@@ -0,0 +1,125 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`LogFormat`, func() {
+	var (
+		logFormat *LogFormat
+		spec      string
+	)
+
+	JustBeforeEach(func() {
+		var err error
+		logFormat, err = CompileLogFormat(spec)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context(`with the combined log format`, func() {
+		BeforeEach(func() {
+			spec = CombinedLogFormat
+		})
+
+		It(`parses the lines of the sample log like the regexp parser`, func() {
+			for _, line := range readLogLines("sample.log") {
+				expected, err := ParseLogLine(line)
+				Expect(err).NotTo(HaveOccurred())
+				event, err := logFormat.Parse(line)
+				Expect(err).NotTo(HaveOccurred(), line)
+				Expect(event.Time.Equal(expected.Time)).To(BeTrue())
+				Expect(event.Path).To(Equal(expected.Path))
+				Expect(event.StatusCode).To(Equal(expected.StatusCode))
+				Expect(event.PayloadSize).To(Equal(expected.PayloadSize))
+				Expect(event.UserAgent).To(Equal(expected.UserAgent))
+			}
+		})
+
+		It(`parses the lines it formats`, func() {
+			event := Event{
+				Client:      "10.0.0.1",
+				Time:        time.Date(2015, time.December, 23, 18, 22, 21, 0, time.FixedZone("", 3600)),
+				Method:      "GET",
+				Path:        "/api/users?page=2",
+				Protocol:    "HTTP/1.1",
+				StatusCode:  404,
+				PayloadSize: 12,
+				Referer:     "http://example.com/",
+				UserAgent:   "curl/7.43.0",
+			}
+			parsed, err := logFormat.Parse(FormatCommonLogLine(event))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.Time.Equal(event.Time)).To(BeTrue())
+			parsed.Time = event.Time
+			Expect(parsed).To(Equal(event))
+		})
+
+		It(`points at the character where the line stops matching`, func() {
+			_, err := logFormat.Parse(`10.0.0.1 - - [23/Dec/2015:18:22:21 +0000] "GET / HTTP/1.1" OK 12 "-" "curl/7.43.0"`)
+			Expect(err).To(Equal(&FormatError{Offset: 59, Variable: "status", Reason: "is not an integer"}))
+
+			_, err = logFormat.Parse(`10.0.0.1 - - 23/Dec/2015:18:22:21 +0000 "GET / HTTP/1.1" 200 12 "-" "curl/7.43.0"`)
+			Expect(err).To(Equal(&FormatError{Offset: 11, Variable: "remote_user", Reason: `is not followed by " ["`}))
+			Expect(err.Error()).To(Equal(`at character 12: $remote_user is not followed by " ["`))
+		})
+	})
+
+	Context(`with the request time and unknown variables`, func() {
+		BeforeEach(func() {
+			spec = `${time_iso8601} ${host} "$request" $status $bytes_sent $request_time $upstream_addr`
+		})
+
+		It(`parses the request duration and ignores the unknown variables`, func() {
+			event, err := logFormat.Parse(`2015-12-23T18:22:21Z example.com "POST /cart HTTP/2.0" 201 - 0.250 10.1.1.1:8080`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(event).To(Equal(Event{
+				Time:       time.Date(2015, time.December, 23, 18, 22, 21, 0, time.UTC),
+				Host:       "example.com",
+				Method:     "POST",
+				Path:       "/cart",
+				Protocol:   "HTTP/2.0",
+				StatusCode: 201,
+				Duration:   250 * time.Millisecond,
+			}))
+			Expect(logFormat.Ignored()).To(Equal([]string{"upstream_addr"}))
+		})
+	})
+})
+
+var _ = Describe(`CompileLogFormat`, func() {
+	It(`rejects malformed specifications`, func() {
+		for _, spec := range []string{``, `$status$body_bytes_sent`, `${status`, `$ $status`} {
+			_, err := CompileLogFormat(spec)
+			Expect(err).To(HaveOccurred(), spec)
+		}
+	})
+})
+
+var _ = Describe(`CheckLogFormat`, func() {
+	It(`prints the parsed fields and the failures and counts the parsed lines`, func() {
+		logFormat, err := CompileLogFormat(`$remote_addr [$time_local] $status`)
+		Expect(err).NotTo(HaveOccurred())
+		lines := strings.Join([]string{
+			`10.0.0.1 [23/Dec/2015:18:22:21 +0000] 200`,
+			`10.0.0.1 [23/Dec/2015:18:22:21 +0000] ok`,
+		}, "\n")
+
+		var output bytes.Buffer
+		check, err := CheckLogFormat(strings.NewReader(lines), &output, logFormat.Parse, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(check).To(Equal(FormatCheck{Parsed: 1, Total: 2}))
+		Expect(check.String()).To(Equal("parsed 1 of 2 lines (50.0%)"))
+		Expect(output.String()).To(And(
+			ContainSubstring("line 1: ok\n"),
+			MatchRegexp(`StatusCode:\s+200`),
+			ContainSubstring("line 2: at character 39: $status is not an integer\n"),
+			ContainSubstring("\n  "+strings.Repeat(" ", 38)+"^\n"),
+		))
+	})
+})
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	diurnalPeakHour = 14
+	pagesPerSection = 20
+)
+
+var generatedUserAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+	"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+	"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+	"curl/7.43.0",
+}
+
+type WeightedSection struct {
+	Section string
+	Weight  float64
+}
+
+type WeightedStatus struct {
+	StatusCode int
+	Weight     float64
+}
+
+type TrafficSpike struct {
+	Offset     time.Duration
+	Duration   time.Duration
+	Multiplier float64
+	Section    string
+}
+
+type TrafficProfile struct {
+	Seed     int64
+	Start    time.Time
+	Duration time.Duration
+	Rate     float64
+	Diurnal  float64
+	Clients  int
+	Sections []WeightedSection
+	Statuses []WeightedStatus
+	Spikes   []TrafficSpike
+}
+
+func ParseWeightedSections(value string) ([]WeightedSection, error) {
+	var sections []WeightedSection
+	err := parseWeights(value, func(name string, weight float64) error {
+		if !strings.HasPrefix(name, "/") {
+			return fmt.Errorf("section %s does not start with /", name)
+		}
+		sections = append(sections, WeightedSection{Section: name, Weight: weight})
+		return nil
+	})
+	return sections, err
+}
+
+func ParseWeightedStatuses(value string) ([]WeightedStatus, error) {
+	var statuses []WeightedStatus
+	err := parseWeights(value, func(name string, weight float64) error {
+		statusCode, err := strconv.Atoi(name)
+		if err != nil || statusCode < 100 || statusCode > 599 {
+			return fmt.Errorf("invalid status code: %s", name)
+		}
+		statuses = append(statuses, WeightedStatus{StatusCode: statusCode, Weight: weight})
+		return nil
+	})
+	return statuses, err
+}
+
+func parseWeights(value string, add func(string, float64) error) error {
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid weighted entry: %s", entry)
+		}
+		weight, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || weight < 0 {
+			return fmt.Errorf("invalid weight: %s", entry)
+		}
+		if err := add(parts[0], weight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ParseTrafficSpike(value string) (TrafficSpike, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) < 3 || len(parts) > 4 {
+		return TrafficSpike{}, fmt.Errorf("invalid spike, expected offset,duration,multiplier[,section]: %s", value)
+	}
+	offset, err := time.ParseDuration(parts[0])
+	if err != nil {
+		return TrafficSpike{}, err
+	}
+	duration, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return TrafficSpike{}, err
+	}
+	multiplier, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil || multiplier < 0 {
+		return TrafficSpike{}, fmt.Errorf("invalid spike multiplier: %s", parts[2])
+	}
+	spike := TrafficSpike{Offset: offset, Duration: duration, Multiplier: multiplier}
+	if len(parts) == 4 {
+		spike.Section = parts[3]
+	}
+	return spike, nil
+}
+
+type TrafficGenerator struct {
+	profile TrafficProfile
+	random  *rand.Rand
+	clients *rand.Zipf
+	pages   *rand.Zipf
+	peak    float64
+	now     time.Time
+	end     time.Time
+}
+
+func NewTrafficGenerator(profile TrafficProfile) *TrafficGenerator {
+	if profile.Clients < 1 {
+		profile.Clients = 1
+	}
+	random := rand.New(rand.NewSource(profile.Seed))
+	generator := &TrafficGenerator{
+		profile: profile,
+		random:  random,
+		clients: rand.NewZipf(random, 1.1, 1, uint64(profile.Clients-1)),
+		pages:   rand.NewZipf(random, 1.2, 1, pagesPerSection-1),
+		now:     profile.Start,
+		end:     profile.Start.Add(profile.Duration),
+	}
+
+	multiplier := 1.0
+	for _, spike := range profile.Spikes {
+		multiplier *= math.Max(spike.Multiplier, 1)
+	}
+	generator.peak = profile.Rate * (1 + math.Abs(profile.Diurnal)) * multiplier
+	return generator
+}
+
+func (t *TrafficGenerator) Next() (Event, bool) {
+	if t.peak <= 0 {
+		return Event{}, false
+	}
+	for {
+		t.now = t.now.Add(time.Duration(t.random.ExpFloat64() / t.peak * float64(time.Second)))
+		if !t.now.Before(t.end) {
+			return Event{}, false
+		}
+		rate, spikes := t.rates(t.now)
+		if t.random.Float64()*t.peak >= rate {
+			continue
+		}
+		return t.event(t.now, t.section(rate, spikes)), true
+	}
+}
+
+func (t *TrafficGenerator) rates(now time.Time) (float64, []WeightedSection) {
+	hours := float64(now.Hour()) + float64(now.Minute())/60
+	base := math.Max(t.profile.Rate*(1+t.profile.Diurnal*math.Cos(2*math.Pi*(hours-diurnalPeakHour)/24)), 0)
+
+	multiplier := 1.0
+	var spikes []WeightedSection
+	elapsed := now.Sub(t.profile.Start)
+	for _, spike := range t.profile.Spikes {
+		if elapsed < spike.Offset || elapsed >= spike.Offset+spike.Duration {
+			continue
+		}
+		if spike.Section == "" {
+			multiplier *= spike.Multiplier
+		} else if spike.Multiplier > 1 {
+			spikes = append(spikes, WeightedSection{Section: spike.Section, Weight: base * (spike.Multiplier - 1)})
+		}
+	}
+
+	rate := base * multiplier
+	for _, spike := range spikes {
+		rate += spike.Weight
+	}
+	return rate, spikes
+}
+
+func (t *TrafficGenerator) section(rate float64, spikes []WeightedSection) string {
+	choice := t.random.Float64() * rate
+	for _, spike := range spikes {
+		if choice -= spike.Weight; choice < 0 {
+			return spike.Section
+		}
+	}
+
+	var total float64
+	for _, section := range t.profile.Sections {
+		total += section.Weight
+	}
+	choice = t.random.Float64() * total
+	for _, section := range t.profile.Sections {
+		if choice -= section.Weight; choice < 0 {
+			return section.Section
+		}
+	}
+	return "/"
+}
+
+func (t *TrafficGenerator) statusCode() int {
+	var total float64
+	for _, status := range t.profile.Statuses {
+		total += status.Weight
+	}
+	choice := t.random.Float64() * total
+	for _, status := range t.profile.Statuses {
+		if choice -= status.Weight; choice < 0 {
+			return status.StatusCode
+		}
+	}
+	return 200
+}
+
+func (t *TrafficGenerator) event(now time.Time, section string) Event {
+	client := t.clients.Uint64()
+	method := "GET"
+	if t.random.Float64() < 0.1 {
+		method = "POST"
+	}
+	statusCode := t.statusCode()
+	payloadSize := int(math.Exp(7 + t.random.NormFloat64()))
+	if statusCode == 204 || statusCode == 304 {
+		payloadSize = 0
+	}
+	return Event{
+		Client:      fmt.Sprintf("10.%d.%d.%d", client>>16&0xff, client>>8&0xff, client&0xff),
+		Time:        now,
+		Method:      method,
+		Path:        fmt.Sprintf("%s/page%d", strings.TrimSuffix(section, "/"), t.pages.Uint64()+1),
+		Protocol:    "HTTP/1.1",
+		StatusCode:  statusCode,
+		PayloadSize: payloadSize,
+		UserAgent:   generatedUserAgents[t.random.Intn(len(generatedUserAgents))],
+		Duration:    time.Duration(math.Exp(3+0.8*t.random.NormFloat64())) * time.Millisecond,
+	}
+}
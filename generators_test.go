@@ -0,0 +1,128 @@
+package main_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`TrafficGenerator`, func() {
+	var (
+		profile TrafficProfile
+		start   time.Time
+	)
+
+	generate := func() []Event {
+		var events []Event
+		generator := NewTrafficGenerator(profile)
+		for event, ok := generator.Next(); ok; event, ok = generator.Next() {
+			events = append(events, event)
+		}
+		return events
+	}
+
+	count := func(events []Event, from, to time.Duration, section string) int {
+		count := 0
+		for _, event := range events {
+			if !event.Time.Before(start.Add(from)) && event.Time.Before(start.Add(to)) && (section == "" || SectionKey(event) == section) {
+				count++
+			}
+		}
+		return count
+	}
+
+	BeforeEach(func() {
+		start = time.Date(2015, time.December, 23, 18, 0, 0, 0, time.UTC)
+		profile = TrafficProfile{
+			Seed:     1,
+			Start:    start,
+			Duration: 10 * time.Minute,
+			Rate:     5,
+			Clients:  50,
+			Sections: []WeightedSection{{Section: "/api", Weight: 1}, {Section: "/static", Weight: 1}},
+			Statuses: []WeightedStatus{{StatusCode: 200, Weight: 9}, {StatusCode: 500, Weight: 1}},
+		}
+	})
+
+	It(`generates the same traffic for the same seed`, func() {
+		Expect(generate()).To(Equal(generate()))
+		first := generate()
+		profile.Seed = 2
+		Expect(generate()).NotTo(Equal(first))
+	})
+
+	It(`generates events in order at about the configured rate`, func() {
+		events := generate()
+		Expect(len(events)).To(BeNumerically("~", 3000, 300))
+		for i, event := range events {
+			Expect(event.Time).To(BeTemporally(">=", start))
+			Expect(event.Time).To(BeTemporally("<", start.Add(10*time.Minute)))
+			if i > 0 {
+				Expect(event.Time).To(BeTemporally(">=", events[i-1].Time))
+			}
+		}
+		Expect(count(events, 0, 10*time.Minute, "/api")).To(BeNumerically("~", 1500, 200))
+	})
+
+	It(`follows the scripted spikes and outages`, func() {
+		profile.Spikes = []TrafficSpike{
+			{Offset: 2 * time.Minute, Duration: time.Minute, Multiplier: 10, Section: "/api"},
+			{Offset: 5 * time.Minute, Duration: time.Minute, Multiplier: 0},
+		}
+		events := generate()
+		Expect(count(events, 2*time.Minute, 3*time.Minute, "/api")).To(BeNumerically(">", 5*count(events, 0, time.Minute, "/api")))
+		Expect(count(events, 2*time.Minute, 3*time.Minute, "/static")).To(BeNumerically("<", 2*count(events, 0, time.Minute, "/static")))
+		Expect(count(events, 5*time.Minute, 6*time.Minute, "")).To(BeZero())
+	})
+
+	It(`round-trips through the parsers`, func() {
+		for _, event := range generate()[:500] {
+			for _, parse := range []LineParser{ParseCommonLogLine, ParseLogLine} {
+				parsed, err := parse(FormatCommonLogLine(event))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(parsed.Time.Equal(event.Time.Truncate(time.Second))).To(BeTrue())
+				expected := event
+				expected.Time, expected.Duration, parsed.Time = time.Time{}, 0, time.Time{}
+				Expect(parsed).To(Equal(expected))
+			}
+
+			parsed, err := ParseJSONLogLine(FormatJSONLogLine(event))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.Time.Equal(event.Time)).To(BeTrue())
+			parsed.Time = event.Time
+			Expect(parsed).To(Equal(event))
+		}
+	})
+})
+
+var _ = Describe(`ParseTrafficSpike`, func() {
+	It(`parses the offset, duration, multiplier and section`, func() {
+		spike, err := ParseTrafficSpike("10m,2m,5,/api")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spike).To(Equal(TrafficSpike{Offset: 10 * time.Minute, Duration: 2 * time.Minute, Multiplier: 5, Section: "/api"}))
+	})
+
+	It(`rejects malformed spikes`, func() {
+		for _, value := range []string{"10m", "10m,2m,-1", "ten,2m,5", "10m,2m,5,/api,extra"} {
+			_, err := ParseTrafficSpike(value)
+			Expect(err).To(HaveOccurred(), value)
+		}
+	})
+})
+
+var _ = Describe(`ParseWeightedSections`, func() {
+	It(`parses the sections and their weights`, func() {
+		sections, err := ParseWeightedSections("/api:5, /static:1.5")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sections).To(Equal([]WeightedSection{{Section: "/api", Weight: 5}, {Section: "/static", Weight: 1.5}}))
+	})
+
+	It(`rejects malformed sections`, func() {
+		for _, value := range []string{"api:5", "/api", "/api:-1"} {
+			_, err := ParseWeightedSections(value)
+			Expect(err).To(HaveOccurred(), value)
+		}
+	})
+})
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+	errInvalidDatabase = errors.New("invalid MaxMind database")
+)
+
+const (
+	dataSectionSeparatorSize = 16
+	maxMetadataSize          = 128 * 1024
+)
+
+type GeoIPDatabase struct {
+	filename string
+
+	mutex   sync.RWMutex
+	reader  *mmdbReader
+	modTime time.Time
+	size    int64
+
+	stop chan struct{}
+}
+
+func OpenGeoIPDatabase(filename string, reloadInterval time.Duration) (*GeoIPDatabase, error) {
+	database := &GeoIPDatabase{
+		filename: filename,
+		stop:     make(chan struct{}),
+	}
+	if err := database.load(); err != nil {
+		return nil, err
+	}
+	if reloadInterval > 0 {
+		go database.watch(reloadInterval)
+	}
+	return database, nil
+}
+
+func (g *GeoIPDatabase) Lookup(ip net.IP) (map[string]interface{}, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.reader.lookup(ip)
+}
+
+func (g *GeoIPDatabase) DatabaseType() string {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.reader.databaseType
+}
+
+func (g *GeoIPDatabase) Close() {
+	close(g.stop)
+}
+
+func (g *GeoIPDatabase) load() error {
+	info, err := os.Stat(g.filename)
+	if err != nil {
+		return err
+	}
+	contents, err := ioutil.ReadFile(g.filename)
+	if err != nil {
+		return err
+	}
+	reader, err := newMMDBReader(contents)
+	if err != nil {
+		return fmt.Errorf("could not load %s: %s", g.filename, err.Error())
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.reader = reader
+	g.modTime = info.ModTime()
+	g.size = info.Size()
+	return nil
+}
+
+func (g *GeoIPDatabase) changed() bool {
+	info, err := os.Stat(g.filename)
+	if err != nil {
+		return false
+	}
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return !info.ModTime().Equal(g.modTime) || info.Size() != g.size
+}
+
+func (g *GeoIPDatabase) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+			if !g.changed() {
+				continue
+			}
+			if err := g.load(); err != nil {
+				ConsoleNotification.Send(NewErrorMessage("Could not reload GeoIP database: %s", err.Error()))
+			}
+		}
+	}
+}
+
+type GeoIPEnricher struct {
+	city *GeoIPDatabase
+	asn  *GeoIPDatabase
+}
+
+func NewGeoIPEnricher(city *GeoIPDatabase, asn *GeoIPDatabase) *GeoIPEnricher {
+	return &GeoIPEnricher{city: city, asn: asn}
+}
+
+func (g *GeoIPEnricher) Enrich(event *Event) {
+	ip := net.ParseIP(event.Client)
+	if ip == nil {
+		return
+	}
+	if g.city != nil {
+		if record, err := g.city.Lookup(ip); err == nil && record != nil {
+			event.Country = lookupString(record, "country", "iso_code")
+			event.City = lookupString(record, "city", "names", "en")
+		}
+	}
+	if g.asn != nil {
+		if record, err := g.asn.Lookup(ip); err == nil && record != nil {
+			if asn, ok := record["autonomous_system_number"].(uint64); ok {
+				event.ASN = uint(asn)
+			}
+			event.Organization = lookupString(record, "autonomous_system_organization")
+		}
+	}
+}
+
+func lookupString(record map[string]interface{}, path ...string) string {
+	var value interface{} = record
+	for _, key := range path {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = fields[key]
+	}
+	result, _ := value.(string)
+	return result
+}
+
+type mmdbReader struct {
+	buffer       []byte
+	data         []byte
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+	ipv4Start    uint
+}
+
+func newMMDBReader(buffer []byte) (*mmdbReader, error) {
+	searchStart := 0
+	if len(buffer) > maxMetadataSize {
+		searchStart = len(buffer) - maxMetadataSize
+	}
+	markerIndex := bytes.LastIndex(buffer[searchStart:], metadataStartMarker)
+	if markerIndex < 0 {
+		return nil, errInvalidDatabase
+	}
+	metadataStart := searchStart + markerIndex + len(metadataStartMarker)
+	metadataValue, _, err := (&mmdbDecoder{buffer: buffer[metadataStart:]}).decode(0)
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := metadataValue.(map[string]interface{})
+	if !ok {
+		return nil, errInvalidDatabase
+	}
+
+	reader := &mmdbReader{buffer: buffer}
+	for key, field := range map[string]*uint{"node_count": &reader.nodeCount, "record_size": &reader.recordSize, "ip_version": &reader.ipVersion} {
+		value, ok := metadata[key].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("%s: missing %s in metadata", errInvalidDatabase.Error(), key)
+		}
+		*field = uint(value)
+	}
+	reader.databaseType, _ = metadata["database_type"].(string)
+	if reader.recordSize != 24 && reader.recordSize != 28 && reader.recordSize != 32 {
+		return nil, fmt.Errorf("%s: unsupported record size %d", errInvalidDatabase.Error(), reader.recordSize)
+	}
+
+	searchTreeSize := reader.nodeCount * reader.recordSize / 4
+	dataStart := searchTreeSize + dataSectionSeparatorSize
+	if dataStart > uint(searchStart+markerIndex) {
+		return nil, errInvalidDatabase
+	}
+	reader.data = buffer[dataStart : searchStart+markerIndex]
+
+	if reader.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < reader.nodeCount; i++ {
+			node = reader.readNode(node, 0)
+		}
+		reader.ipv4Start = node
+	}
+	return reader, nil
+}
+
+func (r *mmdbReader) lookup(ip net.IP) (map[string]interface{}, error) {
+	address := ip.To4()
+	node := uint(0)
+	if address == nil {
+		if r.ipVersion == 4 {
+			return nil, fmt.Errorf("cannot look up %s in an IPv4 database", ip.String())
+		}
+		address = ip.To16()
+	} else if r.ipVersion == 6 {
+		node = r.ipv4Start
+	}
+
+	bitCount := uint(len(address) * 8)
+	for i := uint(0); i < bitCount && node < r.nodeCount; i++ {
+		bit := uint(address[i>>3]>>(7-(i%8))) & 1
+		node = r.readNode(node, bit)
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	} else if node < r.nodeCount {
+		return nil, errInvalidDatabase
+	}
+
+	offset := node - r.nodeCount - dataSectionSeparatorSize
+	value, _, err := (&mmdbDecoder{buffer: r.data}).decode(offset)
+	if err != nil {
+		return nil, err
+	}
+	record, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errInvalidDatabase
+	}
+	return record, nil
+}
+
+func (r *mmdbReader) readNode(node uint, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		offset := node*6 + bit*3
+		return uint(r.buffer[offset])<<16 | uint(r.buffer[offset+1])<<8 | uint(r.buffer[offset+2])
+	case 28:
+		offset := node * 7
+		if bit == 0 {
+			return uint(r.buffer[offset+3]&0xF0)<<20 | uint(r.buffer[offset])<<16 | uint(r.buffer[offset+1])<<8 | uint(r.buffer[offset+2])
+		}
+		return uint(r.buffer[offset+3]&0x0F)<<24 | uint(r.buffer[offset+4])<<16 | uint(r.buffer[offset+5])<<8 | uint(r.buffer[offset+6])
+	default:
+		offset := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(r.buffer[offset : offset+4]))
+	}
+}
+
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+const maxMMDBDepth = 64
+
+type mmdbDecoder struct {
+	buffer []byte
+	depth  int
+}
+
+func (d *mmdbDecoder) decode(offset uint) (interface{}, uint, error) {
+	if d.depth >= maxMMDBDepth {
+		return nil, 0, fmt.Errorf("%s: data nested deeper than %d", errInvalidDatabase.Error(), maxMMDBDepth)
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+	if offset >= uint(len(d.buffer)) {
+		return nil, 0, errInvalidDatabase
+	}
+	control := d.buffer[offset]
+	offset++
+	dataType := uint(control >> 5)
+	if dataType == mmdbExtended {
+		if offset >= uint(len(d.buffer)) {
+			return nil, 0, errInvalidDatabase
+		}
+		dataType = uint(d.buffer[offset]) + 7
+		offset++
+	}
+
+	if dataType == mmdbPointer {
+		pointer, next, err := d.decodePointer(control, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+
+	size, offset, err := d.decodeSize(control, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if dataType == mmdbMap || dataType == mmdbArray || dataType == mmdbBool {
+		return d.decodeComposite(dataType, size, offset)
+	}
+	if offset+size > uint(len(d.buffer)) {
+		return nil, 0, errInvalidDatabase
+	}
+	value := d.buffer[offset : offset+size]
+	next := offset + size
+
+	switch dataType {
+	case mmdbString:
+		return string(value), next, nil
+	case mmdbBytes:
+		return append([]byte(nil), value...), next, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errInvalidDatabase
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(value)), next, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errInvalidDatabase
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(value))), next, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbUint128:
+		var unsigned uint64
+		for _, b := range value {
+			unsigned = unsigned<<8 | uint64(b)
+		}
+		return unsigned, next, nil
+	case mmdbInt32:
+		var signed uint32
+		for _, b := range value {
+			signed = signed<<8 | uint32(b)
+		}
+		return int64(int32(signed)), next, nil
+	default:
+		return nil, 0, fmt.Errorf("%s: unsupported data type %d", errInvalidDatabase.Error(), dataType)
+	}
+}
+
+func (d *mmdbDecoder) decodeComposite(dataType uint, size uint, offset uint) (interface{}, uint, error) {
+	switch dataType {
+	case mmdbBool:
+		return size != 0, offset, nil
+	case mmdbArray:
+		values := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+			offset = next
+		}
+		return values, offset, nil
+	default:
+		values := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, 0, errInvalidDatabase
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			values[keyString] = value
+			offset = next
+		}
+		return values, offset, nil
+	}
+}
+
+func (d *mmdbDecoder) decodePointer(control byte, offset uint) (uint, uint, error) {
+	pointerSize := uint((control>>3)&0x3) + 1
+	if offset+pointerSize > uint(len(d.buffer)) {
+		return 0, 0, errInvalidDatabase
+	}
+	var prefix uint
+	if pointerSize != 4 {
+		prefix = uint(control & 0x7)
+	}
+	pointer := prefix
+	for _, b := range d.buffer[offset : offset+pointerSize] {
+		pointer = pointer<<8 | uint(b)
+	}
+	switch pointerSize {
+	case 2:
+		pointer += 2048
+	case 3:
+		pointer += 526336
+	}
+	return pointer, offset + pointerSize, nil
+}
+
+func (d *mmdbDecoder) decodeSize(control byte, offset uint) (uint, uint, error) {
+	size := uint(control & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+	extraBytes := size - 28
+	if offset+extraBytes > uint(len(d.buffer)) {
+		return 0, 0, errInvalidDatabase
+	}
+	var extra uint
+	for _, b := range d.buffer[offset : offset+extraBytes] {
+		extra = extra<<8 | uint(b)
+	}
+	switch extraBytes {
+	case 1:
+		size = 29 + extra
+	case 2:
+		size = 285 + extra
+	default:
+		size = 65821 + extra
+	}
+	return size, offset + extraBytes, nil
+}
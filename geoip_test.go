@@ -0,0 +1,250 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+type mmdbPointer uint
+
+type mmdbNetwork struct {
+	cidr   string
+	record map[string]interface{}
+}
+
+func writeMMDB(filename string, databaseType string, networks ...mmdbNetwork) {
+	var data bytes.Buffer
+	nodes := [][2]int{{-1, -1}}
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		dataRecord := -2 - data.Len()
+		encodeMMDBValue(&data, network.record)
+
+		prefixLength, _ := ipNet.Mask.Size()
+		address := ipNet.IP.To4()
+		node := 0
+		for i := 0; i < prefixLength; i++ {
+			bit := int(address[i/8]>>uint(7-i%8)) & 1
+			if i == prefixLength-1 {
+				nodes[node][bit] = dataRecord
+			} else if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+				node = len(nodes) - 1
+			} else {
+				node = nodes[node][bit]
+			}
+		}
+	}
+
+	var database bytes.Buffer
+	for _, node := range nodes {
+		for _, record := range node {
+			value := record
+			if record == -1 {
+				value = len(nodes)
+			} else if record < -1 {
+				value = len(nodes) + 16 + (-2 - record)
+			}
+			database.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	database.Write(make([]byte, 16))
+	database.Write(data.Bytes())
+	database.WriteString("\xAB\xCD\xEFMaxMind.com")
+	encodeMMDBValue(&database, map[string]interface{}{
+		"node_count":    uint32(len(nodes)),
+		"record_size":   uint16(24),
+		"ip_version":    uint16(4),
+		"database_type": databaseType,
+		"build_epoch":   uint64(1450915200),
+	})
+
+	if err := ioutil.WriteFile(filename, database.Bytes(), 0644); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func encodeMMDBValue(buffer *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		writeMMDBControl(buffer, 2, len(v))
+		buffer.WriteString(v)
+	case uint16:
+		writeMMDBControl(buffer, 5, 2)
+		binary.Write(buffer, binary.BigEndian, v)
+	case uint32:
+		writeMMDBControl(buffer, 6, 4)
+		binary.Write(buffer, binary.BigEndian, v)
+	case uint64:
+		writeMMDBControl(buffer, 9, 8)
+		binary.Write(buffer, binary.BigEndian, v)
+	case mmdbPointer:
+		buffer.WriteByte(byte(1<<5 | v>>8&0x7))
+		buffer.WriteByte(byte(v))
+	case map[string]interface{}:
+		writeMMDBControl(buffer, 7, len(v))
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			encodeMMDBValue(buffer, key)
+			encodeMMDBValue(buffer, v[key])
+		}
+	}
+}
+
+func writeMMDBControl(buffer *bytes.Buffer, dataType int, size int) {
+	sizeBits := size
+	if size >= 29 {
+		sizeBits = 29
+	}
+	if dataType > 7 {
+		buffer.WriteByte(byte(sizeBits))
+		buffer.WriteByte(byte(dataType - 7))
+	} else {
+		buffer.WriteByte(byte(dataType<<5 | sizeBits))
+	}
+	if size >= 29 {
+		buffer.WriteByte(byte(size - 29))
+	}
+}
+
+var _ = Describe(`GeoIPEnricher`, func() {
+	var (
+		enricher Enricher
+		city     *GeoIPDatabase
+		asn      *GeoIPDatabase
+		err      error
+
+		cityFile = "city.test.mmdb"
+		asnFile  = "asn.test.mmdb"
+	)
+
+	BeforeEach(func() {
+		writeMMDB(cityFile, "GeoLite2-City",
+			mmdbNetwork{"209.160.24.0/24", map[string]interface{}{
+				"country": map[string]interface{}{"iso_code": "US"},
+				"city":    map[string]interface{}{"names": map[string]interface{}{"en": "Seattle"}},
+			}},
+			mmdbNetwork{"81.2.69.0/24", map[string]interface{}{
+				"country": map[string]interface{}{"iso_code": "GB"},
+				"city":    map[string]interface{}{"names": map[string]interface{}{"en": "London"}},
+			}},
+		)
+		writeMMDB(asnFile, "GeoLite2-ASN",
+			mmdbNetwork{"209.160.0.0/16", map[string]interface{}{
+				"autonomous_system_number":       uint32(15169),
+				"autonomous_system_organization": "Example Networks",
+			}},
+		)
+		if city, err = OpenGeoIPDatabase(cityFile, 50*time.Millisecond); err != nil {
+			log.Fatal(err.Error())
+		}
+		if asn, err = OpenGeoIPDatabase(asnFile, 0); err != nil {
+			log.Fatal(err.Error())
+		}
+		enricher = NewGeoIPEnricher(city, asn)
+	})
+
+	AfterEach(func() {
+		city.Close()
+		asn.Close()
+		os.Remove(cityFile)
+		os.Remove(asnFile)
+	})
+
+	Describe(`#Enrich`, func() {
+		Context(`when the client is in both databases`, func() {
+			It(`attaches the country, city, ASN and organization`, func() {
+				event := Event{Client: "209.160.24.63"}
+				enricher.Enrich(&event)
+				Expect(event).To(Equal(Event{
+					Client:       "209.160.24.63",
+					Country:      "US",
+					City:         "Seattle",
+					ASN:          15169,
+					Organization: "Example Networks",
+				}))
+			})
+		})
+
+		Context(`when the client is only in the city database`, func() {
+			It(`attaches the country and city`, func() {
+				event := Event{Client: "81.2.69.160"}
+				enricher.Enrich(&event)
+				Expect(event).To(Equal(Event{Client: "81.2.69.160", Country: "GB", City: "London"}))
+			})
+		})
+
+		Context(`when the client is not in either database`, func() {
+			It(`leaves the event unchanged`, func() {
+				event := Event{Client: "10.0.0.1"}
+				enricher.Enrich(&event)
+				Expect(event).To(Equal(Event{Client: "10.0.0.1"}))
+			})
+		})
+
+		Context(`when the database file changes`, func() {
+			JustBeforeEach(func() {
+				time.Sleep(10 * time.Millisecond)
+				writeMMDB(cityFile, "GeoLite2-City",
+					mmdbNetwork{"209.160.24.0/24", map[string]interface{}{
+						"country": map[string]interface{}{"iso_code": "CA"},
+						"city":    map[string]interface{}{"names": map[string]interface{}{"en": "Vancouver"}},
+					}},
+				)
+			})
+
+			It(`reloads the database`, func() {
+				Eventually(func() string {
+					event := Event{Client: "209.160.24.63"}
+					enricher.Enrich(&event)
+					return event.City
+				}, 2*time.Second, 50*time.Millisecond).Should(Equal("Vancouver"))
+			})
+		})
+	})
+})
+
+var _ = Describe(`OpenGeoIPDatabase`, func() {
+	Context(`when the file is not a MaxMind database`, func() {
+		It(`returns an error`, func() {
+			_, err := OpenGeoIPDatabase("sample.log", 0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context(`when a record points back to itself`, func() {
+		filename := "cycle.test.mmdb"
+
+		AfterEach(func() {
+			os.Remove(filename)
+		})
+
+		It(`returns an error instead of following the pointers forever`, func() {
+			writeMMDB(filename, "GeoLite2-City", mmdbNetwork{"209.160.24.0/24", map[string]interface{}{"country": mmdbPointer(0)}})
+			database, err := OpenGeoIPDatabase(filename, 0)
+			Expect(err).ToNot(HaveOccurred())
+			defer database.Close()
+
+			_, err = database.Lookup(net.ParseIP("209.160.24.63"))
+			Expect(err).To(MatchError(ContainSubstring("invalid MaxMind database: data nested deeper than")))
+		})
+	})
+})
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+type HeavyHitter struct {
+	Key   string
+	Count uint64
+	Error uint64
+}
+
+type SpaceSaving struct {
+	capacity int
+	counters map[string]*spaceSavingCounter
+	byCount  spaceSavingHeap
+}
+
+type spaceSavingCounter struct {
+	HeavyHitter
+	index int
+}
+
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &SpaceSaving{capacity: capacity, counters: map[string]*spaceSavingCounter{}}
+}
+
+func (s *SpaceSaving) Add(key string, count uint64) {
+	if counter, ok := s.counters[key]; ok {
+		counter.Count += count
+		heap.Fix(&s.byCount, counter.index)
+		return
+	}
+	if len(s.counters) < s.capacity {
+		counter := &spaceSavingCounter{HeavyHitter: HeavyHitter{Key: key, Count: count}}
+		s.counters[key] = counter
+		heap.Push(&s.byCount, counter)
+		return
+	}
+
+	counter := s.byCount[0]
+	delete(s.counters, counter.Key)
+	counter.Error = counter.Count
+	counter.Key = key
+	counter.Count += count
+	s.counters[key] = counter
+	heap.Fix(&s.byCount, 0)
+}
+
+func (s *SpaceSaving) Count(key string) uint64 {
+	if counter, ok := s.counters[key]; ok {
+		return counter.Count
+	}
+	return 0
+}
+
+func (s *SpaceSaving) Guaranteed(key string) uint64 {
+	if counter, ok := s.counters[key]; ok {
+		return counter.Count - counter.Error
+	}
+	return 0
+}
+
+func (s *SpaceSaving) Len() int {
+	return len(s.counters)
+}
+
+func (s *SpaceSaving) Top(n int) []HeavyHitter {
+	return topHeavyHitters(s.counters, n)
+}
+
+func topHeavyHitters(counters map[string]*spaceSavingCounter, n int) []HeavyHitter {
+	top := make([]HeavyHitter, 0, len(counters))
+	for _, counter := range counters {
+		top = append(top, counter.HeavyHitter)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Key < top[j].Key
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+type spaceSavingHeap []*spaceSavingCounter
+
+func (s spaceSavingHeap) Len() int           { return len(s) }
+func (s spaceSavingHeap) Less(i, j int) bool { return s[i].Count < s[j].Count }
+func (s spaceSavingHeap) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+	s[i].index, s[j].index = i, j
+}
+
+func (s *spaceSavingHeap) Push(x interface{}) {
+	counter := x.(*spaceSavingCounter)
+	counter.index = len(*s)
+	*s = append(*s, counter)
+}
+
+func (s *spaceSavingHeap) Pop() interface{} {
+	old := *s
+	counter := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return counter
+}
+
+type SlidingHeavyHitters struct {
+	window   time.Duration
+	slot     time.Duration
+	capacity int
+
+	slots []heavyHittersSlot
+}
+
+type heavyHittersSlot struct {
+	start    time.Time
+	counters *SpaceSaving
+}
+
+func NewSlidingHeavyHitters(window time.Duration, slots int, capacity int) *SlidingHeavyHitters {
+	if slots < 1 {
+		slots = 1
+	}
+	slot := window / time.Duration(slots)
+	if slot <= 0 {
+		slot = window
+	}
+	return &SlidingHeavyHitters{window: window, slot: slot, capacity: capacity}
+}
+
+func (s *SlidingHeavyHitters) Add(at time.Time, key string) {
+	start := at.Truncate(s.slot)
+	for i := len(s.slots) - 1; i >= 0; i-- {
+		if s.slots[i].start.Equal(start) {
+			s.slots[i].counters.Add(key, 1)
+			return
+		}
+		if s.slots[i].start.Before(start) {
+			break
+		}
+	}
+	counters := NewSpaceSaving(s.capacity)
+	counters.Add(key, 1)
+	s.slots = append(s.slots, heavyHittersSlot{start: start, counters: counters})
+	sort.SliceStable(s.slots, func(i, j int) bool { return s.slots[i].start.Before(s.slots[j].start) })
+}
+
+func (s *SlidingHeavyHitters) Count(now time.Time, key string) uint64 {
+	s.pruneUpTo(now)
+	var count uint64
+	for _, slot := range s.slots {
+		count += slot.counters.Count(key)
+	}
+	return count
+}
+
+func (s *SlidingHeavyHitters) Guaranteed(now time.Time, key string) uint64 {
+	s.pruneUpTo(now)
+	var count uint64
+	for _, slot := range s.slots {
+		count += slot.counters.Guaranteed(key)
+	}
+	return count
+}
+
+func (s *SlidingHeavyHitters) Top(now time.Time, n int) []HeavyHitter {
+	s.pruneUpTo(now)
+	merged := map[string]*spaceSavingCounter{}
+	for _, slot := range s.slots {
+		for key, counter := range slot.counters.counters {
+			if total, ok := merged[key]; ok {
+				total.Count += counter.Count
+				total.Error += counter.Error
+			} else {
+				merged[key] = &spaceSavingCounter{HeavyHitter: counter.HeavyHitter}
+			}
+		}
+	}
+	return topHeavyHitters(merged, n)
+}
+
+func (s *SlidingHeavyHitters) pruneUpTo(now time.Time) {
+	pruned := 0
+	for pruned < len(s.slots) && !s.slots[pruned].start.Add(s.slot).After(now.Add(-s.window)) {
+		pruned++
+	}
+	s.slots = s.slots[pruned:]
+}
@@ -0,0 +1,57 @@
+package main_test
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`SpaceSaving`, func() {
+	It(`counts the keys exactly while they fit`, func() {
+		counters := NewSpaceSaving(3)
+		for _, key := range []string{"a", "b", "a", "c", "a", "b"} {
+			counters.Add(key, 1)
+		}
+		Expect(counters.Top(2)).To(Equal([]HeavyHitter{{Key: "a", Count: 3}, {Key: "b", Count: 2}}))
+	})
+
+	It(`keeps the heavy hitters in bounded memory during a flood of distinct keys`, func() {
+		counters := NewSpaceSaving(20)
+		for i := 0; i < 10000; i++ {
+			counters.Add(fmt.Sprintf("10.0.%d.%d", i/256, i%256), 1)
+			if i%10 == 0 {
+				counters.Add("10.9.9.9", 1)
+			}
+		}
+		Expect(counters.Len()).To(Equal(20))
+		top := counters.Top(1)[0]
+		Expect(top.Key).To(Equal("10.9.9.9"))
+		Expect(top.Count - top.Error).To(BeNumerically("<=", 1000))
+		Expect(top.Count).To(BeNumerically(">=", 1000))
+	})
+
+	It(`does not count the error inherited from a replaced key`, func() {
+		counters := NewSpaceSaving(1)
+		counters.Add("a", 5)
+		counters.Add("b", 1)
+		Expect(counters.Count("b")).To(Equal(uint64(6)))
+		Expect(counters.Guaranteed("b")).To(Equal(uint64(1)))
+		Expect(counters.Guaranteed("a")).To(BeZero())
+	})
+})
+
+var _ = Describe(`SlidingHeavyHitters`, func() {
+	It(`forgets the requests that leave the window`, func() {
+		start := time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+		clients := NewSlidingHeavyHitters(time.Minute, 6, 10)
+		clients.Add(start, "a")
+		clients.Add(start.Add(30*time.Second), "a")
+		clients.Add(start.Add(30*time.Second), "b")
+		Expect(clients.Count(start.Add(30*time.Second), "a")).To(Equal(uint64(2)))
+		Expect(clients.Guaranteed(start.Add(30*time.Second), "a")).To(Equal(uint64(2)))
+		Expect(clients.Top(start.Add(90*time.Second), 5)).To(Equal([]HeavyHitter{{Key: "a", Count: 1}, {Key: "b", Count: 1}}))
+	})
+})
@@ -9,12 +9,13 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 var (
 	clientMatcher        = regexp.MustCompile(`[0-9]{1,3}\.[0-9]{1,3}\.[0-9]{1,3}\.[0-9]{1,3}`)
-	timeMatcher          = regexp.MustCompile(`\[[a-zA-Z0-9\/\:\s\-]*\]`)
+	timeMatcher          = regexp.MustCompile(`\[[a-zA-Z0-9\/\:\s\-\+]*\]`)
 	userAgentMatcher     = regexp.MustCompile(`\"([^\"]*)\"$`)
 	statusCodeAndPayload = regexp.MustCompile(`\d+ \d+`)
 
@@ -32,36 +33,113 @@ type LogReader interface {
 	Close()
 }
 
+type LineParser func(string) (Event, error)
+
 type LogFileReader struct {
-	logs chan Event
+	logs *EventQueue
 	file *os.File
+
+	queueConfig QueueConfig
+	parser      LineParser
+	workers     int
+	stopAtEOF   bool
+}
+
+type ReaderOption func(*LogFileReader)
+
+func ReadQueue(config QueueConfig) ReaderOption {
+	return func(f *LogFileReader) {
+		f.queueConfig = config
+	}
 }
 
-func NewLogFileReader(filename string) (*LogFileReader, error) {
+func ParseWith(parser LineParser) ReaderOption {
+	return func(f *LogFileReader) {
+		f.parser = parser
+	}
+}
+
+func ParseWorkers(workers int) ReaderOption {
+	return func(f *LogFileReader) {
+		f.workers = workers
+	}
+}
+
+func StopAtEOF() ReaderOption {
+	return func(f *LogFileReader) {
+		f.stopAtEOF = true
+	}
+}
+
+func NewLogFileReader(filename string, options ...ReaderOption) (*LogFileReader, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	LogFileReader := &LogFileReader{
-		logs: make(chan Event),
-		file: file,
+		file:    file,
+		parser:  ParseLogLine,
+		workers: 1,
+	}
+	for _, option := range options {
+		option(LogFileReader)
 	}
-	go LogFileReader.consumeFromFile()
+	if LogFileReader.workers < 1 {
+		LogFileReader.workers = 1
+	}
+	LogFileReader.logs = NewEventQueue("reader", LogFileReader.queueConfig)
+
+	lines := make(chan rawLine, LogFileReader.workers*16)
+	parsedLines := make(chan parsedLine, LogFileReader.workers*16)
+	var parsing sync.WaitGroup
+	parsing.Add(LogFileReader.workers)
+	for i := 0; i < LogFileReader.workers; i++ {
+		go LogFileReader.parseLines(lines, parsedLines, &parsing)
+	}
+	go func() {
+		parsing.Wait()
+		close(parsedLines)
+	}()
+	go LogFileReader.resequence(parsedLines)
+	go LogFileReader.consumeFromFile(filename, lines)
 	return LogFileReader, nil
 }
 
 func (f *LogFileReader) Read() <-chan Event {
-	return f.logs
+	return f.logs.Events()
 }
 
 func (f *LogFileReader) Close() {
-	close(f.logs)
+	f.logs.Close()
+}
+
+func (f *LogFileReader) QueueStats() []QueueStats {
+	return []QueueStats{f.logs.Stats()}
+}
+
+type rawLine struct {
+	source   string
+	sequence uint64
+	text     string
+}
+
+type parsedLine struct {
+	source   string
+	sequence uint64
+	event    Event
+	err      error
 }
 
-func (f *LogFileReader) consumeFromFile() {
+func (f *LogFileReader) consumeFromFile(source string, lines chan<- rawLine) {
+	defer f.file.Close()
+	defer close(lines)
 	bufferedReader := bufio.NewReader(f.file)
-	for true {
+	var sequence uint64
+	for !f.logs.Closed() {
 		if _, err := bufferedReader.Peek(1); err != nil {
+			if f.stopAtEOF {
+				return
+			}
 			time.Sleep(200 * time.Millisecond)
 			continue
 		}
@@ -70,15 +148,65 @@ func (f *LogFileReader) consumeFromFile() {
 		if err != nil && err != io.EOF {
 			continue
 		}
-		event, err := f.parseLog(line)
-		if err != nil {
-			continue
+		lines <- rawLine{source: source, sequence: sequence, text: strings.TrimRight(line, "\r\n")}
+		sequence++
+	}
+}
+
+func (f *LogFileReader) parseLines(lines <-chan rawLine, parsedLines chan<- parsedLine, parsing *sync.WaitGroup) {
+	defer parsing.Done()
+	for line := range lines {
+		event, err := f.parser(line.text)
+		parsedLines <- parsedLine{source: line.source, sequence: line.sequence, event: event, err: err}
+	}
+}
+
+func (f *LogFileReader) resequence(parsedLines <-chan parsedLine) {
+	defer f.logs.Close()
+	resequencer := newResequencer()
+	for line := range parsedLines {
+		for _, next := range resequencer.add(line) {
+			if next.err != nil || f.logs.Closed() {
+				continue
+			}
+			f.logs.Push(next.event)
+		}
+	}
+}
+
+type resequencer struct {
+	next    map[string]uint64
+	pending map[string]map[uint64]parsedLine
+}
+
+func newResequencer() *resequencer {
+	return &resequencer{
+		next:    map[string]uint64{},
+		pending: map[string]map[uint64]parsedLine{},
+	}
+}
+
+func (r *resequencer) add(line parsedLine) []parsedLine {
+	pending, ok := r.pending[line.source]
+	if !ok {
+		pending = map[uint64]parsedLine{}
+		r.pending[line.source] = pending
+	}
+	pending[line.sequence] = line
+
+	var ready []parsedLine
+	for {
+		next, ok := pending[r.next[line.source]]
+		if !ok {
+			return ready
 		}
-		f.logs <- event
+		delete(pending, next.sequence)
+		ready = append(ready, next)
+		r.next[line.source]++
 	}
 }
 
-func (f *LogFileReader) parseLog(line string) (Event, error) {
+func ParseLogLine(line string) (Event, error) {
 	var logDate time.Time
 	if dateWithTimezone, err := time.Parse(logDateFormatWithTimezone, strings.Trim(timeMatcher.FindString(line), "[]")); err == nil {
 		logDate = dateWithTimezone
@@ -1,8 +1,10 @@
 package main_test
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"testing"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -82,3 +84,133 @@ var _ = Describe(`FileLogReader`, func() {
 		})
 	})
 })
+
+var _ = Describe(`FileLogReader with parallel parsing workers`, func() {
+	var (
+		fileLogReader LogReader
+		file          *os.File
+		err           error
+
+		testFile = "sample.workers.test"
+	)
+
+	BeforeEach(func() {
+		file, err = os.Create(testFile)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		for i := 0; i < 500; i++ {
+			if i%7 == 0 {
+				file.WriteString("malformed line\n")
+			}
+			file.WriteString(fmt.Sprintf("209.160.24.63 - - [23/Dec/2015:18:22:21] \"GET /product.screen HTTP 1.1\" 200 %d \"-\" \"curl/7.43.0\"\n", i))
+		}
+		fileLogReader, err = NewLogFileReader(testFile, ParseWorkers(8))
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	})
+
+	AfterEach(func() {
+		fileLogReader.Close()
+		file.Close()
+		os.Remove(file.Name())
+	})
+
+	Describe(`#Read`, func() {
+		It(`reads the events in the order of the file`, func() {
+			expected := make([]int, 500)
+			for i := range expected {
+				expected[i] = i
+			}
+
+			payloadSizes := make(chan []int)
+			go func() {
+				var read []int
+				for event := range fileLogReader.Read() {
+					if read = append(read, event.PayloadSize); len(read) == len(expected) {
+						break
+					}
+				}
+				payloadSizes <- read
+			}()
+			Eventually(payloadSizes, 5*time.Second).Should(Receive(Equal(expected)))
+		})
+	})
+})
+
+var _ = Describe(`FileLogReader stopping at the end of the file`, func() {
+	var (
+		fileLogReader LogReader
+		testFile      = "sample.eof.test"
+	)
+
+	BeforeEach(func() {
+		file, err := os.Create(testFile)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer file.Close()
+		for i := 0; i < 3; i++ {
+			file.WriteString(fmt.Sprintf("209.160.24.63 - - [23/Dec/2015:18:22:21] \"GET /product.screen HTTP 1.1\" 200 %d \"-\" \"curl/7.43.0\"\n", i))
+		}
+		fileLogReader, err = NewLogFileReader(testFile, StopAtEOF())
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	})
+
+	AfterEach(func() {
+		fileLogReader.Close()
+		os.Remove(testFile)
+	})
+
+	It(`closes the events once the whole file is read`, func() {
+		read := make(chan int)
+		go func() {
+			count := 0
+			for _ = range fileLogReader.Read() {
+				count++
+			}
+			read <- count
+		}()
+		Eventually(read).Should(Receive(Equal(3)))
+	})
+})
+
+func benchmarkLogFileReader(b *testing.B, workers int) {
+	testFile := fmt.Sprintf("sample.bench.%d.test", workers)
+	file, err := os.Create(testFile)
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+	defer os.Remove(testFile)
+	defer file.Close()
+	for i := 0; i < b.N; i++ {
+		file.WriteString(`209.160.24.63 - - [23/Dec/2015:18:22:19] "POST /category.screen?categoryId=STRATEGY&JSESSIONID=SD0SL6FF7ADFF4953 HTTP 1.1" 200 407 "http://wwbuttercupgames.com/cart.do?action=remove&itemId=EST-7&productId=PZ-SG-G05" "Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/536.5 (KHTML, like Gecko) Chrome/19.0.1084.46 Safari/536.5"` + "\n")
+	}
+
+	b.ResetTimer()
+	reader, err := NewLogFileReader(testFile, ParseWorkers(workers), ReadQueue(QueueConfig{Capacity: 1024}))
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+	defer reader.Close()
+	for i := 0; i < b.N; i++ {
+		<-reader.Read()
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "lines/s")
+}
+
+func BenchmarkLogFileReader1Worker(b *testing.B) {
+	benchmarkLogFileReader(b, 1)
+}
+
+func BenchmarkLogFileReader4Workers(b *testing.B) {
+	benchmarkLogFileReader(b, 4)
+}
+
+func BenchmarkLogFileReader8Workers(b *testing.B) {
+	benchmarkLogFileReader(b, 8)
+}
@@ -3,6 +3,10 @@ package main
 import (
 	"flag"
 	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
 	"time"
 )
 
@@ -12,28 +16,419 @@ var (
 	monitor  int
 	duration int
 	traffic  int
+
+	groupBy        string
+	userAgents     string
+	userAgentCache int
+	dropBots       bool
+
+	geoIPCity      string
+	geoIPASN       string
+	geoIPReload    int
+	alertGroupBy   string
+	alertMaxGroups int
+
+	alertFor        time.Duration
+	alertMinFiring  time.Duration
+	alertClearRatio float64
+	alertResolution time.Duration
+
+	errorRate        float64
+	errorStatuses    string
+	errorMinRequests int
+
+	latencySLO      time.Duration
+	latencyQuantile float64
+	latencyWindow   time.Duration
+	latencyFor      time.Duration
+
+	clientTraffic   int
+	clientWindow    time.Duration
+	clientBySection bool
+	clientTop       int
+	clientCapacity  int
+
+	anomalyMetricNames string
+	anomalyInterval    time.Duration
+	anomalySeason      time.Duration
+	anomalySensitivity float64
+	anomalyState       string
+
+	absenceTimeout time.Duration
+	absenceMatch   string
+
+	configFile string
+
+	alertManagerGroupBy string
+	alertGroupWait      time.Duration
+	alertGroupInterval  time.Duration
+	alertRepeatInterval time.Duration
+	alertManagerListen  string
+	alertHistory        string
+
+	webhook        string
+	webhookTimeout time.Duration
+	webhookQueue   int
+
+	parser         string
+	logFormatSpec  string
+	parseWorkers   int
+	clockName      string
+	lateness       int
+	latePolicyName string
+
+	queueSize          int
+	queueSampleRate    int
+	queueStats         int
+	readerQueuePolicy  string
+	monitorQueuePolicy string
+	alertQueuePolicy   string
 )
 
 func init() {
-	flag.StringVar(&file, "file", "access.log", "File name of the file to monitor, collect, and/or alert on traffic logs")
-	flag.IntVar(&monitor, "monitor", 10, "Monitoring duration in seconds to which to send a summary")
-	flag.IntVar(&duration, "duration", 120, "Duration in seconds for which the total traffic exceeds should alert")
-	flag.IntVar(&traffic, "traffic", 1000, "Traffic amount that should trigger an alert")
-	flag.Parse()
+	registerFlags(flag.CommandLine)
+	flag.StringVar(&clockName, "clock", "wall", "Clock of the tickers sending the summaries of idle windows: wall for the system time, event for the timestamps of the log lines")
+}
+
+func registerFlags(flags *flag.FlagSet) {
+	flags.StringVar(&file, "file", "access.log", "File name of the file to monitor, collect, and/or alert on traffic logs")
+	flags.IntVar(&monitor, "monitor", 10, "Monitoring duration in seconds to which to send a summary")
+	flags.IntVar(&duration, "duration", 120, "Duration in seconds for which the total traffic exceeds should alert")
+	flags.IntVar(&traffic, "traffic", 1000, "Traffic amount that should trigger an alert")
+	flags.StringVar(&groupBy, "groupby", "section", "Comma separated event keys to break the traffic summaries down by: section, browser, os, device, bot, country, city, asn, org, client")
+	flags.StringVar(&userAgents, "useragents", "", "File name of the user agent signature database used to enrich events; disabled when empty")
+	flags.IntVar(&userAgentCache, "useragent-cache", 10000, "Number of parsed user agents to keep in the LRU cache")
+	flags.BoolVar(&dropBots, "drop-bots", false, "Drop events from bots and crawlers; requires -useragents")
+	flags.StringVar(&geoIPCity, "geoip-city", "", "File name of a GeoLite2/GeoIP2 City or Country .mmdb database used to enrich events; disabled when empty")
+	flags.StringVar(&geoIPASN, "geoip-asn", "", "File name of a GeoLite2/GeoIP2 ASN .mmdb database used to enrich events; disabled when empty")
+	flags.IntVar(&geoIPReload, "geoip-reload", 60, "Interval in seconds to check the GeoIP databases for changes and reload them")
+	flags.StringVar(&parser, "parser", "fast", "Parser of the log lines: fast for the single pass Common/Combined Log Format parser, regexp for the regular expression parser, jsonl for JSON lines")
+	flags.StringVar(&logFormatSpec, "log-format", "", "nginx log_format specification of the log lines, such as '$remote_addr - $remote_user [$time_local] \"$request\" $status $body_bytes_sent'; overrides -parser")
+	flags.IntVar(&parseWorkers, "parse-workers", runtime.NumCPU(), "Number of workers parsing the log lines in parallel; events keep the order of the file")
+	flags.IntVar(&lateness, "lateness", 0, "Seconds the summaries wait for out of order events before a window is sent")
+	flags.StringVar(&latePolicyName, "late-events", "count", "What to do with events older than the lateness: count them in the next summary, or update the summary of their window")
+	flags.IntVar(&queueSize, "queue-size", 1024, "Number of events each pipeline stage can buffer before its queue policy applies")
+	flags.IntVar(&queueSampleRate, "queue-sample-rate", 10, "Keep one of every N events that overflow a queue with the sample policy")
+	flags.IntVar(&queueStats, "queue-stats", 0, "Interval in seconds to log the queue depth and drop counters; disabled when 0")
+	flags.StringVar(&readerQueuePolicy, "reader-queue", "block", "Policy when the reader queue is full: block, drop-oldest, drop-newest, sample")
+	flags.StringVar(&monitorQueuePolicy, "monitor-queue", "block", "Policy when the monitor queue is full: block, drop-oldest, drop-newest, sample")
+	flags.StringVar(&alertQueuePolicy, "alert-queue", "block", "Policy when the alert queue is full: block, drop-oldest, drop-newest, sample")
+	flags.StringVar(&alertGroupBy, "alert-groupby", "", "Event key to alert on the traffic of each group separately, such as section, asn or country; alerts on the total traffic when empty")
+	flags.IntVar(&alertMaxGroups, "alert-max-groups", 1000, "Number of groups -alert-groupby tracks at most; the least recently seen group that is not alerting makes room for a new one")
+	flags.DurationVar(&alertFor, "alert-for", 0, "How long the condition of an alert should hold before it fires; the alert is pending meanwhile")
+	flags.DurationVar(&alertMinFiring, "alert-min-firing", 0, "How long an alert fires at least before it can resolve")
+	flags.Float64Var(&alertClearRatio, "alert-clear-ratio", 1, "Fraction of the threshold of an alert that the traffic should get back under before the alert resolves, such as 0.8")
+	flags.DurationVar(&alertResolution, "alert-resolution", DefaultCounterResolution, "Resolution of the windows counting the hits of -traffic and the requests of -error-rate; a coarser resolution uses less memory for long windows")
+	flags.Float64Var(&errorRate, "error-rate", 0, "Fraction of the requests in the -duration window matching -error-statuses that should trigger an alert; disabled when 0")
+	flags.StringVar(&errorStatuses, "error-statuses", "5xx", "Status classes and codes counted as errors by -error-rate, such as 5xx or 4xx,5xx or 429,503")
+	flags.IntVar(&errorMinRequests, "error-min-requests", 20, "Number of requests the -duration window needs before -error-rate can trigger an alert")
+	flags.DurationVar(&latencySLO, "latency-slo", 0, "Request duration that the -latency-quantile should stay under, such as 500ms; disabled when 0")
+	flags.Float64Var(&latencyQuantile, "latency-quantile", 0.95, "Quantile of the request durations compared to -latency-slo, such as 0.95 or 0.99")
+	flags.DurationVar(&latencyWindow, "latency-window", time.Minute, "Sliding window of the request durations the -latency-quantile is computed over")
+	flags.DurationVar(&latencyFor, "latency-for", 5*time.Minute, "How long the -latency-quantile should stay above -latency-slo before it triggers an alert")
+	flags.IntVar(&clientTraffic, "client-traffic", 0, "Number of requests of a single client in the -client-window that should trigger an alert; disabled when 0")
+	flags.DurationVar(&clientWindow, "client-window", time.Minute, "Sliding window of the requests counted for each client by -client-traffic")
+	flags.BoolVar(&clientBySection, "client-by-section", false, "Count the requests of each client to each section separately for -client-traffic")
+	flags.IntVar(&clientTop, "client-top", 5, "Number of top offenders listed in the -client-traffic alerts")
+	flags.IntVar(&clientCapacity, "client-capacity", 1000, "Number of clients -client-traffic counts at most in each slot of its window, and the most clients it alerts on at once; the rest share the counts of the least active ones, which never trigger an alert")
+	flags.StringVar(&anomalyMetricNames, "anomaly-metrics", "", "Comma separated metrics to learn the expected rate of and alert on when they leave the expected range: hits, errors, bytes, each optionally with its own settings as metric:interval:season:sensitivity such as hits:1m:1d:4; disabled when empty")
+	flags.DurationVar(&anomalyInterval, "anomaly-interval", 5*time.Minute, "Interval the -anomaly-metrics without their own interval are summed over and compared to their expected range")
+	flags.DurationVar(&anomalySeason, "anomaly-season", 7*24*time.Hour, "Seasonality of the expected ranges of the -anomaly-metrics without their own season, such as 24h for a daily or 168h for a weekly pattern")
+	flags.Float64Var(&anomalySensitivity, "anomaly-sensitivity", 3, "Number of standard deviations from the expected value that the expected range of the -anomaly-metrics without their own sensitivity spans")
+	flags.StringVar(&anomalyState, "anomaly-state", "", "File name to persist the learned expected ranges to across restarts; kept in memory when empty")
+	flags.DurationVar(&absenceTimeout, "absence-timeout", 0, "How long without events should trigger an alert, for each group when -alert-groupby is set; disabled when 0")
+	flags.StringVar(&absenceMatch, "absence-match", "", "Only count the events of one group as traffic for -absence-timeout, such as section=/api or country=US")
+	flags.StringVar(&configFile, "config", "", "File name of a JSON config with alert rules, SLOs, silences and notification templates; the config is checked at startup")
+	flags.StringVar(&alertManagerGroupBy, "alert-manager-groupby", "", "Comma separated labels to group the alerts of one notification by: alert, group, subject; all the alerts share one notification when empty")
+	flags.DurationVar(&alertGroupWait, "alert-group-wait", 0, "How long a group of alerts waits for more alerts before its first notification")
+	flags.DurationVar(&alertGroupInterval, "alert-group-interval", 0, "How long a group of alerts waits after a notification before it notifies of new or resolved alerts")
+	flags.DurationVar(&alertRepeatInterval, "alert-repeat-interval", 0, "Interval to remind of the alerts that are still firing; disabled when 0")
+	flags.StringVar(&alertManagerListen, "alert-manager-listen", "", "Address to serve the alert records and the silences API on, such as :9093; disabled when empty")
+	flags.StringVar(&alertHistory, "alert-history", "", "File name of the append-only history every alert transition is recorded to, listed by the alerts command, such as alerts.jsonl; disabled when empty")
+	flags.StringVar(&webhook, "webhook", "", "URL to POST every summary, alert and error to as a JSON message, besides the console; disabled when empty")
+	flags.DurationVar(&webhookTimeout, "webhook-timeout", 5*time.Second, "How long to wait for the -webhook to accept a message")
+	flags.IntVar(&webhookQueue, "webhook-queue", 256, "Number of messages waiting to be sent to the -webhook before the new ones are dropped")
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Parse()
-	totalTrafficAlert := NewTotalTrafficAlert(traffic, time.Duration(duration)*time.Second, ConsoleNotification)
-	trafficMonitor := NewSummaryStatsTrafficMonitor(time.Duration(monitor)*time.Second, ConsoleNotification)
-	fileLogReader, err := NewLogFileReader(file)
+	clock, err := ClockByName(clockName)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	fileLogReader, err := NewLogFileReader(file, readerOptions()...)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	defer fileLogReader.Close()
 
 	log.Printf("Consuming the %s file for http logs", file)
 	log.Printf("Monitoring traffic; will alert if traffic surpasses %d requests in %d seconds", traffic, duration)
-	app := NewApplication(fileLogReader, trafficMonitor, totalTrafficAlert)
+	app, closeApplication := newApplication(fileLogReader, clock)
+	defer closeApplication()
 	app.Run()
 }
+
+func loadConfig() *Config {
+	if configFile == "" {
+		return &Config{}
+	}
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	return config
+}
+
+func newNotification(config *Config) (Notification, func()) {
+	console := NewConsoleNotification(NotificationTemplates(config.NotifierTemplates("console")))
+	if webhook == "" {
+		return console, func() {}
+	}
+	if webhookQueue < 1 {
+		log.Fatalf("-webhook-queue must be at least 1: %d", webhookQueue)
+	}
+	notification := NewWebhookNotification(webhook, webhookTimeout, webhookQueue, NotificationTemplates(config.NotifierTemplates("webhook")))
+	return MultiNotification{console, notification}, notification.Close
+}
+
+func newAlert(config *Config, clock Clock, output Notification) (Alert, []StatusReporter, func()) {
+	notification, closeAlertManager := newAlertManager(config, clock, output)
+
+	var alertKey EventKey
+	var alert Alert = NewTotalTrafficAlert(traffic, time.Duration(duration)*time.Second, notification, sharedAlertOptions()...)
+	if alertGroupBy != "" {
+		var err error
+		alertKey, err = EventKeyByName(alertGroupBy)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		alert = NewKeyedTrafficAlert(alertKey, traffic, time.Duration(duration)*time.Second, notification, alertMaxGroups, sharedAlertOptions()...)
+	}
+	if errorRate > 0 {
+		statuses, err := ParseStatusMatcher(errorStatuses)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		alert = CompositeAlert{alert, groupAlerts(alertKey, func(options ...AlertOption) Alert {
+			return NewErrorRateAlert(statuses, errorRate, errorMinRequests, time.Duration(duration)*time.Second, notification, options...)
+		})}
+	}
+	if clientTraffic > 0 {
+		clientKey := ClientKey
+		if clientBySection {
+			clientKey = JoinKeys(ClientKey, SectionKey)
+		}
+		alert = CompositeAlert{alert, NewClientRateAlert(clientKey, clientTraffic, clientWindow, clientCapacity, clientTop, notification, sharedAlertOptions()...)}
+	}
+	if anomalyMetricNames != "" {
+		store, err := LoadBaselineStore(anomalyState)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		specs, err := ParseAnomalySpecs(anomalyMetricNames, AnomalySpec{Interval: anomalyInterval, Season: anomalySeason, Sensitivity: anomalySensitivity})
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		for _, spec := range specs {
+			alert = CompositeAlert{alert, NewAnomalyAlert(spec.Name, spec.Metric, store, spec.Interval, spec.Season, spec.Sensitivity, notification, sharedAlertOptions()...)}
+		}
+	}
+	if absenceTimeout > 0 {
+		var filter Filter
+		if absenceMatch != "" {
+			keyFilter, err := ParseKeyFilter(absenceMatch)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			filter = keyFilter
+		}
+		alert = CompositeAlert{alert, groupAlerts(alertKey, func(options ...AlertOption) Alert {
+			return NewAbsenceAlert(absenceTimeout, filter, notification, append(options, AlertClock(clock))...)
+		})}
+	}
+	if latencySLO > 0 {
+		if latencyQuantile <= 0 || latencyQuantile >= 1 {
+			log.Fatalf("-latency-quantile must be between 0 and 1: %g", latencyQuantile)
+		}
+		alert = CompositeAlert{alert, groupAlerts(alertKey, func(options ...AlertOption) Alert {
+			return NewLatencyAlert(latencyQuantile, latencySLO, latencyWindow, notification, append(options, AlertFor(latencyFor))...)
+		})}
+	}
+	rules, err := config.CompileRules()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	for _, rule := range rules {
+		rule := rule
+		if key := rule.Key(); key != nil {
+			alert = CompositeAlert{alert, NewGroupedAlert(key, func(group string) Alert {
+				return NewRuleAlert(rule, notification, append(sharedAlertOptions(), AlertLabel(group))...)
+			}, MaxGroups(alertMaxGroups))}
+		} else {
+			alert = CompositeAlert{alert, NewRuleAlert(rule, notification, sharedAlertOptions()...)}
+		}
+	}
+	slos, err := config.CompileSLOs()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	var statuses []StatusReporter
+	for _, slo := range slos {
+		sloAlert := NewSLOAlert(slo, notification, sharedAlertOptions()...)
+		alert = CompositeAlert{alert, sloAlert}
+		statuses = append(statuses, sloAlert)
+	}
+	return CompositeAlert{alert, notification}, statuses, closeAlertManager
+}
+
+func newAlertManager(config *Config, clock Clock, output Notification) (*AlertManager, func()) {
+	var labels []string
+	if alertManagerGroupBy != "" {
+		labels = strings.Split(alertManagerGroupBy, ",")
+	}
+	if err := CheckAlertLabels(labels); err != nil {
+		log.Fatal(err.Error())
+	}
+	options := []AlertManagerOption{ManagerGroupBy(labels...), GroupWait(alertGroupWait), GroupInterval(alertGroupInterval), RepeatInterval(alertRepeatInterval), ManagerClock(clock)}
+	var history *AlertHistory
+	if alertHistory != "" {
+		var err error
+		if history, err = OpenAlertHistory(alertHistory); err != nil {
+			log.Fatal(err.Error())
+		}
+		options = append(options, ManagerHistory(history))
+	}
+	manager := NewAlertManager(output, options...)
+	for _, silence := range config.Silences {
+		if _, err := manager.AddSilence(silence); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+	if alertManagerListen != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(alertManagerListen, manager.Handler()).Error())
+		}()
+	}
+	return manager, func() {
+		manager.Flush()
+		if history != nil {
+			history.Close()
+		}
+	}
+}
+
+func sharedAlertOptions() []AlertOption {
+	return []AlertOption{AlertFor(alertFor), AlertMinFiring(alertMinFiring), AlertHysteresis(alertClearRatio), AlertResolution(alertResolution)}
+}
+
+func groupAlerts(key EventKey, newAlert func(options ...AlertOption) Alert) Alert {
+	if key == nil {
+		return newAlert(sharedAlertOptions()...)
+	}
+	return NewGroupedAlert(key, func(group string) Alert {
+		return newAlert(append(sharedAlertOptions(), AlertLabel(group))...)
+	}, MaxGroups(alertMaxGroups))
+}
+
+func readerOptions() []ReaderOption {
+	readerQueue, err := queueConfig(readerQueuePolicy)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	return []ReaderOption{ReadQueue(readerQueue), ParseWith(lineParser(parser, logFormatSpec)), ParseWorkers(parseWorkers)}
+}
+
+func newApplication(logReader LogReader, clock Clock) (*Application, func()) {
+	var keys []EventKey
+	for _, name := range strings.Split(groupBy, ",") {
+		key, err := EventKeyByName(strings.TrimSpace(name))
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		keys = append(keys, key)
+	}
+
+	monitorQueue, err := queueConfig(monitorQueuePolicy)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	alertQueue, err := queueConfig(alertQueuePolicy)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	latePolicy, err := ParseLatePolicy(latePolicyName)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	config := loadConfig()
+	output, closeNotification := newNotification(config)
+	alert, statuses, closeAlert := newAlert(config, clock, output)
+	trafficMonitor := NewSummaryStatsTrafficMonitor(time.Duration(monitor)*time.Second, output, GroupBy(keys...), MonitorQueue(monitorQueue), MonitorClock(clock), AllowedLateness(time.Duration(lateness)*time.Second), LateEvents(latePolicy), ReportStatus(statuses...))
+	closers := []func(){logReader.Close, closeAlert, closeNotification}
+
+	app := NewApplication(logReader, trafficMonitor, alert)
+	app.SetAlertQueue(alertQueue)
+	app.SetClock(clock)
+	if userAgents != "" {
+		database, err := LoadUserAgentDatabase(userAgents)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		app.AddEnricher(NewUserAgentEnricher(database, userAgentCache))
+	}
+	if dropBots {
+		if userAgents == "" {
+			log.Fatal("-drop-bots requires a user agent database set with -useragents")
+		}
+		app.AddFilter(BotFilter)
+	}
+	if geoIPCity != "" || geoIPASN != "" {
+		var city, asn *GeoIPDatabase
+		if geoIPCity != "" {
+			if city, err = OpenGeoIPDatabase(geoIPCity, time.Duration(geoIPReload)*time.Second); err != nil {
+				log.Fatal(err.Error())
+			}
+			closers = append(closers, city.Close)
+		}
+		if geoIPASN != "" {
+			if asn, err = OpenGeoIPDatabase(geoIPASN, time.Duration(geoIPReload)*time.Second); err != nil {
+				log.Fatal(err.Error())
+			}
+			closers = append(closers, asn.Close)
+		}
+		app.AddEnricher(NewGeoIPEnricher(city, asn))
+	}
+	if queueStats > 0 {
+		go logQueueStats(app, time.Duration(queueStats)*time.Second)
+	}
+	return app, func() {
+		for _, close := range closers {
+			close()
+		}
+	}
+}
+
+func queueConfig(policyName string) (QueueConfig, error) {
+	policy, err := ParseQueuePolicy(policyName)
+	if err != nil {
+		return QueueConfig{}, err
+	}
+	return QueueConfig{Capacity: queueSize, Policy: policy, SampleRate: queueSampleRate}, nil
+}
+
+func logQueueStats(app *Application, interval time.Duration) {
+	for _ = range time.Tick(interval) {
+		for _, stats := range app.QueueStats() {
+			log.Print(stats.String())
+		}
+	}
+}
@@ -1,25 +1,222 @@
 package main
 
-import "log"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type MessageKind string
+
+const (
+	SummaryMessage MessageKind = "summary"
+	AlertMessage   MessageKind = "alert"
+	ErrorMessage   MessageKind = "error"
+)
+
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+type Message struct {
+	Kind        MessageKind         `json:"kind"`
+	Severity    Severity            `json:"severity"`
+	Title       string              `json:"title"`
+	Labels      map[string]string   `json:"labels,omitempty"`
+	Values      map[string]float64  `json:"values,omitempty"`
+	At          time.Time           `json:"at"`
+	Start       time.Time           `json:"start"`
+	End         time.Time           `json:"end"`
+	Reminder    bool                `json:"reminder,omitempty"`
+	Statistics  []TrafficStatistics `json:"statistics,omitempty"`
+	Statuses    []string            `json:"statuses,omitempty"`
+	Transitions []AlertTransition   `json:"transitions,omitempty"`
+}
+
+func NewAlertMessage(transition AlertTransition) Message {
+	return Message{
+		Kind:        AlertMessage,
+		Severity:    transition.Severity(),
+		Title:       transition.Title(),
+		Labels:      transition.Labels(),
+		Values:      map[string]float64{"value": transition.Value, "threshold": transition.Threshold},
+		At:          transition.At,
+		Start:       transition.At.Add(-transition.Window),
+		End:         transition.At,
+		Transitions: []AlertTransition{transition},
+	}
+}
+
+func NewErrorMessage(format string, args ...interface{}) Message {
+	return Message{Kind: ErrorMessage, Severity: SeverityWarning, Title: fmt.Sprintf(format, args...), At: time.Now()}
+}
+
+func (m Message) String() string {
+	text, err := DefaultTemplates.Render(m)
+	if err != nil {
+		return m.Title + "\n"
+	}
+	return text
+}
 
 type Notification interface {
-	Send(string)
+	Send(Message)
+}
+
+type NotificationOption func(*notificationOptions)
+
+type notificationOptions struct {
+	templates Templates
+}
+
+func NotificationTemplates(templates Templates) NotificationOption {
+	return func(n *notificationOptions) {
+		n.templates = templates
+	}
 }
 
-var ConsoleNotification = NewNotificationSender(func(message string) {
-	log.Printf(message)
-})
+func newNotificationOptions(options []NotificationOption) notificationOptions {
+	notificationOptions := notificationOptions{templates: DefaultTemplates}
+	for _, option := range options {
+		option(&notificationOptions)
+	}
+	return notificationOptions
+}
+
+func (n notificationOptions) render(message Message) string {
+	text, err := n.templates.Render(message)
+	if err != nil {
+		log.Printf("Could not render the %s template: %s", message.Kind, err.Error())
+		return message.String()
+	}
+	return text
+}
+
+var ConsoleNotification = NewConsoleNotification()
+
+func NewConsoleNotification(options ...NotificationOption) *NotificationSender {
+	return NewNotificationSender(func(message string) {
+		log.Print(message)
+	}, options...)
+}
 
 type NotificationSender struct {
-	sendFn SendFn
+	sendFn  SendFn
+	options notificationOptions
 }
 
 type SendFn func(string)
 
-func NewNotificationSender(fn SendFn) *NotificationSender {
-	return &NotificationSender{sendFn: fn}
+func NewNotificationSender(fn SendFn, options ...NotificationOption) *NotificationSender {
+	return &NotificationSender{sendFn: fn, options: newNotificationOptions(options)}
+}
+
+func (n *NotificationSender) Send(message Message) {
+	n.sendFn(n.options.render(message))
+}
+
+type MultiNotification []Notification
+
+func (m MultiNotification) Send(message Message) {
+	for _, notification := range m {
+		notification.Send(message)
+	}
+}
+
+type WebhookNotification struct {
+	url      string
+	client   *http.Client
+	options  notificationOptions
+	messages chan Message
+	stopped  chan struct{}
+	mutex    sync.RWMutex
+	closed   bool
+	dropped  uint64
+}
+
+type webhookPayload struct {
+	Message
+	Text string `json:"text"`
+}
+
+func NewWebhookNotification(url string, timeout time.Duration, queue int, options ...NotificationOption) *WebhookNotification {
+	webhook := &WebhookNotification{
+		url:      url,
+		client:   &http.Client{Timeout: timeout},
+		options:  newNotificationOptions(options),
+		messages: make(chan Message, queue),
+		stopped:  make(chan struct{}),
+	}
+	go webhook.run()
+	return webhook
+}
+
+func (w *WebhookNotification) Send(message Message) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		w.drop(message, "the webhook is closed")
+		return
+	}
+	select {
+	case w.messages <- message:
+	default:
+		w.drop(message, "the queue is full")
+	}
+}
+
+func (w *WebhookNotification) drop(message Message, reason string) {
+	atomic.AddUint64(&w.dropped, 1)
+	log.Printf("Could not send the %s notification to the webhook: %s", message.Kind, reason)
+}
+
+func (w *WebhookNotification) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+func (w *WebhookNotification) Close() {
+	w.mutex.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.messages)
+	}
+	w.mutex.Unlock()
+	<-w.stopped
+}
+
+func (w *WebhookNotification) run() {
+	defer close(w.stopped)
+	for message := range w.messages {
+		if err := w.post(message); err != nil {
+			log.Printf("Could not send the %s notification to the webhook: %s", message.Kind, err.Error())
+		}
+	}
 }
 
-func (n *NotificationSender) Send(message string) {
-	n.sendFn(message)
+func (w *WebhookNotification) post(message Message) error {
+	payload, err := json.Marshal(webhookPayload{Message: message, Text: w.options.render(message)})
+	if err != nil {
+		return err
+	}
+	response, err := w.client.Post(w.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("%s", response.Status)
+	}
+	return nil
 }
@@ -2,7 +2,12 @@ package main_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -20,7 +25,7 @@ var _ = Describe(`ConsoleNotification`, func() {
 		})
 
 		JustBeforeEach(func() {
-			ConsoleNotification.Send("alert message")
+			ConsoleNotification.Send(NewErrorMessage("alert message"))
 		})
 
 		It(`sends an alert to the console`, func() {
@@ -28,3 +33,195 @@ var _ = Describe(`ConsoleNotification`, func() {
 		})
 	})
 })
+
+var _ = Describe(`Message`, func() {
+	var (
+		start      time.Time
+		transition AlertTransition
+	)
+
+	BeforeEach(func() {
+		start = time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+		transition = AlertTransition{
+			Alert:     "traffic",
+			From:      AlertPending,
+			To:        AlertFiring,
+			At:        start,
+			Condition: "High traffic",
+			Subject:   "traffic",
+			Value:     12,
+			Threshold: 10,
+			Window:    time.Minute,
+		}
+	})
+
+	Describe(`NewAlertMessage`, func() {
+		It(`carries the transition as a structured alert`, func() {
+			message := NewAlertMessage(transition)
+			Expect(message.Kind).To(Equal(AlertMessage))
+			Expect(message.Severity).To(Equal(SeverityCritical))
+			Expect(message.Title).To(Equal("High traffic generated an alert"))
+			Expect(message.Labels).To(Equal(map[string]string{"alert": "traffic", "group": "", "subject": "traffic"}))
+			Expect(message.Values).To(Equal(map[string]float64{"value": 12, "threshold": 10}))
+			Expect(message.Start).To(Equal(start.Add(-time.Minute)))
+			Expect(message.End).To(Equal(start))
+			Expect(message.String()).To(Equal(transition.String()))
+		})
+
+		It(`uses the severity of the transition`, func() {
+			for _, test := range []struct {
+				to       AlertState
+				severity Severity
+			}{
+				{AlertPending, SeverityWarning},
+				{AlertFiring, SeverityCritical},
+				{AlertResolved, SeverityInfo},
+			} {
+				transition.To = test.to
+				Expect(NewAlertMessage(transition).Severity).To(Equal(test.severity))
+			}
+		})
+	})
+
+	Describe(`#String`, func() {
+		It(`renders a summary with its late events, statistics and statuses`, func() {
+			message := Message{
+				Kind:       SummaryMessage,
+				Title:      "Summary from a to b",
+				Values:     map[string]float64{"late_events": 2},
+				Statistics: []TrafficStatistics{{Section: "/api", Count: 1}, {Section: "/pages", Count: 2}},
+				Statuses:   []string{"SLO ok\n"},
+			}
+			Expect(message.String()).To(Equal("Summary from a to b\nLate Events: 2\n" + (&message.Statistics[0]).String() + "\n" + (&message.Statistics[1]).String() + "SLO ok\n"))
+		})
+
+		It(`renders grouped alerts under their title`, func() {
+			message := Message{Kind: AlertMessage, Title: "Reminder: 1 alerts still firing", Reminder: true, Transitions: []AlertTransition{transition}}
+			Expect(message.String()).To(Equal("Reminder: 1 alerts still firing\n" + transition.String()))
+		})
+	})
+
+	It(`encodes as JSON`, func() {
+		encoded, err := json.Marshal(NewAlertMessage(transition))
+		Expect(err).NotTo(HaveOccurred())
+		var decoded map[string]interface{}
+		Expect(json.Unmarshal(encoded, &decoded)).To(Succeed())
+		Expect(decoded["kind"]).To(Equal("alert"))
+		Expect(decoded["severity"]).To(Equal("critical"))
+		Expect(decoded["title"]).To(Equal("High traffic generated an alert"))
+		Expect(decoded["values"]).To(Equal(map[string]interface{}{"value": 12.0, "threshold": 10.0}))
+	})
+})
+
+var _ = Describe(`MultiNotification`, func() {
+	It(`sends the message to every notification`, func() {
+		first, second := &notificationMock{}, &notificationMock{}
+		MultiNotification{first, second}.Send(NewErrorMessage("failure"))
+		Expect(first.messages).To(Equal([]string{"failure\n"}))
+		Expect(second.messages).To(Equal([]string{"failure\n"}))
+	})
+})
+
+var _ = Describe(`WebhookNotification`, func() {
+	var (
+		server   *httptest.Server
+		mutex    sync.Mutex
+		received []Message
+		texts    []string
+		status   int
+		release  chan struct{}
+	)
+
+	BeforeEach(func() {
+		received, texts, status, release = nil, nil, http.StatusOK, nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var payload struct {
+				Message
+				Text string `json:"text"`
+			}
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(json.NewDecoder(r.Body).Decode(&payload)).To(Succeed())
+			if release != nil {
+				<-release
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			received = append(received, payload.Message)
+			texts = append(texts, payload.Text)
+			w.WriteHeader(status)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It(`posts the message as JSON`, func() {
+		webhook := NewWebhookNotification(server.URL, time.Second, 1)
+		webhook.Send(NewErrorMessage("failure"))
+		webhook.Close()
+		Expect(received).To(HaveLen(1))
+		Expect(received[0].Kind).To(Equal(ErrorMessage))
+		Expect(received[0].Title).To(Equal("failure"))
+		Expect(texts).To(Equal([]string{"failure\n"}))
+	})
+
+	It(`renders the text of the message with its templates`, func() {
+		templates, err := ParseTemplates("webhook", map[string]string{"error": `:warning: {{.Title}}`})
+		Expect(err).ToNot(HaveOccurred())
+		webhook := NewWebhookNotification(server.URL, time.Second, 1, NotificationTemplates(templates))
+		webhook.Send(NewErrorMessage("failure"))
+		webhook.Close()
+		Expect(texts).To(Equal([]string{":warning: failure"}))
+	})
+
+	It(`logs a failed delivery`, func() {
+		buf := new(bytes.Buffer)
+		log.SetOutput(buf)
+		log.SetFlags(0)
+		status = http.StatusInternalServerError
+
+		webhook := NewWebhookNotification(server.URL, time.Second, 1)
+		webhook.Send(NewErrorMessage("failure"))
+		webhook.Close()
+		Expect(buf.String()).To(ContainSubstring("Could not send the error notification to the webhook: 500 Internal Server Error"))
+	})
+
+	It(`drops the messages sent after it is closed`, func() {
+		buf := new(bytes.Buffer)
+		log.SetOutput(buf)
+		log.SetFlags(0)
+
+		webhook := NewWebhookNotification(server.URL, time.Second, 1)
+		webhook.Close()
+		webhook.Send(NewErrorMessage("failure"))
+		webhook.Close()
+		Expect(received).To(BeEmpty())
+		Expect(webhook.Dropped()).To(Equal(uint64(1)))
+		Expect(buf.String()).To(ContainSubstring("Could not send the error notification to the webhook: the webhook is closed"))
+	})
+
+	It(`drops the messages that overflow its queue instead of waiting for a slow webhook`, func() {
+		buf := new(bytes.Buffer)
+		log.SetOutput(buf)
+		log.SetFlags(0)
+		release = make(chan struct{})
+
+		webhook := NewWebhookNotification(server.URL, time.Second, 2)
+		sent := make(chan struct{})
+		go func() {
+			defer close(sent)
+			for i := 0; i < 10; i++ {
+				webhook.Send(NewErrorMessage("failure %d", i))
+			}
+		}()
+		Eventually(sent).Should(BeClosed())
+		close(release)
+		webhook.Close()
+
+		Expect(webhook.Dropped()).To(BeNumerically(">=", 7))
+		Expect(len(received) + int(webhook.Dropped())).To(Equal(10))
+		Expect(received[0].Title).To(Equal("failure 0"))
+		Expect(buf.String()).To(ContainSubstring("Could not send the error notification to the webhook: the queue is full"))
+	})
+})
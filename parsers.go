@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	errTimeNotPresent           = errors.New("time not present")
+	errInvalidTime              = errors.New("invalid time")
+	errStatusAndPayloadNotFound = errors.New("status code and payload not present")
+
+	httpMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
+	shortMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+	fixedZones      = map[int]*time.Location{}
+	fixedZonesMutex sync.RWMutex
+)
+
+const commonLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+var lineParsers = map[string]LineParser{
+	"regexp": ParseLogLine,
+	"fast":   ParseCommonLogLine,
+	"jsonl":  ParseJSONLogLine,
+}
+
+func LineParserByName(name string) (LineParser, error) {
+	parser, ok := lineParsers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown log parser: %s", name)
+	}
+	return parser, nil
+}
+
+type LineFormatter func(Event) string
+
+var lineFormatters = map[string]LineFormatter{
+	"clf":   FormatCommonLogLine,
+	"jsonl": FormatJSONLogLine,
+}
+
+func LineFormatterByName(name string) (LineFormatter, error) {
+	formatter, ok := lineFormatters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown log format: %s", name)
+	}
+	return formatter, nil
+}
+
+type jsonLogLine struct {
+	Time        time.Time `json:"time"`
+	Client      string    `json:"client"`
+	User        string    `json:"user,omitempty"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	Protocol    string    `json:"protocol"`
+	Status      int       `json:"status"`
+	Bytes       int       `json:"bytes"`
+	Referer     string    `json:"referer,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	Host        string    `json:"host,omitempty"`
+	RequestTime float64   `json:"request_time,omitempty"`
+}
+
+func ParseJSONLogLine(line string) (Event, error) {
+	var logLine jsonLogLine
+	if err := json.Unmarshal([]byte(line), &logLine); err != nil {
+		return Event{}, err
+	}
+	if logLine.Time.IsZero() {
+		return Event{}, errTimeNotPresent
+	}
+	return Event{
+		Client:      logLine.Client,
+		User:        logLine.User,
+		Time:        logLine.Time,
+		Method:      logLine.Method,
+		Path:        logLine.Path,
+		Protocol:    logLine.Protocol,
+		StatusCode:  logLine.Status,
+		PayloadSize: logLine.Bytes,
+		UserAgent:   logLine.UserAgent,
+		Referer:     logLine.Referer,
+		Host:        logLine.Host,
+		Duration:    time.Duration(math.Round(logLine.RequestTime*1e6)) * time.Microsecond,
+	}, nil
+}
+
+func FormatJSONLogLine(event Event) string {
+	line, _ := json.Marshal(jsonLogLine{
+		Time:        event.Time,
+		Client:      event.Client,
+		User:        event.User,
+		Method:      event.Method,
+		Path:        event.Path,
+		Protocol:    event.Protocol,
+		Status:      event.StatusCode,
+		Bytes:       event.PayloadSize,
+		Referer:     event.Referer,
+		UserAgent:   event.UserAgent,
+		Host:        event.Host,
+		RequestTime: event.Duration.Seconds(),
+	})
+	return string(line)
+}
+
+func FormatCommonLogLine(event Event) string {
+	return fmt.Sprintf(
+		"%s - %s [%s] \"%s %s %s\" %d %d %s %s",
+		orDash(event.Client),
+		orDash(event.User),
+		event.Time.Format(commonLogTimeFormat),
+		event.Method,
+		event.Path,
+		event.Protocol,
+		event.StatusCode,
+		event.PayloadSize,
+		strconv.Quote(orDash(event.Referer)),
+		strconv.Quote(orDash(event.UserAgent)),
+	)
+}
+
+func orDash(field string) string {
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+func ParseCommonLogLine(line string) (Event, error) {
+	timestamp, ok := scanTimestamp(line)
+	if !ok {
+		return Event{}, errTimeNotPresent
+	}
+	logDate, err := parseLogTime(timestamp)
+	if err != nil {
+		return Event{}, err
+	}
+
+	request := scanRequest(line)
+	statusCode, payloadSize, err := scanStatusCodeAndPayload(line)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		Client:      scanClient(line),
+		Time:        logDate,
+		Method:      scanMethod(request),
+		Path:        scanPath(request),
+		Protocol:    scanProtocol(request),
+		StatusCode:  statusCode,
+		PayloadSize: payloadSize,
+		UserAgent:   scanUserAgent(line),
+	}, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func digitRun(s string, start int) int {
+	end := start
+	for end < len(s) && isDigit(s[end]) {
+		end++
+	}
+	return end
+}
+
+func scanClient(line string) string {
+	for start := 0; start < len(line); start++ {
+		position := start
+		for octet := 0; octet < 4; octet++ {
+			end := digitRun(line, position)
+			if end == position || (octet < 3 && end-position > 3) {
+				break
+			}
+			if octet == 3 {
+				if end-position > 3 {
+					end = position + 3
+				}
+				return line[start:end]
+			}
+			if end == len(line) || line[end] != '.' {
+				break
+			}
+			position = end + 1
+		}
+	}
+	return ""
+}
+
+func isTimeCharacter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || isDigit(c) || c == '/' || c == ':' || c == '-' || c == '+' || isSpace(c)
+}
+
+func scanTimestamp(line string) (string, bool) {
+	for start := 0; start < len(line); start++ {
+		if line[start] != '[' {
+			continue
+		}
+		end := start + 1
+		for end < len(line) && isTimeCharacter(line[end]) {
+			end++
+		}
+		if end < len(line) && line[end] == ']' {
+			return line[start+1 : end], true
+		}
+		start = end - 1
+	}
+	return "", false
+}
+
+func scanRequest(line string) string {
+	for start := 0; start+1 < len(line); start++ {
+		if line[start] != '"' || line[start+1] < 'A' || line[start+1] > 'Z' {
+			continue
+		}
+		for end := start + 2; end < len(line); end++ {
+			if line[end] == '"' {
+				return line[start+1 : end]
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+func scanMethod(request string) string {
+	for start := 0; start < len(request); start++ {
+		for _, method := range httpMethods {
+			if len(request)-start >= len(method) && request[start:start+len(method)] == method {
+				return method
+			}
+		}
+	}
+	return ""
+}
+
+func scanProtocol(request string) string {
+	for start := 0; start+8 <= len(request); start++ {
+		if request[start:start+4] != "HTTP" {
+			continue
+		}
+		separator := request[start+4]
+		if (separator == '/' || separator == '|' || isSpace(separator)) && isDigit(request[start+5]) && request[start+6] == '.' && isDigit(request[start+7]) {
+			return request[start : start+8]
+		}
+	}
+	return ""
+}
+
+func isPathCharacter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || isDigit(c) || c == '-' || c == '/' || c == '?' || c == '&' || c == '=' || c == '.'
+}
+
+func scanPath(request string) string {
+	for start := 0; start < len(request); start++ {
+		if request[start] != '/' {
+			continue
+		}
+		end := start + 1
+		for end < len(request) && isPathCharacter(request[end]) {
+			end++
+		}
+		return request[start:end]
+	}
+	return ""
+}
+
+func scanUserAgent(line string) string {
+	if len(line) < 2 || line[len(line)-1] != '"' {
+		return ""
+	}
+	for start := len(line) - 2; start >= 0; start-- {
+		if line[start] == '"' {
+			return line[start+1 : len(line)-1]
+		}
+	}
+	return ""
+}
+
+func scanStatusCodeAndPayload(line string) (int, int, error) {
+	for start := 0; start < len(line); start++ {
+		if !isDigit(line[start]) {
+			continue
+		}
+		statusEnd := digitRun(line, start)
+		if statusEnd+1 >= len(line) || line[statusEnd] != ' ' || !isDigit(line[statusEnd+1]) {
+			start = statusEnd
+			continue
+		}
+		payloadEnd := digitRun(line, statusEnd+1)
+
+		statusCode, ok := atoi(line[start:statusEnd])
+		if !ok {
+			return 0, 0, fmt.Errorf("could not parse status code: %s to integer", line[start:statusEnd])
+		}
+		payloadSize, ok := atoi(line[statusEnd+1 : payloadEnd])
+		if !ok {
+			return 0, 0, fmt.Errorf("could not parse payload size: %s to integer", line[statusEnd+1:payloadEnd])
+		}
+		return statusCode, payloadSize, nil
+	}
+	return 0, 0, errStatusAndPayloadNotFound
+}
+
+func atoi(digits string) (int, bool) {
+	const maxInt = int(^uint(0) >> 1)
+	value := 0
+	for i := 0; i < len(digits); i++ {
+		digit := int(digits[i] - '0')
+		if value > (maxInt-digit)/10 {
+			return 0, false
+		}
+		value = value*10 + digit
+	}
+	return value, true
+}
+
+func parseLogTime(timestamp string) (time.Time, error) {
+	day, position, ok := fixedDigits(timestamp, 0, 1, 2)
+	if !ok || position >= len(timestamp) || timestamp[position] != '/' {
+		return time.Time{}, errInvalidTime
+	}
+	month, ok := parseShortMonth(timestamp, position+1)
+	if !ok || position+4 >= len(timestamp) || timestamp[position+4] != '/' {
+		return time.Time{}, errInvalidTime
+	}
+	year, position, ok := fixedDigits(timestamp, position+5, 4, 4)
+	if !ok || position >= len(timestamp) || timestamp[position] != ':' {
+		return time.Time{}, errInvalidTime
+	}
+	hour, position, ok := fixedDigits(timestamp, position+1, 1, 2)
+	if !ok || hour >= 24 || position >= len(timestamp) || timestamp[position] != ':' {
+		return time.Time{}, errInvalidTime
+	}
+	minute, position, ok := fixedDigits(timestamp, position+1, 2, 2)
+	if !ok || minute >= 60 || position >= len(timestamp) || timestamp[position] != ':' {
+		return time.Time{}, errInvalidTime
+	}
+	second, position, ok := fixedDigits(timestamp, position+1, 2, 2)
+	if !ok || second >= 60 || day < 1 || day > daysIn(time.Month(month), year) {
+		return time.Time{}, errInvalidTime
+	}
+
+	logDate := time.Date(year, time.Month(month), day, hour, minute, second, 0, time.UTC)
+	if position == len(timestamp) {
+		return logDate, nil
+	}
+	if timestamp[position] != ' ' {
+		return time.Time{}, errInvalidTime
+	}
+	for position < len(timestamp) && timestamp[position] == ' ' {
+		position++
+	}
+	if len(timestamp)-position != 5 || (timestamp[position] != '-' && timestamp[position] != '+') {
+		return time.Time{}, errInvalidTime
+	}
+	offsetHours, _, hoursOK := fixedDigits(timestamp, position+1, 2, 2)
+	offsetMinutes, _, minutesOK := fixedDigits(timestamp, position+3, 2, 2)
+	if !hoursOK || !minutesOK || offsetHours > 24 || offsetMinutes > 60 {
+		return time.Time{}, errInvalidTime
+	}
+	offset := (offsetHours*60 + offsetMinutes) * 60
+	if timestamp[position] == '-' {
+		offset = -offset
+	}
+
+	logDate = logDate.Add(-time.Duration(offset) * time.Second)
+	if _, localOffset := logDate.In(time.Local).Zone(); localOffset == offset {
+		return logDate.In(time.Local), nil
+	}
+	return logDate.In(fixedZone(offset)), nil
+}
+
+func fixedDigits(s string, start int, minDigits int, maxDigits int) (int, int, bool) {
+	value, position := 0, start
+	for position < len(s) && position-start < maxDigits && isDigit(s[position]) {
+		value = value*10 + int(s[position]-'0')
+		position++
+	}
+	return value, position, position-start >= minDigits
+}
+
+func parseShortMonth(s string, start int) (int, bool) {
+	if len(s)-start < 3 {
+		return 0, false
+	}
+	for i, month := range shortMonths {
+		matches := true
+		for j := 0; j < 3; j++ {
+			if s[start+j]|0x20 != month[j] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+func daysIn(month time.Month, year int) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func fixedZone(offset int) *time.Location {
+	fixedZonesMutex.RLock()
+	location, ok := fixedZones[offset]
+	fixedZonesMutex.RUnlock()
+	if ok {
+		return location
+	}
+
+	fixedZonesMutex.Lock()
+	defer fixedZonesMutex.Unlock()
+	if location, ok = fixedZones[offset]; !ok {
+		location = time.FixedZone("", offset)
+		fixedZones[offset] = location
+	}
+	return location
+}
@@ -0,0 +1,197 @@
+package main_test
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var malformedLogLines = []string{
+	``,
+	`malformed line`,
+	`209.160.24.63 - - "GET /product.screen HTTP 1.1" 200 2047`,
+	`209.160.24.63 - - [23/Dex/2015:18:22:20] "GET /product.screen HTTP 1.1" 200 2047`,
+	`209.160.24.63 - - [31/Feb/2015:18:22:20] "GET /product.screen HTTP 1.1" 200 2047`,
+	`209.160.24.63 - - [23/Dec/2015:24:22:20] "GET /product.screen HTTP 1.1" 200 2047`,
+	`209.160.24.63 - - [23/Dec/2015:18:2:20] "GET /product.screen HTTP 1.1" 200 2047`,
+	`209.160.24.63 - - [23/Dec/15:18:22:20] "GET /product.screen HTTP 1.1" 200 2047`,
+	`209.160.24.63 - - [23/Dec/2015:18:22:20 -07] "GET /product.screen HTTP 1.1" 200 2047`,
+	`209.160.24.63 - - [23/Dec/2015:18:22:20 -2500] "GET /product.screen HTTP 1.1" 200 2047`,
+	`209.160.24.63 - - [23/Dec/2015:18:22:20 ] "GET /product.screen HTTP 1.1" 200 2047`,
+	`209.160.24.63 - - [23/Dec/2015:18:22:20] "GET /product.screen HTTP 1.1" 200`,
+	`209.160.24.63 - - [23/Dec/2015:18:22:20] "GET /product.screen HTTP 1.1" - 2047`,
+	`209.160.24.63 - - [23/Dec/2015:18:22:20] "GET /product.screen HTTP 1.1" 99999999999999999999 2047`,
+	`209.160.24.63 - - [23/Dec/2015:18:22:20] "GET /product.screen HTTP 1.1" 200 99999999999999999999`,
+	`209.160.24.63 - - [bad] [01/Jan/2016:00:00:00] "GET /product.screen HTTP 1.1" 200 2047`,
+}
+
+var validLogLines = []string{
+	`209.160.24.63 - - [23/Dec/2015:18:22:21 -0700] "POST /cart.do?action=purchase&itemId=EST-21 HTTP/1.1" 200 486 "Mozilla/5.0 (Windows NT 6.1; WOW64)"`,
+	`209.160.24.63 - - [23/Dec/2015:18:22:21 -0000] "GET / HTTP/1.0" 304 0 "-" "curl/7.43.0"`,
+	`209.160.24.63 - - [23/Dec/2015:18:22:20 +0100] "GET /product.screen HTTP 1.1" 200 2047`,
+	`209.160.24.63 - - [23/Dec/2015:18:22:21    -0130] "GET / HTTP/1.0" 304 0 "-" "curl/7.43.0"`,
+	`209.160.24.63 - frank [3/dec/2015:8:22:21] "DELETE /api/v1/items/12 HTTP/2.0" 204 0`,
+	`1234.160.24.6345 - - [29/Feb/2016:18:22:21] "PATCH /a_b/c%20d HTTP|1.1" 500 12 "" ""`,
+	`example.com - - [01/Jan/2016:00:00:00] "OPTIONS * HTTP/1.1" 200 0 "http://10.0.0.1/"`,
+	`::1 - - [01/Jan/2016:00:00:00] "PROPFIND /GETTER HTTP/1.1" 207 10 "-" "with trailing newline"` + "\n",
+	`209.160.24.63 - - [01/Jan/2016:00:00:00] "get /lowercase" 200 1`,
+	`[01/Jan/2016:00:00:00] "GET /no-client" 0 0 "-" "agent "with" quotes"`,
+}
+
+func readLogLines(filename string) []string {
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+var _ = Describe(`ParseCommonLogLine`, func() {
+	Context(`when the line is valid`, func() {
+		It(`matches the output of the regexp parser`, func() {
+			for _, line := range append(validLogLines, readLogLines("sample.log")...) {
+				expected, expectedErr := ParseLogLine(line)
+				event, err := ParseCommonLogLine(line)
+				Expect(expectedErr).NotTo(HaveOccurred(), line)
+				Expect(err).NotTo(HaveOccurred(), line)
+				Expect(event).To(Equal(expected), line)
+			}
+		})
+
+		It(`parses the fields of the line`, func() {
+			event, err := ParseCommonLogLine(validLogLines[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(event).To(Equal(Event{
+				Client:      "209.160.24.63",
+				Time:        time.Date(2015, 12, 23, 18, 22, 21, 0, time.FixedZone("", -7*60*60)),
+				Method:      "POST",
+				Path:        "/cart.do?action=purchase&itemId=EST-21",
+				Protocol:    "HTTP/1.1",
+				StatusCode:  200,
+				PayloadSize: 486,
+				UserAgent:   "Mozilla/5.0 (Windows NT 6.1; WOW64)",
+			}))
+		})
+	})
+
+	Context(`when the line is malformed`, func() {
+		It(`returns an error like the regexp parser`, func() {
+			for _, line := range malformedLogLines {
+				_, expectedErr := ParseLogLine(line)
+				_, err := ParseCommonLogLine(line)
+				Expect(expectedErr).To(HaveOccurred(), line)
+				Expect(err).To(HaveOccurred(), line)
+			}
+		})
+	})
+
+	It(`does not allocate`, func() {
+		line := readLogLines("sample.log")[0]
+		allocations := testing.AllocsPerRun(100, func() {
+			ParseCommonLogLine(line)
+		})
+		Expect(allocations).To(BeZero())
+	})
+})
+
+var _ = Describe(`ParseJSONLogLine`, func() {
+	It(`parses the fields of the line`, func() {
+		event, err := ParseJSONLogLine(`{"time":"2015-12-23T18:22:21-07:00","client":"209.160.24.63","method":"GET","path":"/api/users","protocol":"HTTP/1.1","status":200,"bytes":486,"user_agent":"curl/7.43.0","host":"example.com","request_time":0.125}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(event.Time.Equal(time.Date(2015, 12, 24, 1, 22, 21, 0, time.UTC))).To(BeTrue())
+		event.Time = time.Time{}
+		Expect(event).To(Equal(Event{
+			Client:      "209.160.24.63",
+			Method:      "GET",
+			Path:        "/api/users",
+			Protocol:    "HTTP/1.1",
+			StatusCode:  200,
+			PayloadSize: 486,
+			UserAgent:   "curl/7.43.0",
+			Host:        "example.com",
+			Duration:    125 * time.Millisecond,
+		}))
+	})
+
+	It(`rejects lines that are not json or have no time`, func() {
+		_, err := ParseJSONLogLine(validLogLines[0])
+		Expect(err).To(HaveOccurred())
+		_, err = ParseJSONLogLine(`{"client":"209.160.24.63"}`)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe(`LineParserByName`, func() {
+	It(`returns the parser with the name`, func() {
+		parser, err := LineParserByName("fast")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reflect.ValueOf(parser).Pointer()).To(Equal(reflect.ValueOf(ParseCommonLogLine).Pointer()))
+	})
+
+	It(`rejects unknown parsers`, func() {
+		_, err := LineParserByName("slow")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func FuzzParseCommonLogLine(f *testing.F) {
+	for _, line := range append(append(validLogLines, malformedLogLines...), readLogLines("sample.log")...) {
+		f.Add(line)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		expected, expectedErr := ParseLogLine(line)
+		event, err := ParseCommonLogLine(line)
+		if (expectedErr == nil) != (err == nil) {
+			t.Fatalf("parsing %q: regexp parser error %v, fast parser error %v", line, expectedErr, err)
+		}
+		if !reflect.DeepEqual(event, expected) {
+			t.Fatalf("parsing %q: regexp parser %+v, fast parser %+v", line, expected, event)
+		}
+	})
+}
+
+func benchmarkLineParser(b *testing.B, parser LineParser) {
+	lines := readLogLines("sample.log")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parser(lines[i%len(lines)]); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "lines/s")
+}
+
+func BenchmarkParseLogLine(b *testing.B) {
+	benchmarkLineParser(b, ParseLogLine)
+}
+
+func BenchmarkParseCommonLogLine(b *testing.B) {
+	benchmarkLineParser(b, ParseCommonLogLine)
+}
+
+func BenchmarkParseCommonLogLineWithTimezone(b *testing.B) {
+	line := strings.Replace(readLogLines("sample.log")[0], "18:22:19]", "18:22:19 -0700]", 1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseCommonLogLine(line); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "lines/s")
+}
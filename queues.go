@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+type QueuePolicy int
+
+const (
+	BlockPolicy QueuePolicy = iota
+	DropOldestPolicy
+	DropNewestPolicy
+	SamplePolicy
+)
+
+const defaultSampleRate = 10
+
+var queuePolicies = map[string]QueuePolicy{
+	"block":       BlockPolicy,
+	"drop-oldest": DropOldestPolicy,
+	"drop-newest": DropNewestPolicy,
+	"sample":      SamplePolicy,
+}
+
+func ParseQueuePolicy(name string) (QueuePolicy, error) {
+	policy, ok := queuePolicies[name]
+	if !ok {
+		return BlockPolicy, fmt.Errorf("unknown queue policy: %s", name)
+	}
+	return policy, nil
+}
+
+func (q QueuePolicy) String() string {
+	for name, policy := range queuePolicies {
+		if policy == q {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+type QueueConfig struct {
+	Capacity   int
+	Policy     QueuePolicy
+	SampleRate int
+}
+
+type QueueStats struct {
+	Name     string
+	Policy   QueuePolicy
+	Depth    int
+	Capacity int
+	Received uint64
+	Dropped  uint64
+}
+
+func (q QueueStats) String() string {
+	return fmt.Sprintf("queue=%s policy=%s depth=%d/%d received=%d dropped=%d", q.Name, q.Policy, q.Depth, q.Capacity, q.Received, q.Dropped)
+}
+
+type QueueReporter interface {
+	QueueStats() []QueueStats
+}
+
+type EventQueue struct {
+	name   string
+	config QueueConfig
+
+	events  chan Event
+	closing chan struct{}
+	once    sync.Once
+
+	mutex  sync.Mutex
+	closed bool
+
+	received uint64
+	dropped  uint64
+	overflow uint64
+}
+
+func NewEventQueue(name string, config QueueConfig) *EventQueue {
+	if config.SampleRate <= 0 {
+		config.SampleRate = defaultSampleRate
+	}
+	return &EventQueue{
+		name:    name,
+		config:  config,
+		events:  make(chan Event, config.Capacity),
+		closing: make(chan struct{}),
+	}
+}
+
+func (q *EventQueue) Events() <-chan Event {
+	return q.events
+}
+
+func (q *EventQueue) Push(event Event) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return false
+	}
+	atomic.AddUint64(&q.received, 1)
+
+	select {
+	case q.events <- event:
+		return true
+	default:
+	}
+
+	switch q.config.Policy {
+	case DropNewestPolicy:
+		atomic.AddUint64(&q.dropped, 1)
+		return true
+	case DropOldestPolicy:
+		if q.config.Capacity == 0 {
+			break
+		}
+		for {
+			select {
+			case q.events <- event:
+				return true
+			default:
+			}
+			select {
+			case <-q.events:
+				atomic.AddUint64(&q.dropped, 1)
+			default:
+			}
+		}
+	case SamplePolicy:
+		if q.overflow++; q.overflow%uint64(q.config.SampleRate) != 0 {
+			atomic.AddUint64(&q.dropped, 1)
+			return true
+		}
+	}
+
+	select {
+	case q.events <- event:
+		return true
+	case <-q.closing:
+		return false
+	}
+}
+
+func (q *EventQueue) Close() {
+	q.once.Do(func() {
+		close(q.closing)
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		q.closed = true
+		close(q.events)
+	})
+}
+
+func (q *EventQueue) Closed() bool {
+	select {
+	case <-q.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *EventQueue) Stats() QueueStats {
+	return QueueStats{
+		Name:     q.name,
+		Policy:   q.config.Policy,
+		Depth:    len(q.events),
+		Capacity: q.config.Capacity,
+		Received: atomic.LoadUint64(&q.received),
+		Dropped:  atomic.LoadUint64(&q.dropped),
+	}
+}
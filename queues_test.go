@@ -0,0 +1,186 @@
+package main_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`EventQueue`, func() {
+	var (
+		queue  *EventQueue
+		config QueueConfig
+	)
+
+	push := func() {
+		queue = NewEventQueue("test", config)
+		for i := 1; i <= 5; i++ {
+			queue.Push(Event{StatusCode: i})
+		}
+		queue.Close()
+	}
+
+	drain := func() []int {
+		var statusCodes []int
+		for event := range queue.Events() {
+			statusCodes = append(statusCodes, event.StatusCode)
+		}
+		return statusCodes
+	}
+
+	Context(`when the queue drops the oldest events`, func() {
+		BeforeEach(func() {
+			config = QueueConfig{Capacity: 2, Policy: DropOldestPolicy}
+		})
+
+		JustBeforeEach(push)
+
+		It(`keeps the newest events`, func() {
+			Expect(drain()).To(Equal([]int{4, 5}))
+			Expect(queue.Stats()).To(Equal(QueueStats{Name: "test", Policy: DropOldestPolicy, Capacity: 2, Received: 5, Dropped: 3}))
+		})
+	})
+
+	Context(`when the queue drops the newest events`, func() {
+		BeforeEach(func() {
+			config = QueueConfig{Capacity: 2, Policy: DropNewestPolicy}
+		})
+
+		JustBeforeEach(push)
+
+		It(`keeps the oldest events`, func() {
+			Expect(drain()).To(Equal([]int{1, 2}))
+			Expect(queue.Stats().Dropped).To(Equal(uint64(3)))
+		})
+	})
+
+	Context(`when the queue samples the overflowing events`, func() {
+		BeforeEach(func() {
+			config = QueueConfig{Capacity: 2, Policy: SamplePolicy, SampleRate: 2}
+		})
+
+		JustBeforeEach(func() {
+			queue = NewEventQueue("test", config)
+			go func() {
+				for i := 1; i <= 6; i++ {
+					queue.Push(Event{StatusCode: i})
+				}
+				queue.Close()
+			}()
+		})
+
+		It(`keeps one of every sample rate overflowing events`, func() {
+			Eventually(func() uint64 { return queue.Stats().Dropped }).Should(Equal(uint64(1)))
+			Expect(<-queue.Events()).To(Equal(Event{StatusCode: 1}))
+			Eventually(func() uint64 { return queue.Stats().Dropped }).Should(Equal(uint64(2)))
+			Expect(drain()).To(Equal([]int{2, 4, 6}))
+		})
+	})
+
+	Context(`when the queue is closed while blocked`, func() {
+		BeforeEach(func() {
+			config = QueueConfig{Capacity: 2, Policy: BlockPolicy}
+		})
+
+		JustBeforeEach(func() {
+			queue = NewEventQueue("test", config)
+			queue.Push(Event{})
+			queue.Push(Event{})
+		})
+
+		It(`stops blocking and rejects the event`, func() {
+			pushed := make(chan bool)
+			go func() { pushed <- queue.Push(Event{}) }()
+			queue.Close()
+			Eventually(pushed).Should(Receive(BeFalse()))
+			Expect(queue.Push(Event{})).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe(`ParseQueuePolicy`, func() {
+	It(`parses the policy names`, func() {
+		for name, expected := range map[string]QueuePolicy{"block": BlockPolicy, "drop-oldest": DropOldestPolicy, "drop-newest": DropNewestPolicy, "sample": SamplePolicy} {
+			policy, err := ParseQueuePolicy(name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(policy).To(Equal(expected))
+			Expect(policy.String()).To(Equal(name))
+		}
+	})
+
+	It(`rejects unknown policies`, func() {
+		_, err := ParseQueuePolicy("unbounded")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+type channelLogReader struct {
+	logs chan Event
+}
+
+func (c *channelLogReader) Read() <-chan Event {
+	return c.logs
+}
+
+func (c *channelLogReader) Close() {}
+
+type notifyingAlert struct {
+	every        int
+	notification Notification
+
+	checked int
+}
+
+func (n *notifyingAlert) Check(event Event) {
+	if n.checked++; n.checked%n.every == 0 {
+		n.notification.Send(NewErrorMessage("alert"))
+	}
+}
+
+func benchmarkPipeline(b *testing.B, policy QueuePolicy) {
+	slowNotification := NewNotificationSender(func(message string) {
+		time.Sleep(time.Millisecond)
+	})
+	reader := &channelLogReader{logs: make(chan Event, 1024)}
+	config := QueueConfig{Capacity: 1024, Policy: policy}
+	monitor := NewSummaryStatsTrafficMonitor(time.Hour, slowNotification, MonitorQueue(config))
+	defer monitor.Stop()
+	app := NewApplication(reader, monitor, &notifyingAlert{every: 100, notification: slowNotification})
+	app.SetAlertQueue(config)
+
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			reader.logs <- Event{Path: "/section1/page1", StatusCode: 200, PayloadSize: 100}
+		}
+		close(reader.logs)
+	}()
+	app.Run()
+	b.StopTimer()
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "events/s")
+	for _, stats := range app.QueueStats() {
+		if stats.Name == "alert" {
+			b.ReportMetric(float64(stats.Dropped)/float64(b.N), "dropped/event")
+		}
+	}
+}
+
+func BenchmarkPipelineBlockWithSlowNotification(b *testing.B) {
+	benchmarkPipeline(b, BlockPolicy)
+}
+
+func BenchmarkPipelineDropOldestWithSlowNotification(b *testing.B) {
+	benchmarkPipeline(b, DropOldestPolicy)
+}
+
+func BenchmarkPipelineDropNewestWithSlowNotification(b *testing.B) {
+	benchmarkPipeline(b, DropNewestPolicy)
+}
+
+func BenchmarkPipelineSampleWithSlowNotification(b *testing.B) {
+	benchmarkPipeline(b, SamplePolicy)
+}
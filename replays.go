@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type ReplayLogReader struct {
+	reader LogReader
+	clock  Clock
+	speed  float64
+
+	events  chan Event
+	closing chan struct{}
+	once    sync.Once
+}
+
+func NewReplayLogReader(reader LogReader, clock Clock, speed float64) *ReplayLogReader {
+	replay := &ReplayLogReader{
+		reader:  reader,
+		clock:   clock,
+		speed:   speed,
+		events:  make(chan Event),
+		closing: make(chan struct{}),
+	}
+	go replay.play()
+	return replay
+}
+
+func (r *ReplayLogReader) Read() <-chan Event {
+	return r.events
+}
+
+func (r *ReplayLogReader) Close() {
+	r.once.Do(func() {
+		close(r.closing)
+		r.reader.Close()
+	})
+}
+
+func (r *ReplayLogReader) QueueStats() []QueueStats {
+	if reporter, ok := r.reader.(QueueReporter); ok {
+		return reporter.QueueStats()
+	}
+	return nil
+}
+
+func (r *ReplayLogReader) play() {
+	defer close(r.events)
+	var origin, started time.Time
+	for event := range r.reader.Read() {
+		if r.speed > 0 {
+			if started.IsZero() {
+				origin, started = event.Time, r.clock.Now()
+			}
+			if !r.wait(started.Add(time.Duration(float64(event.Time.Sub(origin)) / r.speed))) {
+				return
+			}
+		}
+		select {
+		case r.events <- event:
+		case <-r.closing:
+			return
+		}
+	}
+}
+
+func (r *ReplayLogReader) wait(due time.Time) bool {
+	for {
+		wait := due.Sub(r.clock.Now())
+		if wait <= 0 {
+			return true
+		}
+		select {
+		case <-r.clock.After(wait):
+		case <-r.closing:
+			return false
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`ReplayLogReader`, func() {
+	var (
+		reader    *ReplayLogReader
+		clock     *FakeClock
+		speed     float64
+		eventTime time.Time
+		events    []Event
+	)
+
+	BeforeEach(func() {
+		clock = NewFakeClock(time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC))
+		eventTime = time.Date(2015, time.December, 23, 18, 0, 0, 0, time.UTC)
+		events = []Event{
+			{Time: eventTime},
+			{Time: eventTime.Add(10 * time.Second)},
+			{Time: eventTime.Add(20 * time.Second)},
+		}
+	})
+
+	JustBeforeEach(func() {
+		logs := &channelLogReader{logs: make(chan Event, len(events))}
+		for _, event := range events {
+			logs.logs <- event
+		}
+		close(logs.logs)
+		reader = NewReplayLogReader(logs, clock, speed)
+	})
+
+	AfterEach(func() {
+		reader.Close()
+	})
+
+	Context(`when replaying as fast as possible`, func() {
+		BeforeEach(func() {
+			speed = 0
+		})
+
+		It(`sends every event without waiting`, func() {
+			for _, event := range events {
+				Eventually(reader.Read()).Should(Receive(Equal(event)))
+			}
+			Eventually(reader.Read()).Should(BeClosed())
+		})
+	})
+
+	Context(`when replaying at twice the speed`, func() {
+		BeforeEach(func() {
+			speed = 2
+		})
+
+		It(`spaces the events by half of their time apart`, func() {
+			Eventually(reader.Read()).Should(Receive(Equal(events[0])))
+
+			Eventually(clock.Timers).Should(Equal(1))
+			clock.Advance(4 * time.Second)
+			Consistently(reader.Read(), 100*time.Millisecond).ShouldNot(Receive())
+			clock.Advance(1 * time.Second)
+			Eventually(reader.Read()).Should(Receive(Equal(events[1])))
+
+			Eventually(clock.Timers).Should(Equal(1))
+			clock.Advance(5 * time.Second)
+			Eventually(reader.Read()).Should(Receive(Equal(events[2])))
+			Eventually(reader.Read()).Should(BeClosed())
+		})
+	})
+})
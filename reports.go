@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/url"
+	"sort"
+	"text/tabwriter"
+	"text/template"
+	"time"
+)
+
+const (
+	reportResolution   = time.Minute
+	maxTimelinePeriods = 48
+)
+
+var timelineIntervals = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour}
+
+type TrafficPeriod struct {
+	Start             time.Time `json:"start"`
+	End               time.Time `json:"end"`
+	Requests          int64     `json:"requests"`
+	Bytes             int64     `json:"bytes"`
+	Errors            int64     `json:"errors"`
+	RequestsPerSecond float64   `json:"requests_per_second"`
+}
+
+type StatusCodeCount struct {
+	StatusCode int     `json:"status_code"`
+	Count      int64   `json:"count"`
+	Share      float64 `json:"share"`
+}
+
+type EndpointLatency struct {
+	Endpoint string  `json:"endpoint"`
+	Count    int64   `json:"count"`
+	Average  float64 `json:"average_ms"`
+	Max      float64 `json:"max_ms"`
+}
+
+type Report struct {
+	Start            time.Time           `json:"start"`
+	End              time.Time           `json:"end"`
+	Totals           TrafficStatistics   `json:"totals"`
+	BytesPerSecond   float64             `json:"bytes_per_second"`
+	Interval         time.Duration       `json:"-"`
+	Timeline         []TrafficPeriod     `json:"timeline"`
+	PeakPeriods      []TrafficPeriod     `json:"peak_periods"`
+	TopSections      []TrafficStatistics `json:"top_sections"`
+	TopClients       []TrafficStatistics `json:"top_clients"`
+	StatusCodes      []StatusCodeCount   `json:"status_codes"`
+	ErrorHotspots    []TrafficStatistics `json:"error_hotspots"`
+	SlowestEndpoints []EndpointLatency   `json:"slowest_endpoints"`
+}
+
+type endpointLatency struct {
+	count int64
+	total time.Duration
+	max   time.Duration
+}
+
+type ReportBuilder struct {
+	top int
+
+	start       time.Time
+	end         time.Time
+	totals      map[string]*TrafficStatistics
+	periods     map[time.Time]*TrafficPeriod
+	sections    map[string]*TrafficStatistics
+	clients     map[string]*TrafficStatistics
+	statusCodes map[int]int64
+	endpoints   map[string]*endpointLatency
+}
+
+func NewReportBuilder(top int) *ReportBuilder {
+	return &ReportBuilder{
+		top:         top,
+		totals:      map[string]*TrafficStatistics{},
+		periods:     map[time.Time]*TrafficPeriod{},
+		sections:    map[string]*TrafficStatistics{},
+		clients:     map[string]*TrafficStatistics{},
+		statusCodes: map[int]int64{},
+		endpoints:   map[string]*endpointLatency{},
+	}
+}
+
+func (r *ReportBuilder) Add(event Event) {
+	if r.start.IsZero() || event.Time.Before(r.start) {
+		r.start = event.Time
+	}
+	if event.Time.After(r.end) {
+		r.end = event.Time
+	}
+
+	updateStatistics(r.totals, "Total Traffic", event)
+	if section := SectionKey(event); section != "" {
+		updateStatistics(r.sections, section, event)
+	}
+	if event.Client != "" {
+		updateStatistics(r.clients, event.Client, event)
+	}
+	r.statusCodes[event.StatusCode]++
+
+	start := event.Time.Truncate(reportResolution)
+	period, ok := r.periods[start]
+	if !ok {
+		period = &TrafficPeriod{Start: start, End: start.Add(reportResolution)}
+		r.periods[start] = period
+	}
+	addToPeriod(period, event)
+
+	if event.Duration > 0 {
+		endpoint := event.Method + " " + endpointPath(event.Path)
+		latency, ok := r.endpoints[endpoint]
+		if !ok {
+			latency = &endpointLatency{}
+			r.endpoints[endpoint] = latency
+		}
+		latency.count++
+		latency.total += event.Duration
+		if event.Duration > latency.max {
+			latency.max = event.Duration
+		}
+	}
+}
+
+func (r *ReportBuilder) Report() Report {
+	report := Report{Start: r.start, End: r.end, Interval: timelineInterval(r.end.Sub(r.start))}
+	if totals, ok := r.totals["Total Traffic"]; ok {
+		report.Totals = *totals
+	}
+	if seconds := r.end.Sub(r.start).Seconds(); seconds > 0 {
+		report.BytesPerSecond = float64(report.Totals.TotalPayloadSize) / seconds
+	}
+
+	report.Timeline = r.timeline(report.Interval)
+	report.PeakPeriods = r.peakPeriods()
+	report.TopSections = topStatistics(r.sections, r.top, func(s *TrafficStatistics) int64 { return s.Count })
+	report.TopClients = topStatistics(r.clients, r.top, func(s *TrafficStatistics) int64 { return s.Count })
+	report.ErrorHotspots = topStatistics(r.sections, r.top, func(s *TrafficStatistics) int64 { return int64(s.ClientFailures + s.ServerFailures) })
+
+	for statusCode, count := range r.statusCodes {
+		report.StatusCodes = append(report.StatusCodes, StatusCodeCount{StatusCode: statusCode, Count: count, Share: float64(count) / float64(report.Totals.Count)})
+	}
+	sort.Slice(report.StatusCodes, func(i, j int) bool { return report.StatusCodes[i].StatusCode < report.StatusCodes[j].StatusCode })
+
+	for endpoint, latency := range r.endpoints {
+		report.SlowestEndpoints = append(report.SlowestEndpoints, EndpointLatency{
+			Endpoint: endpoint,
+			Count:    latency.count,
+			Average:  milliseconds(latency.total / time.Duration(latency.count)),
+			Max:      milliseconds(latency.max),
+		})
+	}
+	sort.Slice(report.SlowestEndpoints, func(i, j int) bool {
+		if report.SlowestEndpoints[i].Average != report.SlowestEndpoints[j].Average {
+			return report.SlowestEndpoints[i].Average > report.SlowestEndpoints[j].Average
+		}
+		return report.SlowestEndpoints[i].Endpoint < report.SlowestEndpoints[j].Endpoint
+	})
+	if len(report.SlowestEndpoints) > r.top {
+		report.SlowestEndpoints = report.SlowestEndpoints[:r.top]
+	}
+	return report
+}
+
+func (r *ReportBuilder) timeline(interval time.Duration) []TrafficPeriod {
+	if len(r.periods) == 0 {
+		return nil
+	}
+	periods := map[time.Time]*TrafficPeriod{}
+	for start, minute := range r.periods {
+		start = start.Truncate(interval)
+		period, ok := periods[start]
+		if !ok {
+			period = &TrafficPeriod{Start: start, End: start.Add(interval)}
+			periods[start] = period
+		}
+		period.Requests += minute.Requests
+		period.Bytes += minute.Bytes
+		period.Errors += minute.Errors
+	}
+
+	var timeline []TrafficPeriod
+	for start := r.start.Truncate(interval); !start.After(r.end); start = start.Add(interval) {
+		period, ok := periods[start]
+		if !ok {
+			period = &TrafficPeriod{Start: start, End: start.Add(interval)}
+		}
+		period.RequestsPerSecond = float64(period.Requests) / interval.Seconds()
+		timeline = append(timeline, *period)
+	}
+	return timeline
+}
+
+func (r *ReportBuilder) peakPeriods() []TrafficPeriod {
+	var peaks []TrafficPeriod
+	for _, period := range r.periods {
+		period.RequestsPerSecond = float64(period.Requests) / reportResolution.Seconds()
+		peaks = append(peaks, *period)
+	}
+	sort.Slice(peaks, func(i, j int) bool {
+		if peaks[i].Requests != peaks[j].Requests {
+			return peaks[i].Requests > peaks[j].Requests
+		}
+		return peaks[i].Start.Before(peaks[j].Start)
+	})
+	if len(peaks) > r.top {
+		peaks = peaks[:r.top]
+	}
+	return peaks
+}
+
+func addToPeriod(period *TrafficPeriod, event Event) {
+	period.Requests++
+	period.Bytes += int64(event.PayloadSize)
+	if event.StatusCode >= 400 {
+		period.Errors++
+	}
+}
+
+func topStatistics(statistics map[string]*TrafficStatistics, top int, rank func(*TrafficStatistics) int64) []TrafficStatistics {
+	var ranked []TrafficStatistics
+	for _, statistic := range statistics {
+		if rank(statistic) > 0 {
+			ranked = append(ranked, *statistic)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if rank(&ranked[i]) != rank(&ranked[j]) {
+			return rank(&ranked[i]) > rank(&ranked[j])
+		}
+		return ranked[i].Section < ranked[j].Section
+	})
+	if len(ranked) > top {
+		ranked = ranked[:top]
+	}
+	return ranked
+}
+
+func timelineInterval(span time.Duration) time.Duration {
+	for _, interval := range timelineIntervals {
+		if span/interval < maxTimelinePeriods {
+			return interval
+		}
+	}
+	return timelineIntervals[len(timelineIntervals)-1]
+}
+
+func endpointPath(path string) string {
+	if endpointURL, err := url.Parse(path); err == nil {
+		return endpointURL.Path
+	}
+	return path
+}
+
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
+
+type ReportWriter func(io.Writer, Report) error
+
+var reportWriters = map[string]ReportWriter{
+	"text": WriteTextReport,
+	"json": WriteJSONReport,
+	"html": WriteHTMLReport,
+}
+
+func ReportWriterByName(name string) (ReportWriter, error) {
+	writer, ok := reportWriters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown report format: %s", name)
+	}
+	return writer, nil
+}
+
+func WriteJSONReport(w io.Writer, report Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+var reportFunctions = map[string]interface{}{
+	"time": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05 -0700")
+	},
+	"percent": func(share float64) string {
+		return fmt.Sprintf("%.1f%%", share*100)
+	},
+	"bytes": formatBytes,
+	"errors": func(s TrafficStatistics) int {
+		return s.ClientFailures + s.ServerFailures
+	},
+	"width": func(requests int64, timeline []TrafficPeriod) float64 {
+		var peak int64
+		for _, period := range timeline {
+			if period.Requests > peak {
+				peak = period.Requests
+			}
+		}
+		if peak == 0 {
+			return 0
+		}
+		return float64(requests) / float64(peak) * 100
+	},
+}
+
+func formatBytes(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+var textReportTemplate = template.Must(template.New("text").Funcs(reportFunctions).Parse(`Traffic report from {{time .Start}} to {{time .End}}
+
+Requests:	{{.Totals.Count}}
+Bandwidth:	{{bytes .Totals.TotalPayloadSize}} ({{printf "%.1f" .BytesPerSecond}} B/s)
+Average payload:	{{printf "%.1f" .Totals.AveragePayloadSize}} B
+
+Requests over time (every {{.Interval}})
+Start	Requests	Req/s	Bytes	Errors
+{{range .Timeline}}{{time .Start}}	{{.Requests}}	{{printf "%.2f" .RequestsPerSecond}}	{{bytes .Bytes}}	{{.Errors}}
+{{end}}
+Peak periods
+Start	Requests	Req/s	Errors
+{{range .PeakPeriods}}{{time .Start}}	{{.Requests}}	{{printf "%.2f" .RequestsPerSecond}}	{{.Errors}}
+{{end}}
+Top sections
+Section	Requests	Bytes	Errors
+{{range .TopSections}}{{.Section}}	{{.Count}}	{{bytes .TotalPayloadSize}}	{{errors .}}
+{{end}}
+Top clients
+Client	Requests	Bytes	Errors
+{{range .TopClients}}{{.Section}}	{{.Count}}	{{bytes .TotalPayloadSize}}	{{errors .}}
+{{end}}
+Status codes
+Status	Requests	Share
+{{range .StatusCodes}}{{.StatusCode}}	{{.Count}}	{{percent .Share}}
+{{end}}
+Error hotspots
+Section	Errors	Client Failures	Server Failures
+{{range .ErrorHotspots}}{{.Section}}	{{errors .}}	{{.ClientFailures}}	{{.ServerFailures}}
+{{end}}
+Slowest endpoints
+{{if .SlowestEndpoints}}Endpoint	Requests	Average	Max
+{{range .SlowestEndpoints}}{{.Endpoint}}	{{.Count}}	{{printf "%.1fms" .Average}}	{{printf "%.1fms" .Max}}
+{{end}}{{else}}No request durations in the log
+{{end}}`))
+
+func WriteTextReport(w io.Writer, report Report) error {
+	tabs := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if err := textReportTemplate.Execute(tabs, report); err != nil {
+		return err
+	}
+	return tabs.Flush()
+}
+
+var htmlReportTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(reportFunctions).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Traffic report from {{time .Start}} to {{time .End}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 0.3em 0.8em; border-bottom: 1px solid #ddd; text-align: left; }
+td.bar { width: 30em; }
+td.bar div { background: #4a7ab5; height: 1em; }
+</style>
+</head>
+<body>
+<h1>Traffic report</h1>
+<p>{{time .Start}} to {{time .End}}</p>
+<table>
+<tr><th>Requests</th><td>{{.Totals.Count}}</td></tr>
+<tr><th>Bandwidth</th><td>{{bytes .Totals.TotalPayloadSize}} ({{printf "%.1f" .BytesPerSecond}} B/s)</td></tr>
+<tr><th>Average payload</th><td>{{printf "%.1f" .Totals.AveragePayloadSize}} B</td></tr>
+</table>
+<h2>Requests over time (every {{.Interval}})</h2>
+<table>
+<tr><th>Start</th><th>Requests</th><th></th><th>Req/s</th><th>Bytes</th><th>Errors</th></tr>
+{{$timeline := .Timeline}}{{range .Timeline}}<tr><td>{{time .Start}}</td><td>{{.Requests}}</td><td class="bar"><div style="width: {{width .Requests $timeline}}%"></div></td><td>{{printf "%.2f" .RequestsPerSecond}}</td><td>{{bytes .Bytes}}</td><td>{{.Errors}}</td></tr>
+{{end}}</table>
+<h2>Peak periods</h2>
+<table>
+<tr><th>Start</th><th>Requests</th><th>Req/s</th><th>Errors</th></tr>
+{{range .PeakPeriods}}<tr><td>{{time .Start}}</td><td>{{.Requests}}</td><td>{{printf "%.2f" .RequestsPerSecond}}</td><td>{{.Errors}}</td></tr>
+{{end}}</table>
+<h2>Top sections</h2>
+<table>
+<tr><th>Section</th><th>Requests</th><th>Bytes</th><th>Errors</th></tr>
+{{range .TopSections}}<tr><td>{{.Section}}</td><td>{{.Count}}</td><td>{{bytes .TotalPayloadSize}}</td><td>{{errors .}}</td></tr>
+{{end}}</table>
+<h2>Top clients</h2>
+<table>
+<tr><th>Client</th><th>Requests</th><th>Bytes</th><th>Errors</th></tr>
+{{range .TopClients}}<tr><td>{{.Section}}</td><td>{{.Count}}</td><td>{{bytes .TotalPayloadSize}}</td><td>{{errors .}}</td></tr>
+{{end}}</table>
+<h2>Status codes</h2>
+<table>
+<tr><th>Status</th><th>Requests</th><th>Share</th></tr>
+{{range .StatusCodes}}<tr><td>{{.StatusCode}}</td><td>{{.Count}}</td><td>{{percent .Share}}</td></tr>
+{{end}}</table>
+<h2>Error hotspots</h2>
+<table>
+<tr><th>Section</th><th>Errors</th><th>Client Failures</th><th>Server Failures</th></tr>
+{{range .ErrorHotspots}}<tr><td>{{.Section}}</td><td>{{errors .}}</td><td>{{.ClientFailures}}</td><td>{{.ServerFailures}}</td></tr>
+{{end}}</table>
+<h2>Slowest endpoints</h2>
+{{if .SlowestEndpoints}}<table>
+<tr><th>Endpoint</th><th>Requests</th><th>Average</th><th>Max</th></tr>
+{{range .SlowestEndpoints}}<tr><td>{{.Endpoint}}</td><td>{{.Count}}</td><td>{{printf "%.1fms" .Average}}</td><td>{{printf "%.1fms" .Max}}</td></tr>
+{{end}}</table>{{else}}<p>No request durations in the log</p>{{end}}
+</body>
+</html>
+`))
+
+func WriteHTMLReport(w io.Writer, report Report) error {
+	return htmlReportTemplate.Execute(w, report)
+}
@@ -0,0 +1,119 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`ReportBuilder`, func() {
+	var (
+		builder *ReportBuilder
+		report  Report
+		start   time.Time
+	)
+
+	BeforeEach(func() {
+		start = time.Date(2015, time.December, 23, 18, 0, 0, 0, time.UTC)
+		builder = NewReportBuilder(2)
+		events := []Event{
+			{Client: "10.0.0.1", Time: start, Method: "GET", Path: "/api/users?page=1", StatusCode: 200, PayloadSize: 100, Duration: 100 * time.Millisecond},
+			{Client: "10.0.0.1", Time: start.Add(10 * time.Second), Method: "GET", Path: "/api/users?page=2", StatusCode: 500, PayloadSize: 300, Duration: 300 * time.Millisecond},
+			{Client: "10.0.0.2", Time: start.Add(20 * time.Second), Method: "GET", Path: "/static/app.js", StatusCode: 200, PayloadSize: 1000, Duration: 10 * time.Millisecond},
+			{Client: "10.0.0.3", Time: start.Add(3 * time.Minute), Method: "POST", Path: "/cart/add", StatusCode: 404, PayloadSize: 50},
+		}
+		for _, event := range events {
+			builder.Add(event)
+		}
+	})
+
+	JustBeforeEach(func() {
+		report = builder.Report()
+	})
+
+	It(`summarizes the totals of the whole file`, func() {
+		Expect(report.Start).To(Equal(start))
+		Expect(report.End).To(Equal(start.Add(3 * time.Minute)))
+		Expect(report.Totals.Count).To(Equal(int64(4)))
+		Expect(report.Totals.TotalPayloadSize).To(Equal(int64(1450)))
+		Expect(report.BytesPerSecond).To(BeNumerically("~", 1450.0/180.0))
+	})
+
+	It(`counts the requests over time including the quiet periods`, func() {
+		Expect(report.Timeline).To(HaveLen(4))
+		Expect(report.Timeline[0]).To(Equal(TrafficPeriod{Start: start, End: start.Add(time.Minute), Requests: 3, Bytes: 1400, Errors: 1, RequestsPerSecond: 3.0 / 60}))
+		Expect(report.Timeline[1].Requests).To(BeZero())
+		Expect(report.Timeline[3].Requests).To(Equal(int64(1)))
+	})
+
+	It(`ranks the peak periods, sections and clients`, func() {
+		Expect(report.PeakPeriods).To(HaveLen(2))
+		Expect(report.PeakPeriods[0].Start).To(Equal(start))
+		Expect(report.TopSections).To(HaveLen(2))
+		Expect(report.TopSections[0].Section).To(Equal("/api"))
+		Expect(report.TopSections[0].Count).To(Equal(int64(2)))
+		Expect(report.TopClients[0].Section).To(Equal("10.0.0.1"))
+	})
+
+	It(`breaks the requests down by status code`, func() {
+		Expect(report.StatusCodes).To(Equal([]StatusCodeCount{
+			{StatusCode: 200, Count: 2, Share: 0.5},
+			{StatusCode: 404, Count: 1, Share: 0.25},
+			{StatusCode: 500, Count: 1, Share: 0.25},
+		}))
+	})
+
+	It(`finds the error hotspots`, func() {
+		Expect(report.ErrorHotspots).To(HaveLen(2))
+		Expect(report.ErrorHotspots[0].Section).To(Equal("/api"))
+		Expect(report.ErrorHotspots[1].Section).To(Equal("/cart"))
+	})
+
+	It(`ranks the endpoints with request durations by their average`, func() {
+		Expect(report.SlowestEndpoints).To(Equal([]EndpointLatency{
+			{Endpoint: "GET /api/users", Count: 2, Average: 200, Max: 300},
+			{Endpoint: "GET /static/app.js", Count: 1, Average: 10, Max: 10},
+		}))
+	})
+
+	Describe(`ReportWriterByName`, func() {
+		write := func(format string) string {
+			writer, err := ReportWriterByName(format)
+			Expect(err).NotTo(HaveOccurred())
+			var output bytes.Buffer
+			Expect(writer(&output, report)).To(Succeed())
+			return output.String()
+		}
+
+		It(`writes the report as text`, func() {
+			Expect(write("text")).To(And(
+				ContainSubstring("Top sections"),
+				MatchRegexp(`GET /api/users\s+2\s+200.0ms\s+300.0ms`),
+			))
+		})
+
+		It(`writes the report as json`, func() {
+			var decoded Report
+			Expect(json.Unmarshal([]byte(write("json")), &decoded)).To(Succeed())
+			Expect(decoded.Totals).To(Equal(report.Totals))
+			Expect(decoded.StatusCodes).To(Equal(report.StatusCodes))
+		})
+
+		It(`writes the report as a self-contained html page`, func() {
+			Expect(write("html")).To(And(
+				HavePrefix("<!DOCTYPE html>"),
+				ContainSubstring("<style>"),
+				ContainSubstring("<td>/api</td>"),
+			))
+		})
+
+		It(`rejects unknown formats`, func() {
+			_, err := ReportWriterByName("pdf")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
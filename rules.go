@@ -0,0 +1,904 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type RuleError struct {
+	Offset int
+	Reason string
+}
+
+func (r *RuleError) Error() string {
+	return fmt.Sprintf("at character %d: %s", r.Offset+1, r.Reason)
+}
+
+type Rule struct {
+	Name    string
+	Source  string
+	For     time.Duration
+	GroupBy []string
+}
+
+func CompileRule(name, source string, pendingFor time.Duration, groupBy []string) (*Rule, error) {
+	if _, err := parseRule(source); err != nil {
+		return nil, fmt.Errorf("rule %s: %s", name, err.Error())
+	}
+	for _, label := range groupBy {
+		if _, err := ruleLabel(label); err != nil {
+			return nil, fmt.Errorf("rule %s: %s", name, err.Error())
+		}
+	}
+	return &Rule{Name: name, Source: source, For: pendingFor, GroupBy: groupBy}, nil
+}
+
+func (r *Rule) Key() EventKey {
+	if len(r.GroupBy) == 0 {
+		return nil
+	}
+	keys := make([]EventKey, len(r.GroupBy))
+	for i, name := range r.GroupBy {
+		keys[i], _ = ruleLabel(name)
+	}
+	if len(keys) == 1 {
+		return keys[0]
+	}
+	return JoinKeys(keys...)
+}
+
+func CompileSelector(source string) (Filter, error) {
+	tokens, err := lexRule(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &ruleParser{tokens: tokens}
+	selector, window, err := parser.parseSelector()
+	if err != nil {
+		return nil, err
+	}
+	if token := parser.peek(); token.kind != "end" {
+		return nil, &RuleError{Offset: token.offset, Reason: fmt.Sprintf("unexpected %q", token.text)}
+	}
+	if window > 0 {
+		return nil, &RuleError{Offset: 0, Reason: "a selector takes no window"}
+	}
+	return NewEventFilter(func(event Event) bool {
+		_, ok := selector.sample(event)
+		return ok
+	}), nil
+}
+
+type RuleAlert struct {
+	rule       *Rule
+	expression booleanNode
+	clock      Clock
+	label      string
+	lifecycle  *AlertLifecycle
+}
+
+func NewRuleAlert(rule *Rule, notification Notification, options ...AlertOption) *RuleAlert {
+	alertOptions := newAlertOptions(options)
+	if rule.For > 0 {
+		alertOptions.pendingFor = rule.For
+	}
+	expression, _ := parseRule(rule.Source)
+	var window time.Duration
+	for _, aggregate := range aggregatesOf(expression) {
+		if aggregateWindow := aggregate.window(); aggregateWindow > window {
+			window = aggregateWindow
+		}
+	}
+	return &RuleAlert{
+		rule:       rule,
+		expression: expression,
+		clock:      alertOptions.clock,
+		label:      alertOptions.label,
+		lifecycle:  newAlertLifecycle("rule:"+rule.Name, window, notification, alertOptions),
+	}
+}
+
+func (r *RuleAlert) Check(event Event) {
+	if observer, ok := r.clock.(EventTimeObserver); ok {
+		observer.Observe(event.Time)
+	}
+	r.expression.observe(event)
+	r.evaluate(event.Time, r.clock.Now())
+}
+
+func (r *RuleAlert) Tick(now time.Time) {
+	if _, ok := r.clock.(EventTimeObserver); ok {
+		if now = r.clock.Now(); now.IsZero() {
+			return
+		}
+	}
+	r.evaluate(now, now)
+}
+
+func (r *RuleAlert) evaluate(at, now time.Time) {
+	holds := r.expression.holds(now)
+	r.lifecycle.Evaluate(at, holds, !holds, func(transition *AlertTransition) {
+		transition.Subject = "rule " + labeled(r.rule.Name, r.label)
+		transition.Condition = "Rule " + labeled(r.rule.Name, r.label)
+		var values []string
+		for _, aggregate := range aggregatesOf(r.expression) {
+			values = append(values, fmt.Sprintf("%s = %s", aggregate, strconv.FormatFloat(aggregate.value(now), 'g', 4, 64)))
+		}
+		transition.Details = strings.Join(values, ", ")
+		if comparison := firstComparison(r.expression); comparison != nil {
+			transition.Value, transition.Threshold = comparison.left.value(now), comparison.right.value(now)
+		}
+	})
+}
+
+func (r *RuleAlert) Triggered() bool {
+	return r.lifecycle.Firing()
+}
+
+type ruleNode interface {
+	observe(Event)
+	String() string
+}
+
+type numericNode interface {
+	ruleNode
+	value(time.Time) float64
+}
+
+type booleanNode interface {
+	ruleNode
+	holds(time.Time) bool
+}
+
+type numberNode float64
+
+func (n numberNode) observe(Event)           {}
+func (n numberNode) value(time.Time) float64 { return float64(n) }
+func (n numberNode) String() string          { return strconv.FormatFloat(float64(n), 'g', -1, 64) }
+
+type arithmeticNode struct {
+	operator    string
+	left, right numericNode
+}
+
+func (a *arithmeticNode) observe(event Event) {
+	a.left.observe(event)
+	a.right.observe(event)
+}
+
+func (a *arithmeticNode) value(now time.Time) float64 {
+	left, right := a.left.value(now), a.right.value(now)
+	switch a.operator {
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "*":
+		return left * right
+	}
+	if right == 0 {
+		return 0
+	}
+	return left / right
+}
+
+func (a *arithmeticNode) children() []ruleNode {
+	return []ruleNode{a.left, a.right}
+}
+
+func (a *arithmeticNode) String() string {
+	return fmt.Sprintf("%s %s %s", a.left, a.operator, a.right)
+}
+
+type comparisonNode struct {
+	operator    string
+	left, right numericNode
+}
+
+func (c *comparisonNode) observe(event Event) {
+	c.left.observe(event)
+	c.right.observe(event)
+}
+
+func (c *comparisonNode) holds(now time.Time) bool {
+	return compareNumbers(c.left.value(now), c.operator, c.right.value(now))
+}
+
+func (c *comparisonNode) children() []ruleNode {
+	return []ruleNode{c.left, c.right}
+}
+
+func (c *comparisonNode) String() string {
+	return fmt.Sprintf("%s %s %s", c.left, c.operator, c.right)
+}
+
+func compareNumbers(left float64, operator string, right float64) bool {
+	switch operator {
+	case ">":
+		return left > right
+	case ">=":
+		return left >= right
+	case "<":
+		return left < right
+	case "<=":
+		return left <= right
+	case "!=":
+		return left != right
+	}
+	return left == right
+}
+
+type logicalNode struct {
+	operator    string
+	left, right booleanNode
+}
+
+func (l *logicalNode) observe(event Event) {
+	l.left.observe(event)
+	l.right.observe(event)
+}
+
+func (l *logicalNode) holds(now time.Time) bool {
+	if l.operator == "and" {
+		return l.left.holds(now) && l.right.holds(now)
+	}
+	return l.left.holds(now) || l.right.holds(now)
+}
+
+func (l *logicalNode) children() []ruleNode {
+	return []ruleNode{l.left, l.right}
+}
+
+func (l *logicalNode) String() string {
+	return fmt.Sprintf("%s %s %s", l.left, l.operator, l.right)
+}
+
+type notNode struct {
+	operand booleanNode
+}
+
+func (n *notNode) observe(event Event)      { n.operand.observe(event) }
+func (n *notNode) holds(now time.Time) bool { return !n.operand.holds(now) }
+func (n *notNode) children() []ruleNode     { return []ruleNode{n.operand} }
+func (n *notNode) String() string           { return "not " + n.operand.String() }
+
+type parenthesesNode struct {
+	node ruleNode
+}
+
+func (p *parenthesesNode) observe(event Event)  { p.node.observe(event) }
+func (p *parenthesesNode) children() []ruleNode { return []ruleNode{p.node} }
+func (p *parenthesesNode) String() string       { return "(" + p.node.String() + ")" }
+
+type numericParenthesesNode struct {
+	parenthesesNode
+}
+
+func (n *numericParenthesesNode) value(now time.Time) float64 {
+	return n.node.(numericNode).value(now)
+}
+
+type booleanParenthesesNode struct {
+	parenthesesNode
+}
+
+func (b *booleanParenthesesNode) holds(now time.Time) bool {
+	return b.node.(booleanNode).holds(now)
+}
+
+type aggregateNode interface {
+	numericNode
+	window() time.Duration
+}
+
+func aggregatesOf(node ruleNode) []aggregateNode {
+	if aggregate, ok := node.(aggregateNode); ok {
+		return []aggregateNode{aggregate}
+	}
+	var aggregates []aggregateNode
+	if parent, ok := node.(interface{ children() []ruleNode }); ok {
+		for _, child := range parent.children() {
+			aggregates = append(aggregates, aggregatesOf(child)...)
+		}
+	}
+	return aggregates
+}
+
+func firstComparison(node ruleNode) *comparisonNode {
+	if comparison, ok := node.(*comparisonNode); ok {
+		return comparison
+	}
+	if parent, ok := node.(interface{ children() []ruleNode }); ok {
+		for _, child := range parent.children() {
+			if comparison := firstComparison(child); comparison != nil {
+				return comparison
+			}
+		}
+	}
+	return nil
+}
+
+type ruleMetric func(Event) (float64, bool)
+
+var ruleMetrics = map[string]ruleMetric{
+	"hits":  func(Event) (float64, bool) { return 1, true },
+	"all":   func(Event) (float64, bool) { return 1, true },
+	"bytes": func(event Event) (float64, bool) { return float64(event.PayloadSize), true },
+	"latency": func(event Event) (float64, bool) {
+		return float64(event.Duration) / float64(time.Millisecond), event.Duration > 0
+	},
+}
+
+type ruleMatcher struct {
+	name     string
+	label    EventKey
+	operator string
+	value    string
+}
+
+func (r ruleMatcher) matches(event Event) bool {
+	actual := r.label(event)
+	switch r.operator {
+	case "=", "==":
+		return actual == r.value
+	case "!=":
+		return actual != r.value
+	}
+	left, err := strconv.ParseFloat(actual, 64)
+	if err != nil {
+		return false
+	}
+	right, _ := strconv.ParseFloat(r.value, 64)
+	return compareNumbers(left, r.operator, right)
+}
+
+func (r ruleMatcher) String() string {
+	if _, err := strconv.ParseFloat(r.value, 64); err == nil && r.operator != "=" && r.operator != "!=" {
+		return r.name + r.operator + r.value
+	}
+	return r.name + r.operator + strconv.Quote(r.value)
+}
+
+type ruleSelector struct {
+	metricName string
+	metric     ruleMetric
+	matchers   []ruleMatcher
+}
+
+func (r *ruleSelector) sample(event Event) (float64, bool) {
+	for _, matcher := range r.matchers {
+		if !matcher.matches(event) {
+			return 0, false
+		}
+	}
+	return r.metric(event)
+}
+
+func (r *ruleSelector) String() string {
+	if len(r.matchers) == 0 {
+		return r.metricName
+	}
+	matchers := make([]string, len(r.matchers))
+	for i, matcher := range r.matchers {
+		matchers[i] = matcher.String()
+	}
+	return r.metricName + "{" + strings.Join(matchers, ", ") + "}"
+}
+
+type countNode struct {
+	function string
+	selector *ruleSelector
+	duration time.Duration
+	counter  *WindowCounter
+}
+
+func (c *countNode) window() time.Duration { return c.duration }
+
+func (c *countNode) observe(event Event) {
+	value, ok := c.selector.sample(event)
+	if !ok {
+		return
+	}
+	if c.function == "sum" {
+		c.counter.Add(event.Time, uint64(value))
+	} else {
+		c.counter.Add(event.Time, 1)
+	}
+}
+
+func (c *countNode) value(now time.Time) float64 {
+	count := float64(c.counter.Count(now))
+	if c.function == "rate" {
+		return count / c.duration.Seconds()
+	}
+	return count
+}
+
+func (c *countNode) String() string {
+	return fmt.Sprintf("%s(%s[%s])", c.function, c.selector, shortDuration(c.duration))
+}
+
+type ratioNode struct {
+	numerator, denominator *ruleSelector
+	duration               time.Duration
+	matching, total        *WindowCounter
+}
+
+func (r *ratioNode) window() time.Duration { return r.duration }
+
+func (r *ratioNode) observe(event Event) {
+	if _, ok := r.denominator.sample(event); !ok {
+		return
+	}
+	r.total.Add(event.Time, 1)
+	if _, ok := r.numerator.sample(event); ok {
+		r.matching.Add(event.Time, 1)
+	}
+}
+
+func (r *ratioNode) value(now time.Time) float64 {
+	total := r.total.Count(now)
+	if total == 0 {
+		return 0
+	}
+	return float64(r.matching.Count(now)) / float64(total)
+}
+
+func (r *ratioNode) String() string {
+	return fmt.Sprintf("ratio(%s, %s)[%s]", r.numerator, r.denominator, shortDuration(r.duration))
+}
+
+type percentileNode struct {
+	selector *ruleSelector
+	quantile float64
+	duration time.Duration
+	sketch   *SlidingSketch
+}
+
+func (p *percentileNode) window() time.Duration { return p.duration }
+
+func (p *percentileNode) observe(event Event) {
+	if value, ok := p.selector.sample(event); ok {
+		p.sketch.Add(event.Time, value)
+	}
+}
+
+func (p *percentileNode) value(now time.Time) float64 {
+	return p.sketch.Window(now).Quantile(p.quantile)
+}
+
+func (p *percentileNode) String() string {
+	return fmt.Sprintf("percentile(%s[%s], %g)", p.selector, shortDuration(p.duration), p.quantile)
+}
+
+var ruleFields = map[string]EventKey{
+	"method":     func(event Event) string { return event.Method },
+	"status":     func(event Event) string { return strconv.Itoa(event.StatusCode) },
+	"client":     func(event Event) string { return event.Client },
+	"host":       func(event Event) string { return event.Host },
+	"path":       func(event Event) string { return event.Path },
+	"protocol":   func(event Event) string { return event.Protocol },
+	"user":       func(event Event) string { return event.User },
+	"referer":    func(event Event) string { return event.Referer },
+	"user_agent": func(event Event) string { return event.UserAgent },
+}
+
+func ruleLabel(name string) (EventKey, error) {
+	if field, ok := ruleFields[name]; ok {
+		return field, nil
+	}
+	key, err := EventKeyByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown label: %s", name)
+	}
+	return func(event Event) string {
+		return strings.TrimPrefix(key(event), name+":")
+	}, nil
+}
+
+type ruleToken struct {
+	kind   string
+	text   string
+	offset int
+}
+
+func lexRule(source string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(source) && source[end] != '"' {
+				if source[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(source) {
+				return nil, &RuleError{Offset: i, Reason: "unterminated string"}
+			}
+			text, err := strconv.Unquote(source[i : end+1])
+			if err != nil {
+				return nil, &RuleError{Offset: i, Reason: "invalid string"}
+			}
+			tokens = append(tokens, ruleToken{kind: "string", text: text, offset: i})
+			i = end + 1
+		case c == '[':
+			end := strings.IndexByte(source[i:], ']')
+			if end < 0 {
+				return nil, &RuleError{Offset: i, Reason: "unterminated window"}
+			}
+			tokens = append(tokens, ruleToken{kind: "window", text: strings.TrimSpace(source[i+1 : i+end]), offset: i})
+			i += end + 1
+		case isDigit(c) || (c == '.' && i+1 < len(source) && isDigit(source[i+1])):
+			end := i
+			for end < len(source) && (isDigit(source[end]) || source[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, ruleToken{kind: "number", text: source[i:end], offset: i})
+			i = end
+		case unicode.IsLetter(rune(c)) || c == '_':
+			end := i
+			for end < len(source) && isVariableCharacter(source[end]) {
+				end++
+			}
+			tokens = append(tokens, ruleToken{kind: "identifier", text: source[i:end], offset: i})
+			i = end
+		case strings.HasPrefix(source[i:], ">=") || strings.HasPrefix(source[i:], "<=") || strings.HasPrefix(source[i:], "==") || strings.HasPrefix(source[i:], "!="):
+			tokens = append(tokens, ruleToken{kind: "operator", text: source[i : i+2], offset: i})
+			i += 2
+		case strings.IndexByte("<>=+-*/(){},", c) >= 0:
+			tokens = append(tokens, ruleToken{kind: "operator", text: string(c), offset: i})
+			i++
+		default:
+			return nil, &RuleError{Offset: i, Reason: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return append(tokens, ruleToken{kind: "end", offset: len(source)}), nil
+}
+
+type ruleParser struct {
+	tokens   []ruleToken
+	position int
+}
+
+func parseRule(source string) (booleanNode, error) {
+	tokens, err := lexRule(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &ruleParser{tokens: tokens}
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := parser.peek(); token.kind != "end" {
+		return nil, &RuleError{Offset: token.offset, Reason: fmt.Sprintf("unexpected %q", token.text)}
+	}
+	expression, ok := node.(booleanNode)
+	if !ok {
+		return nil, &RuleError{Offset: 0, Reason: "the rule is a number instead of a condition; compare it with >, >=, <, <=, == or !="}
+	}
+	return expression, nil
+}
+
+func (r *ruleParser) peek() ruleToken {
+	return r.tokens[r.position]
+}
+
+func (r *ruleParser) next() ruleToken {
+	token := r.tokens[r.position]
+	if token.kind != "end" {
+		r.position++
+	}
+	return token
+}
+
+func (r *ruleParser) accept(text string) bool {
+	if token := r.peek(); (token.kind == "operator" || token.kind == "identifier") && token.text == text {
+		r.position++
+		return true
+	}
+	return false
+}
+
+func (r *ruleParser) expect(text string) error {
+	if !r.accept(text) {
+		token := r.peek()
+		return &RuleError{Offset: token.offset, Reason: fmt.Sprintf("expected %q", text)}
+	}
+	return nil
+}
+
+func (r *ruleParser) parseOr() (ruleNode, error) {
+	return r.parseLogical("or", r.parseAnd)
+}
+
+func (r *ruleParser) parseAnd() (ruleNode, error) {
+	return r.parseLogical("and", r.parseNot)
+}
+
+func (r *ruleParser) parseLogical(operator string, operand func() (ruleNode, error)) (ruleNode, error) {
+	offset := r.peek().offset
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for r.peek().kind == "identifier" && r.peek().text == operator {
+		next := r.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		leftCondition, leftOk := left.(booleanNode)
+		rightCondition, rightOk := right.(booleanNode)
+		if !leftOk {
+			return nil, &RuleError{Offset: offset, Reason: fmt.Sprintf("%s needs a condition on its left", operator)}
+		}
+		if !rightOk {
+			return nil, &RuleError{Offset: next.offset, Reason: fmt.Sprintf("%s needs a condition on its right", operator)}
+		}
+		left = &logicalNode{operator: operator, left: leftCondition, right: rightCondition}
+	}
+	return left, nil
+}
+
+func (r *ruleParser) parseNot() (ruleNode, error) {
+	if token := r.peek(); token.kind == "identifier" && token.text == "not" {
+		r.next()
+		operand, err := r.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		condition, ok := operand.(booleanNode)
+		if !ok {
+			return nil, &RuleError{Offset: token.offset, Reason: "not needs a condition"}
+		}
+		return &notNode{operand: condition}, nil
+	}
+	return r.parseComparison()
+}
+
+func (r *ruleParser) parseComparison() (ruleNode, error) {
+	left, err := r.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	token := r.peek()
+	if token.kind != "operator" || !isComparison(token.text) {
+		return left, nil
+	}
+	r.next()
+	right, err := r.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	leftNumber, leftOk := left.(numericNode)
+	rightNumber, rightOk := right.(numericNode)
+	if !leftOk || !rightOk {
+		return nil, &RuleError{Offset: token.offset, Reason: fmt.Sprintf("%s compares numbers, not conditions", token.text)}
+	}
+	return &comparisonNode{operator: token.text, left: leftNumber, right: rightNumber}, nil
+}
+
+func isComparison(operator string) bool {
+	switch operator {
+	case ">", ">=", "<", "<=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+func (r *ruleParser) parseSum() (ruleNode, error) {
+	return r.parseArithmetic("+-", r.parseProduct)
+}
+
+func (r *ruleParser) parseProduct() (ruleNode, error) {
+	return r.parseArithmetic("*/", r.parseOperand)
+}
+
+func (r *ruleParser) parseArithmetic(operators string, operand func() (ruleNode, error)) (ruleNode, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for token := r.peek(); token.kind == "operator" && len(token.text) == 1 && strings.Contains(operators, token.text); token = r.peek() {
+		r.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		leftNumber, leftOk := left.(numericNode)
+		rightNumber, rightOk := right.(numericNode)
+		if !leftOk || !rightOk {
+			return nil, &RuleError{Offset: token.offset, Reason: fmt.Sprintf("%s needs numbers on both sides", token.text)}
+		}
+		left = &arithmeticNode{operator: token.text, left: leftNumber, right: rightNumber}
+	}
+	return left, nil
+}
+
+func (r *ruleParser) parseOperand() (ruleNode, error) {
+	token := r.next()
+	switch {
+	case token.kind == "number":
+		number, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, &RuleError{Offset: token.offset, Reason: fmt.Sprintf("invalid number %s", token.text)}
+		}
+		return numberNode(number), nil
+	case token.kind == "operator" && token.text == "(":
+		node, err := r.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := r.expect(")"); err != nil {
+			return nil, err
+		}
+		if _, ok := node.(booleanNode); ok {
+			return &booleanParenthesesNode{parenthesesNode{node: node}}, nil
+		}
+		return &numericParenthesesNode{parenthesesNode{node: node}}, nil
+	case token.kind == "identifier":
+		return r.parseFunction(token)
+	}
+	if token.kind == "end" {
+		return nil, &RuleError{Offset: token.offset, Reason: "unexpected end of the rule"}
+	}
+	return nil, &RuleError{Offset: token.offset, Reason: fmt.Sprintf("unexpected %q", token.text)}
+}
+
+func (r *ruleParser) parseFunction(name ruleToken) (ruleNode, error) {
+	arguments := map[string]int{"count": 1, "rate": 1, "sum": 1, "ratio": 2, "percentile": 1}
+	selectors, ok := arguments[name.text]
+	if !ok {
+		return nil, &RuleError{Offset: name.offset, Reason: fmt.Sprintf("unknown function %s; expected count, rate, sum, ratio or percentile", name.text)}
+	}
+	if err := r.expect("("); err != nil {
+		return nil, err
+	}
+
+	var window time.Duration
+	var parsed []*ruleSelector
+	for i := 0; i < selectors; i++ {
+		if i > 0 {
+			if err := r.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		selector, selectorWindow, err := r.parseSelector()
+		if err != nil {
+			return nil, err
+		}
+		if selectorWindow > 0 {
+			window = selectorWindow
+		}
+		parsed = append(parsed, selector)
+	}
+
+	quantile := 0.0
+	if name.text == "percentile" {
+		if err := r.expect(","); err != nil {
+			return nil, err
+		}
+		token := r.next()
+		number, err := strconv.ParseFloat(token.text, 64)
+		if token.kind != "number" || err != nil || number <= 0 || number > 100 {
+			return nil, &RuleError{Offset: token.offset, Reason: "percentile needs a quantile between 0 and 1, or a percentage up to 100"}
+		}
+		if quantile = number; quantile > 1 {
+			quantile /= 100
+		}
+	}
+	if err := r.expect(")"); err != nil {
+		return nil, err
+	}
+	if token := r.peek(); token.kind == "window" {
+		r.next()
+		duration, err := parseRuleWindow(token)
+		if err != nil {
+			return nil, err
+		}
+		window = duration
+	}
+	if window <= 0 {
+		return nil, &RuleError{Offset: name.offset, Reason: fmt.Sprintf("%s needs a window such as [5m]", name.text)}
+	}
+
+	switch name.text {
+	case "ratio":
+		return &ratioNode{numerator: parsed[0], denominator: parsed[1], duration: window, matching: NewWindowCounter(window, DefaultCounterResolution), total: NewWindowCounter(window, DefaultCounterResolution)}, nil
+	case "percentile":
+		return &percentileNode{selector: parsed[0], quantile: quantile, duration: window, sketch: NewSlidingSketch(window, 12, DefaultSketchAccuracy, DefaultSketchMaxBuckets)}, nil
+	}
+	return &countNode{function: name.text, selector: parsed[0], duration: window, counter: NewWindowCounter(window, DefaultCounterResolution)}, nil
+}
+
+func parseRuleWindow(token ruleToken) (time.Duration, error) {
+	duration, err := time.ParseDuration(token.text)
+	if err != nil || duration <= 0 {
+		return 0, &RuleError{Offset: token.offset, Reason: fmt.Sprintf("invalid window [%s]", token.text)}
+	}
+	return duration, nil
+}
+
+func shortDuration(duration time.Duration) string {
+	text := duration.String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
+
+func (r *ruleParser) parseSelector() (*ruleSelector, time.Duration, error) {
+	token := r.next()
+	if token.kind != "identifier" {
+		return nil, 0, &RuleError{Offset: token.offset, Reason: "expected a metric such as hits, bytes or latency, or a condition such as status>=500"}
+	}
+
+	selector := &ruleSelector{metricName: "hits", metric: ruleMetrics["hits"]}
+	if metric, ok := ruleMetrics[token.text]; ok {
+		selector.metricName, selector.metric = token.text, metric
+		if r.accept("{") {
+			for !r.accept("}") {
+				if len(selector.matchers) > 0 {
+					if err := r.expect(","); err != nil {
+						return nil, 0, err
+					}
+				}
+				matcher, err := r.parseMatcher(r.next())
+				if err != nil {
+					return nil, 0, err
+				}
+				selector.matchers = append(selector.matchers, matcher)
+			}
+		}
+	} else {
+		if _, err := ruleLabel(token.text); err != nil {
+			return nil, 0, &RuleError{Offset: token.offset, Reason: fmt.Sprintf("unknown metric or label %s", token.text)}
+		}
+		matcher, err := r.parseMatcher(token)
+		if err != nil {
+			return nil, 0, err
+		}
+		selector.matchers = append(selector.matchers, matcher)
+	}
+
+	if token := r.peek(); token.kind == "window" {
+		r.next()
+		window, err := parseRuleWindow(token)
+		return selector, window, err
+	}
+	return selector, 0, nil
+}
+
+func (r *ruleParser) parseMatcher(name ruleToken) (ruleMatcher, error) {
+	if name.kind != "identifier" {
+		return ruleMatcher{}, &RuleError{Offset: name.offset, Reason: "expected a label such as section or status"}
+	}
+	label, err := ruleLabel(name.text)
+	if err != nil {
+		return ruleMatcher{}, &RuleError{Offset: name.offset, Reason: err.Error()}
+	}
+	operator := r.next()
+	if operator.kind != "operator" || (operator.text != "=" && !isComparison(operator.text)) {
+		return ruleMatcher{}, &RuleError{Offset: operator.offset, Reason: fmt.Sprintf("expected a comparison after %s", name.text)}
+	}
+	value := r.next()
+	if value.kind != "string" && value.kind != "number" {
+		return ruleMatcher{}, &RuleError{Offset: value.offset, Reason: fmt.Sprintf("expected a value to compare %s with", name.text)}
+	}
+	if value.kind == "string" && operator.text != "=" && operator.text != "==" && operator.text != "!=" {
+		return ruleMatcher{}, &RuleError{Offset: operator.offset, Reason: fmt.Sprintf("%s compares numbers, not strings", operator.text)}
+	}
+	return ruleMatcher{name: name.text, label: label, operator: operator.text, value: value.text}, nil
+}
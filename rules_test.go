@@ -0,0 +1,153 @@
+package main_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`CompileRule`, func() {
+	It(`compiles aggregates over windows combined with and, or and not`, func() {
+		_, err := CompileRule("api", `rate(hits{section="/api"}[2m]) > 50 and ratio(status>=500, all)[5m] > 0.05`, 0, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = CompileRule("mixed", `not (count(hits{method="POST", status!="200"}[1m]) <= 10) or sum(bytes[1m]) / 60 > 1000 or percentile(latency{host="a"}[5m], 99) > 500`, 0, nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It(`rejects invalid rules at the character where they go wrong`, func() {
+		for _, invalid := range []struct{ source, message string }{
+			{`rate(hits[1m])`, `at character 1: the rule is a number instead of a condition; compare it with >, >=, <, <=, == or !=`},
+			{`avg(hits[1m]) > 1`, `at character 1: unknown function avg; expected count, rate, sum, ratio or percentile`},
+			{`rate(hits) > 1`, `at character 1: rate needs a window such as [5m]`},
+			{`rate(hits[soon]) > 1`, `at character 10: invalid window [soon]`},
+			{`rate(hits{colour="red"}[1m]) > 1`, `at character 11: unknown label: colour`},
+			{`rate(hits{method>"GET"}[1m]) > 1`, `at character 17: > compares numbers, not strings`},
+			{`(rate(hits[1m]) > 1) + 1 > 2`, `at character 22: + needs numbers on both sides`},
+			{`rate(hits[1m]) > 1 and 2`, `at character 20: and needs a condition on its right`},
+			{`rate(hits[1m]) > 1 )`, `at character 20: unexpected ")"`},
+			{`rate(hits{section="/api}[1m]) > 1`, `at character 19: unterminated string`},
+			{`percentile(latency[1m], 120) > 1`, `at character 25: percentile needs a quantile between 0 and 1, or a percentage up to 100`},
+		} {
+			_, err := CompileRule("bad", invalid.source, 0, nil)
+			Expect(err).To(MatchError("rule bad: " + invalid.message))
+		}
+	})
+
+	It(`rejects unknown grouping labels`, func() {
+		_, err := CompileRule("bad", `rate(hits[1m]) > 1`, 0, []string{"colour"})
+		Expect(err).To(MatchError("rule bad: unknown label: colour"))
+	})
+})
+
+var _ = Describe(`RuleAlert`, func() {
+	var (
+		notification *transitionsMock
+		start        time.Time
+	)
+
+	BeforeEach(func() {
+		notification = new(transitionsMock)
+		start = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	newRuleAlert := func(source string, options ...AlertOption) *RuleAlert {
+		rule, err := CompileRule("api", source, 0, nil)
+		Expect(err).ToNot(HaveOccurred())
+		return NewRuleAlert(rule, notification, options...)
+	}
+
+	It(`fires when all the conditions hold and resolves when one stops holding`, func() {
+		alert := newRuleAlert(`rate(hits{section="/api"}[1m]) > 0.05 and ratio(status>=500, all)[1m] > 0.5`)
+		for i := 0; i < 4; i++ {
+			alert.Check(Event{Time: start, Path: "/api/users", StatusCode: 200})
+		}
+		Expect(alert.Triggered()).To(BeFalse())
+
+		for i := 0; i < 5; i++ {
+			alert.Check(Event{Time: start.Add(time.Second), Path: "/other", StatusCode: 503})
+		}
+		Expect(alert.Triggered()).To(BeTrue())
+		Expect(notification.transitions[0].Alert).To(Equal("rule:api"))
+		Expect(notification.transitions[0].Value).To(BeNumerically("~", 0.0667, 0.001))
+		Expect(notification.transitions[0].Threshold).To(Equal(0.05))
+		Expect(notification.transitions[0].Window).To(Equal(time.Minute))
+		Expect(notification.message).To(Equal("Rule api generated an alert - rate(hits{section=\"/api\"}[1m]) = 0.06667, ratio(hits{status>=500}, all)[1m] = 0.5556, triggered at " + start.Add(time.Second).String() + "\n"))
+
+		alert.Check(Event{Time: start.Add(61 * time.Second), Path: "/other", StatusCode: 503})
+		Expect(alert.Triggered()).To(BeFalse())
+		Expect(notification.message).To(HavePrefix("Rule api returned to normal - rate(hits{section=\"/api\"}[1m]) = 0, "))
+	})
+
+	It(`evaluates on ticks while the traffic is absent`, func() {
+		alert := newRuleAlert(`count(hits[5m]) < 2`, AlertClock(NewFakeClock(start)))
+		alert.Check(Event{Time: start})
+		alert.Check(Event{Time: start.Add(time.Second)})
+		Expect(alert.Triggered()).To(BeFalse())
+
+		alert.Tick(start.Add(4 * time.Minute))
+		Expect(alert.Triggered()).To(BeFalse())
+		alert.Tick(start.Add(6 * time.Minute))
+		Expect(alert.Triggered()).To(BeTrue())
+		Expect(notification.message).To(Equal("Rule api generated an alert - count(hits[5m]) = 0, triggered at " + start.Add(6*time.Minute).String() + "\n"))
+	})
+
+	It(`keeps the event times of a backfill when it ticks on the wall clock`, func() {
+		alert := newRuleAlert(`count(hits[5m]) < 2`)
+		alert.Tick(time.Now())
+		Expect(alert.Triggered()).To(BeFalse())
+
+		alert.Check(Event{Time: start})
+		alert.Check(Event{Time: start.Add(time.Second)})
+		alert.Tick(time.Now())
+		alert.Check(Event{Time: start.Add(2 * time.Second)})
+		Expect(alert.Triggered()).To(BeFalse())
+
+		alert = newRuleAlert(`count(hits[1m]) > 2`)
+		alert.Tick(time.Now())
+		for i := 0; i < 3; i++ {
+			alert.Check(Event{Time: start.Add(time.Duration(i) * time.Second)})
+		}
+		Expect(alert.Triggered()).To(BeTrue())
+	})
+
+	It(`sums the bytes and computes the percentiles of the latencies in milliseconds`, func() {
+		alert := newRuleAlert(`sum(bytes{method="GET"}[1m]) >= 3000 or percentile(latency[1m], 0.5) > 900`)
+		alert.Check(Event{Time: start, Method: "GET", PayloadSize: 2000, Duration: 100 * time.Millisecond})
+		alert.Check(Event{Time: start, Method: "POST", PayloadSize: 2000, Duration: 100 * time.Millisecond})
+		Expect(alert.Triggered()).To(BeFalse())
+		alert.Check(Event{Time: start, Method: "GET", PayloadSize: 1000, Duration: 100 * time.Millisecond})
+		Expect(alert.Triggered()).To(BeTrue())
+
+		alert = newRuleAlert(`percentile(latency[1m], 50) > 900`)
+		alert.Check(Event{Time: start, Duration: time.Second})
+		Expect(alert.Triggered()).To(BeTrue())
+	})
+
+	It(`waits for the "for" duration of the rule`, func() {
+		rule, err := CompileRule("busy", `count(hits[1m]) > 1`, 30*time.Second, nil)
+		Expect(err).ToNot(HaveOccurred())
+		alert := NewRuleAlert(rule, notification)
+		alert.Check(Event{Time: start})
+		alert.Check(Event{Time: start})
+		Expect(notification.transitions).To(HaveLen(1))
+		Expect(notification.transitions[0].To).To(Equal(AlertPending))
+		alert.Check(Event{Time: start.Add(30 * time.Second)})
+		Expect(alert.Triggered()).To(BeTrue())
+	})
+
+	It(`evaluates each group separately with the grouping labels of the rule`, func() {
+		rule, err := CompileRule("busy", `count(hits[1m]) > 1`, 0, []string{"section"})
+		Expect(err).ToNot(HaveOccurred())
+		alert := NewGroupedAlert(rule.Key(), func(group string) Alert {
+			return NewRuleAlert(rule, notification, AlertLabel(group))
+		})
+		alert.Check(Event{Time: start, Path: "/api"})
+		alert.Check(Event{Time: start, Path: "/users"})
+		Expect(notification.messages).To(BeEmpty())
+		alert.Check(Event{Time: start, Path: "/api"})
+		Expect(notification.messages).To(HaveLen(1))
+		Expect(notification.message).To(HavePrefix("Rule busy on /api generated an alert - count(hits[1m]) = 2"))
+	})
+})
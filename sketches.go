@@ -0,0 +1,202 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultSketchAccuracy   = 0.01
+	DefaultSketchMaxBuckets = 2048
+)
+
+type QuantileSketch struct {
+	accuracy   float64
+	gamma      float64
+	logGamma   float64
+	maxBuckets int
+
+	buckets map[int]uint64
+	zeros   uint64
+	count   uint64
+	min     float64
+	max     float64
+}
+
+func NewQuantileSketch(accuracy float64, maxBuckets int) *QuantileSketch {
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &QuantileSketch{
+		accuracy:   accuracy,
+		gamma:      gamma,
+		logGamma:   math.Log(gamma),
+		maxBuckets: maxBuckets,
+		buckets:    map[int]uint64{},
+	}
+}
+
+func (q *QuantileSketch) Add(value float64) {
+	if value < 0 || math.IsNaN(value) {
+		return
+	}
+	if q.count == 0 || value < q.min {
+		q.min = value
+	}
+	if q.count == 0 || value > q.max {
+		q.max = value
+	}
+	q.count++
+	if value == 0 {
+		q.zeros++
+		return
+	}
+	q.buckets[int(math.Ceil(math.Log(value)/q.logGamma))]++
+	q.collapse()
+}
+
+func (q *QuantileSketch) Merge(other *QuantileSketch) {
+	if other.count == 0 {
+		return
+	}
+	if q.count == 0 || other.min < q.min {
+		q.min = other.min
+	}
+	if q.count == 0 || other.max > q.max {
+		q.max = other.max
+	}
+	q.count += other.count
+	q.zeros += other.zeros
+	for index, count := range other.buckets {
+		q.buckets[index] += count
+	}
+	q.collapse()
+}
+
+func (q *QuantileSketch) Count() uint64 {
+	return q.count
+}
+
+func (q *QuantileSketch) Buckets() int {
+	return len(q.buckets)
+}
+
+func (q *QuantileSketch) Quantile(quantile float64) float64 {
+	if q.count == 0 {
+		return 0
+	}
+	if quantile <= 0 {
+		return q.min
+	}
+	if quantile >= 1 {
+		return q.max
+	}
+
+	rank := uint64(quantile * float64(q.count-1))
+	if rank < q.zeros {
+		return 0
+	}
+	seen := q.zeros
+	for _, index := range q.indexes() {
+		seen += q.buckets[index]
+		if seen > rank {
+			value := 2 * math.Pow(q.gamma, float64(index)) / (q.gamma + 1)
+			return math.Max(q.min, math.Min(value, q.max))
+		}
+	}
+	return q.max
+}
+
+func (q *QuantileSketch) Reset() {
+	q.buckets = map[int]uint64{}
+	q.zeros, q.count, q.min, q.max = 0, 0, 0, 0
+}
+
+func (q *QuantileSketch) indexes() []int {
+	indexes := make([]int, 0, len(q.buckets))
+	for index := range q.buckets {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+func (q *QuantileSketch) collapse() {
+	if q.maxBuckets <= 0 || len(q.buckets) <= q.maxBuckets {
+		return
+	}
+	indexes := q.indexes()
+	excess := len(indexes) - q.maxBuckets
+	lowest := indexes[excess]
+	for _, index := range indexes[:excess] {
+		q.buckets[lowest] += q.buckets[index]
+		delete(q.buckets, index)
+	}
+}
+
+type SlidingSketch struct {
+	window   time.Duration
+	slot     time.Duration
+	accuracy float64
+	buckets  int
+
+	slots  []sketchSlot
+	merged *QuantileSketch
+}
+
+type sketchSlot struct {
+	start  time.Time
+	sketch *QuantileSketch
+}
+
+func NewSlidingSketch(window time.Duration, slots int, accuracy float64, maxBuckets int) *SlidingSketch {
+	if slots < 1 {
+		slots = 1
+	}
+	slot := window / time.Duration(slots)
+	if slot <= 0 {
+		slot = window
+	}
+	return &SlidingSketch{window: window, slot: slot, accuracy: accuracy, buckets: maxBuckets}
+}
+
+func (s *SlidingSketch) Add(at time.Time, value float64) {
+	if s.merged != nil {
+		s.merged.Add(value)
+	}
+	start := at.Truncate(s.slot)
+	for i := len(s.slots) - 1; i >= 0; i-- {
+		if s.slots[i].start.Equal(start) {
+			s.slots[i].sketch.Add(value)
+			return
+		}
+		if s.slots[i].start.Before(start) {
+			break
+		}
+	}
+	sketch := NewQuantileSketch(s.accuracy, s.buckets)
+	sketch.Add(value)
+	s.slots = append(s.slots, sketchSlot{start: start, sketch: sketch})
+	sort.SliceStable(s.slots, func(i, j int) bool { return s.slots[i].start.Before(s.slots[j].start) })
+}
+
+func (s *SlidingSketch) Window(now time.Time) *QuantileSketch {
+	s.pruneUpTo(now)
+	if s.merged == nil {
+		s.merged = NewQuantileSketch(s.accuracy, s.buckets)
+		for _, slot := range s.slots {
+			s.merged.Merge(slot.sketch)
+		}
+	}
+	return s.merged
+}
+
+func (s *SlidingSketch) pruneUpTo(now time.Time) {
+	pruned := 0
+	for pruned < len(s.slots) && !s.slots[pruned].start.Add(s.slot).After(now.Add(-s.window)) {
+		pruned++
+	}
+	if pruned > 0 {
+		s.slots = s.slots[pruned:]
+		s.merged = nil
+	}
+}
@@ -0,0 +1,109 @@
+package main_test
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+func exactQuantile(sorted []float64, quantile float64) float64 {
+	return sorted[int(quantile*float64(len(sorted)-1))]
+}
+
+var _ = Describe(`QuantileSketch`, func() {
+	var (
+		sketch *QuantileSketch
+		values []float64
+	)
+
+	BeforeEach(func() {
+		random := rand.New(rand.NewSource(7))
+		sketch = NewQuantileSketch(DefaultSketchAccuracy, DefaultSketchMaxBuckets)
+		values = make([]float64, 100000)
+		for i := range values {
+			values[i] = math.Exp(3+0.8*random.NormFloat64()) * float64(time.Millisecond)
+			sketch.Add(values[i])
+		}
+		sort.Float64s(values)
+	})
+
+	It(`estimates the quantiles within its relative accuracy`, func() {
+		Expect(sketch.Count()).To(Equal(uint64(len(values))))
+		for _, quantile := range []float64{0.5, 0.9, 0.95, 0.99, 0.999} {
+			exact := exactQuantile(values, quantile)
+			Expect(math.Abs(sketch.Quantile(quantile)-exact)/exact).To(BeNumerically("<=", DefaultSketchAccuracy), QuantileName(quantile))
+		}
+		Expect(sketch.Quantile(0)).To(Equal(values[0]))
+		Expect(sketch.Quantile(1)).To(Equal(values[len(values)-1]))
+	})
+
+	It(`merges into the same estimates as a single sketch`, func() {
+		first := NewQuantileSketch(DefaultSketchAccuracy, DefaultSketchMaxBuckets)
+		second := NewQuantileSketch(DefaultSketchAccuracy, DefaultSketchMaxBuckets)
+		for i, value := range values {
+			if i%2 == 0 {
+				first.Add(value)
+			} else {
+				second.Add(value)
+			}
+		}
+		first.Merge(second)
+		Expect(first.Count()).To(Equal(sketch.Count()))
+		for _, quantile := range []float64{0.5, 0.95, 0.99} {
+			Expect(first.Quantile(quantile)).To(Equal(sketch.Quantile(quantile)))
+		}
+	})
+
+	It(`keeps its memory bounded by collapsing the lowest buckets`, func() {
+		bounded := NewQuantileSketch(DefaultSketchAccuracy, 64)
+		for _, value := range values {
+			bounded.Add(value)
+		}
+		Expect(bounded.Buckets()).To(BeNumerically("<=", 64))
+		exact := exactQuantile(values, 0.99)
+		Expect(math.Abs(bounded.Quantile(0.99)-exact) / exact).To(BeNumerically("<=", DefaultSketchAccuracy))
+	})
+
+	It(`counts zeros`, func() {
+		zeros := NewQuantileSketch(DefaultSketchAccuracy, DefaultSketchMaxBuckets)
+		zeros.Add(0)
+		zeros.Add(0)
+		zeros.Add(10)
+		zeros.Add(10)
+		Expect(zeros.Quantile(0.5)).To(BeZero())
+		Expect(zeros.Quantile(0.99)).To(BeNumerically("~", 10, 10*DefaultSketchAccuracy))
+	})
+})
+
+var _ = Describe(`SlidingSketch`, func() {
+	It(`forgets the values that leave the window`, func() {
+		start := time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+		sketch := NewSlidingSketch(time.Minute, 6, DefaultSketchAccuracy, DefaultSketchMaxBuckets)
+		sketch.Add(start, 1000)
+		sketch.Add(start.Add(30*time.Second), 10)
+		Expect(sketch.Window(start.Add(30 * time.Second)).Count()).To(Equal(uint64(2)))
+
+		window := sketch.Window(start.Add(90 * time.Second))
+		Expect(window.Count()).To(Equal(uint64(1)))
+		Expect(window.Quantile(0.99)).To(BeNumerically("~", 10, 10*DefaultSketchAccuracy))
+	})
+
+	It(`keeps the merged window until a slot leaves it`, func() {
+		start := time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+		sketch := NewSlidingSketch(time.Minute, 6, DefaultSketchAccuracy, DefaultSketchMaxBuckets)
+		sketch.Add(start, 1000)
+		window := sketch.Window(start)
+
+		sketch.Add(start.Add(30*time.Second), 10)
+		Expect(sketch.Window(start.Add(30*time.Second)) == window).To(BeTrue())
+		Expect(window.Count()).To(Equal(uint64(2)))
+
+		Expect(sketch.Window(start.Add(70*time.Second)) == window).To(BeFalse())
+		Expect(sketch.Window(start.Add(70 * time.Second)).Count()).To(Equal(uint64(1)))
+	})
+})
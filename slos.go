@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultSLOPeriod = 30 * 24 * time.Hour
+
+type BurnRate struct {
+	Long   time.Duration
+	Short  time.Duration
+	Factor float64
+}
+
+var DefaultBurnRates = []BurnRate{
+	{Long: time.Hour, Short: 5 * time.Minute, Factor: 14.4},
+	{Long: 6 * time.Hour, Short: 30 * time.Minute, Factor: 6},
+}
+
+type SLO struct {
+	Name      string
+	Total     Filter
+	Good      Filter
+	Target    float64
+	Period    time.Duration
+	BurnRates []BurnRate
+}
+
+type StatusReporter interface {
+	Status() string
+}
+
+type SLOAlert struct {
+	mutex      sync.Mutex
+	slo        *SLO
+	clock      Clock
+	label      string
+	clearRatio float64
+	resolution time.Duration
+
+	now        time.Time
+	windows    map[time.Duration]*sloWindow
+	lifecycles []*AlertLifecycle
+}
+
+type sloWindow struct {
+	total *WindowCounter
+	bad   *WindowCounter
+}
+
+func NewSLOAlert(slo *SLO, notification Notification, options ...AlertOption) *SLOAlert {
+	alertOptions := newAlertOptions(options)
+	alert := &SLOAlert{
+		slo:        slo,
+		clock:      alertOptions.clock,
+		label:      alertOptions.label,
+		clearRatio: alertOptions.clearRatio,
+		resolution: alertOptions.resolution,
+		windows:    map[time.Duration]*sloWindow{},
+	}
+	alert.window(slo.Period)
+	for _, burnRate := range slo.BurnRates {
+		alert.window(burnRate.Long)
+		alert.window(burnRate.Short)
+		alert.lifecycles = append(alert.lifecycles, newAlertLifecycle("slo:"+slo.Name, burnRate.Long, notification, alertOptions))
+	}
+	return alert
+}
+
+func (s *SLOAlert) window(duration time.Duration) *sloWindow {
+	if window, ok := s.windows[duration]; ok {
+		return window
+	}
+	resolution := duration / 720
+	if resolution < s.resolution {
+		resolution = s.resolution
+	}
+	window := &sloWindow{total: NewWindowCounter(duration, resolution), bad: NewWindowCounter(duration, resolution)}
+	s.windows[duration] = window
+	return window
+}
+
+func (s *SLOAlert) Check(event Event) {
+	if !s.slo.Total.Allow(event) {
+		return
+	}
+	if observer, ok := s.clock.(EventTimeObserver); ok {
+		observer.Observe(event.Time)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	good := s.slo.Good.Allow(event)
+	for _, window := range s.windows {
+		window.total.Add(event.Time, 1)
+		if !good {
+			window.bad.Add(event.Time, 1)
+		}
+	}
+	s.evaluate(event.Time, s.clock.Now())
+}
+
+func (s *SLOAlert) Tick(now time.Time) {
+	if _, ok := s.clock.(EventTimeObserver); ok {
+		if now = s.clock.Now(); now.IsZero() {
+			return
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.evaluate(now, now)
+}
+
+func (s *SLOAlert) Triggered() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, lifecycle := range s.lifecycles {
+		if lifecycle.Firing() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SLOAlert) Status() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	period := s.windows[s.slo.Period]
+	total, bad := period.total.Count(s.now), period.bad.Count(s.now)
+	subject := labeled("SLO "+s.slo.Name, s.label)
+	if total == 0 {
+		return fmt.Sprintf("%s: no events over %s, target %s\n", subject, shortDuration(s.slo.Period), formatPercent(s.slo.Target, 3))
+	}
+	return fmt.Sprintf("%s: %s good of %d events over %s, target %s, %s of the error budget remaining\n",
+		subject, formatPercent(1-float64(bad)/float64(total), 3), total, shortDuration(s.slo.Period), formatPercent(s.slo.Target, 3), formatPercent(s.remainingBudget(total, bad), 1))
+}
+
+func (s *SLOAlert) evaluate(at, now time.Time) {
+	if now.After(s.now) {
+		s.now = now
+	}
+	for i, burnRate := range s.slo.BurnRates {
+		long, short := s.burnRate(burnRate.Long), s.burnRate(burnRate.Short)
+		breached := long >= burnRate.Factor && short >= burnRate.Factor
+		cleared := long < burnRate.Factor*s.clearRatio || short < burnRate.Factor*s.clearRatio
+		s.lifecycles[i].Evaluate(at, breached, cleared, func(transition *AlertTransition) {
+			transition.Subject = fmt.Sprintf("%s error budget burn over %s/%s", labeled("SLO "+s.slo.Name, s.label), shortDuration(burnRate.Long), shortDuration(burnRate.Short))
+			transition.Condition = "Fast " + transition.Subject
+			period := s.windows[s.slo.Period]
+			total, bad := period.total.Count(s.now), period.bad.Count(s.now)
+			transition.Details = fmt.Sprintf("burn rate = %.1fx over %s and %.1fx over %s, %s of the error budget remaining", long, shortDuration(burnRate.Long), short, shortDuration(burnRate.Short), formatPercent(s.remainingBudget(total, bad), 1))
+			transition.Value, transition.Threshold = long, burnRate.Factor
+		})
+	}
+}
+
+func (s *SLOAlert) burnRate(duration time.Duration) float64 {
+	window := s.windows[duration]
+	total := window.total.Count(s.now)
+	if total == 0 || s.slo.Target >= 1 {
+		return 0
+	}
+	return float64(window.bad.Count(s.now)) / float64(total) / (1 - s.slo.Target)
+}
+
+func (s *SLOAlert) remainingBudget(total, bad uint64) float64 {
+	allowed := float64(total) * (1 - s.slo.Target)
+	if allowed == 0 {
+		if bad == 0 {
+			return 1
+		}
+		return 0
+	}
+	return 1 - float64(bad)/allowed
+}
+
+func formatPercent(fraction float64, decimals int) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.*f", decimals, fraction*100), "0"), ".") + "%"
+}
@@ -0,0 +1,106 @@
+package main_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`SLOAlert`, func() {
+	var (
+		notification *transitionsMock
+		start        time.Time
+		slo          *SLO
+	)
+
+	BeforeEach(func() {
+		notification = new(transitionsMock)
+		start = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+		total, err := CompileSelector(`hits{section="/cart.do"}`)
+		Expect(err).ToNot(HaveOccurred())
+		good, err := CompileSelector(`status<500`)
+		Expect(err).ToNot(HaveOccurred())
+		slo = &SLO{Name: "cart", Total: total, Good: good, Target: 0.99, Period: 24 * time.Hour, BurnRates: DefaultBurnRates}
+	})
+
+	requests := func(alert Alert, at time.Time, count int, status int) {
+		for i := 0; i < count; i++ {
+			alert.Check(Event{Time: at, Path: "/cart.do", StatusCode: status})
+		}
+	}
+
+	It(`fires when the long and the short windows both burn the error budget fast`, func() {
+		alert := NewSLOAlert(slo, notification)
+		for minute := 0; minute < 60; minute++ {
+			requests(alert, start.Add(time.Duration(minute)*time.Minute), 100, 200)
+		}
+		Expect(alert.Triggered()).To(BeFalse())
+
+		requests(alert, start.Add(time.Hour), 300, 503)
+		Expect(alert.Triggered()).To(BeFalse())
+
+		requests(alert, start.Add(time.Hour), 600, 503)
+		Expect(alert.Triggered()).To(BeTrue())
+		Expect(notification.transitions).To(HaveLen(1))
+		Expect(notification.transitions[0].Alert).To(Equal("slo:cart"))
+		Expect(notification.transitions[0].Subject).To(Equal("SLO cart error budget burn over 6h/30m"))
+		Expect(notification.transitions[0].Threshold).To(Equal(6.0))
+		Expect(notification.transitions[0].Window).To(Equal(6 * time.Hour))
+
+		requests(alert, start.Add(time.Hour), 300, 503)
+		Expect(notification.transitions).To(HaveLen(2))
+		Expect(notification.transitions[1].Subject).To(Equal("SLO cart error budget burn over 1h/5m"))
+		Expect(notification.transitions[1].Threshold).To(Equal(14.4))
+		Expect(notification.message).To(HavePrefix("Fast SLO cart error budget burn over 1h/5m generated an alert - burn rate = 14.4x over 1h and 66.9x over 5m, -1340.8% of the error budget remaining"))
+	})
+
+	It(`does not fire on a short spike that the long window does not confirm`, func() {
+		alert := NewSLOAlert(slo, notification)
+		for minute := 0; minute < 60; minute++ {
+			requests(alert, start.Add(time.Duration(minute)*time.Minute), 1000, 200)
+		}
+		requests(alert, start.Add(time.Hour), 500, 503)
+		Expect(alert.Triggered()).To(BeFalse())
+	})
+
+	It(`resolves once the short window recovers`, func() {
+		alert := NewSLOAlert(slo, notification, AlertClock(NewFakeClock(start)))
+		requests(alert, start, 100, 503)
+		Expect(alert.Triggered()).To(BeTrue())
+
+		alert.Tick(start.Add(6 * time.Minute))
+		Expect(alert.Triggered()).To(BeTrue())
+		requests(alert, start.Add(6*time.Minute), 10, 200)
+		Expect(notification.transitions).To(HaveLen(3))
+		Expect(notification.transitions[2].To).To(Equal(AlertResolved))
+		Expect(notification.transitions[2].Subject).To(Equal("SLO cart error budget burn over 1h/5m"))
+
+		alert.Tick(start.Add(31 * time.Minute))
+		Expect(alert.Triggered()).To(BeFalse())
+	})
+
+	It(`keeps the event times of a backfill when it ticks on the wall clock`, func() {
+		alert := NewSLOAlert(slo, notification)
+		alert.Tick(time.Now())
+		requests(alert, start, 1990, 200)
+		alert.Tick(time.Now())
+		requests(alert, start, 10, 503)
+		Expect(alert.Status()).To(Equal("SLO cart: 99.5% good of 2000 events over 24h, target 99%, 50% of the error budget remaining\n"))
+
+		alert.Tick(time.Now())
+		requests(alert, start.Add(time.Minute), 300, 503)
+		Expect(alert.Triggered()).To(BeTrue())
+	})
+
+	It(`reports the remaining error budget of the period`, func() {
+		alert := NewSLOAlert(slo, notification)
+		Expect(alert.Status()).To(Equal("SLO cart: no events over 24h, target 99%\n"))
+
+		requests(alert, start, 1990, 200)
+		requests(alert, start, 10, 503)
+		alert.Check(Event{Time: start, Path: "/other", StatusCode: 503})
+		Expect(alert.Status()).To(Equal("SLO cart: 99.5% good of 2000 events over 24h, target 99%, 50% of the error budget remaining\n"))
+	})
+})
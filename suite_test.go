@@ -3,16 +3,37 @@ package main_test
 import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
 
+	"sync"
 	"testing"
 )
 
 type notificationMock struct {
-	message string
+	sync.Mutex
+	message  string
+	messages []string
+	sent     []Message
 }
 
-func (s *notificationMock) Send(message string) {
-	s.message = message
+func (s *notificationMock) Send(message Message) {
+	s.Lock()
+	defer s.Unlock()
+	s.message = message.String()
+	s.messages = append(s.messages, s.message)
+	s.sent = append(s.sent, message)
+}
+
+func (s *notificationMock) Messages() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func (s *notificationMock) Last() string {
+	s.Lock()
+	defer s.Unlock()
+	return s.message
 }
 
 func TestAlerts(t *testing.T) {
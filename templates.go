@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var formatVerb = regexp.MustCompile(`%[a-z]`)
+
+var StatisticsTemplate = formatTemplate(SummaryStatisticsFormat, "Section", "AveragePayloadSize", "TotalPayloadSize", "Successes", "Redirects", "ClientFailures", "ServerFailures", "Count")
+
+const SummaryTemplate = "{{.Title}}\n" +
+	"{{with index .Values \"late_events\"}}Late Events: {{printf \"%.0f\" .}}\n{{end}}" +
+	"{{range $i, $statistics := .Statistics}}{{if $i}}\n{{end}}{{template \"statistics\" .}}{{end}}" +
+	"{{range .Statuses}}{{.}}{{end}}"
+
+const AlertTemplate = "{{if or .Reminder (ne (len .Transitions) 1)}}{{.Title}}\n{{end}}" +
+	"{{range .Transitions}}{{.Title}}{{with .Details}} - {{.}}{{end}}, " +
+	"{{if or (eq .To.String \"firing\") (eq .To.String \"resolved\")}}triggered at{{else}}since{{end}} {{.At}}\n" +
+	"{{end}}"
+
+const ErrorTemplate = "{{.Title}}\n"
+
+var DefaultTemplates = mustParseTemplates("default", nil)
+
+var templateFuncs = template.FuncMap{
+	"bytes":    humanizeBytes,
+	"duration": humanizeDuration,
+	"percent":  humanizePercent,
+}
+
+var defaultTemplateSources = map[MessageKind]string{
+	SummaryMessage: SummaryTemplate,
+	AlertMessage:   AlertTemplate,
+	ErrorMessage:   ErrorTemplate,
+}
+
+var templateSamples = map[MessageKind][]Message{
+	SummaryMessage: {
+		{Kind: SummaryMessage, Severity: SeverityInfo, Title: "Summary"},
+		{
+			Kind:       SummaryMessage,
+			Severity:   SeverityInfo,
+			Title:      "Summary",
+			Values:     map[string]float64{"late_events": 1},
+			Statistics: []TrafficStatistics{{Section: "/", Count: 1}, {Section: "/api", Count: 1}},
+			Statuses:   []string{"status\n"},
+		},
+	},
+	AlertMessage: {
+		NewAlertMessage(AlertTransition{Alert: "traffic", To: AlertFiring, Condition: "High traffic", Subject: "traffic", Details: "hits = 1", Window: time.Minute}),
+		{
+			Kind:     AlertMessage,
+			Severity: SeverityCritical,
+			Title:    "Reminder",
+			Labels:   map[string]string{"alert": "traffic"},
+			Values:   map[string]float64{"firing": 2, "resolved": 0},
+			Reminder: true,
+			Transitions: []AlertTransition{
+				{Alert: "traffic", To: AlertFiring, Condition: "High traffic", Subject: "traffic", Window: time.Minute},
+				{Alert: "latency", To: AlertPending, Condition: "High latency", Subject: "latency", Window: time.Minute},
+			},
+		},
+	},
+	ErrorMessage: {
+		{Kind: ErrorMessage, Severity: SeverityWarning, Title: "Error"},
+	},
+}
+
+type Templates map[MessageKind]*template.Template
+
+func ParseTemplates(name string, sources map[string]string) (Templates, error) {
+	for kind := range sources {
+		if _, ok := defaultTemplateSources[MessageKind(kind)]; !ok {
+			return nil, fmt.Errorf("%s templates: unknown message kind: %s", name, kind)
+		}
+	}
+	templates := Templates{}
+	for kind, source := range defaultTemplateSources {
+		if custom, ok := sources[string(kind)]; ok {
+			source = custom
+		}
+		parsed := template.New(name + " " + string(kind)).Funcs(templateFuncs)
+		if _, err := parsed.New("statistics").Parse(StatisticsTemplate); err != nil {
+			return nil, err
+		}
+		if _, err := parsed.Parse(source); err != nil {
+			return nil, err
+		}
+		for _, sample := range templateSamples[kind] {
+			if err := parsed.Execute(&bytes.Buffer{}, sample); err != nil {
+				return nil, err
+			}
+		}
+		templates[kind] = parsed
+	}
+	return templates, nil
+}
+
+func mustParseTemplates(name string, sources map[string]string) Templates {
+	templates, err := ParseTemplates(name, sources)
+	if err != nil {
+		panic(err.Error())
+	}
+	return templates
+}
+
+func formatTemplate(format string, fields ...string) string {
+	field := 0
+	return formatVerb.ReplaceAllStringFunc(format, func(verb string) string {
+		action := fmt.Sprintf("{{printf %q .%s}}", verb, fields[field])
+		field++
+		return action
+	})
+}
+
+func (t Templates) Render(message Message) (string, error) {
+	parsed, ok := t[message.Kind]
+	if !ok {
+		return "", fmt.Errorf("no template for %s messages", message.Kind)
+	}
+	var text bytes.Buffer
+	if err := parsed.Execute(&text, message); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
+
+func humanizeBytes(value interface{}) (string, error) {
+	size, err := templateNumber(value)
+	if err != nil {
+		return "", err
+	}
+	units := []string{"KiB", "MiB", "GiB", "TiB", "PiB"}
+	if math.Abs(size) < 1024 {
+		return fmt.Sprintf("%.0f B", size), nil
+	}
+	unit := -1
+	for math.Abs(size) >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", size), ".0") + " " + units[unit], nil
+}
+
+func humanizeDuration(value interface{}) (string, error) {
+	if duration, ok := value.(time.Duration); ok {
+		return shortDuration(duration), nil
+	}
+	seconds, err := templateNumber(value)
+	if err != nil {
+		return "", err
+	}
+	return shortDuration(time.Duration(seconds * float64(time.Second))), nil
+}
+
+func humanizePercent(value interface{}, decimals ...int) (string, error) {
+	fraction, err := templateNumber(value)
+	if err != nil {
+		return "", err
+	}
+	if len(decimals) == 0 {
+		decimals = []int{1}
+	}
+	return formatPercent(fraction, decimals[0]), nil
+}
+
+func templateNumber(value interface{}) (float64, error) {
+	switch number := value.(type) {
+	case float64:
+		return number, nil
+	case float32:
+		return float64(number), nil
+	case int:
+		return float64(number), nil
+	case int64:
+		return float64(number), nil
+	case uint64:
+		return float64(number), nil
+	case time.Duration:
+		return number.Seconds(), nil
+	}
+	return 0, fmt.Errorf("not a number: %v", value)
+}
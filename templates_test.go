@@ -0,0 +1,96 @@
+package main_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+var _ = Describe(`Templates`, func() {
+	var (
+		start      time.Time
+		transition AlertTransition
+	)
+
+	BeforeEach(func() {
+		start = time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+		transition = AlertTransition{
+			Alert:     "traffic",
+			To:        AlertFiring,
+			At:        start,
+			Condition: "High traffic",
+			Subject:   "traffic",
+			Details:   "hits = 12",
+			Value:     12,
+			Threshold: 10,
+			Window:    90 * time.Second,
+		}
+	})
+
+	Describe(`DefaultTemplates`, func() {
+		It(`render the alerts as their transitions`, func() {
+			text, err := DefaultTemplates.Render(NewAlertMessage(transition))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(text).To(Equal(transition.String()))
+
+			transition.To = AlertPending
+			text, err = DefaultTemplates.Render(NewAlertMessage(transition))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(text).To(Equal("High traffic is pending - hits = 12, since " + start.String() + "\n"))
+		})
+
+		It(`render the summaries as their statistics`, func() {
+			statistics := TrafficStatistics{Section: "/api", AveragePayloadSize: 10, TotalPayloadSize: 20, Successes: 2, Count: 2}
+			text, err := DefaultTemplates.Render(Message{Kind: SummaryMessage, Title: "Summary", Statistics: []TrafficStatistics{statistics}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(text).To(Equal("Summary\n\nSection: /api\nAverage Payload: 10.000000\nTotal Payload: 20\nSuccesses: 2\nRedirects: 0\nClient Failures: 0\nServer Failures: 0\nCount: 2\n\n"))
+			Expect(statistics.String()).To(Equal(strings.TrimPrefix(text, "Summary\n")))
+		})
+	})
+
+	Describe(`ParseTemplates`, func() {
+		It(`replaces the templates of the given message kinds`, func() {
+			templates, err := ParseTemplates("chat", map[string]string{"alert": `{{range .Transitions}}[{{.To}}] {{.Title}}{{end}}`})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(templates.Render(NewAlertMessage(transition))).To(Equal("[firing] High traffic generated an alert"))
+			Expect(templates.Render(NewErrorMessage("failure"))).To(Equal("failure\n"))
+		})
+
+		It(`humanizes bytes, durations and percentages`, func() {
+			templates, err := ParseTemplates("email", map[string]string{
+				"summary": `{{range .Statistics}}{{.Section}} {{bytes .TotalPayloadSize}}{{end}}`,
+				"alert":   `{{range .Transitions}}{{duration .Window}} {{percent .Value}} {{percent .Threshold 0}}{{end}}`,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, test := range []struct {
+				size int64
+				text string
+			}{
+				{512, "/api 512 B"},
+				{1536, "/api 1.5 KiB"},
+				{3 * 1024 * 1024, "/api 3 MiB"},
+			} {
+				Expect(templates.Render(Message{Kind: SummaryMessage, Statistics: []TrafficStatistics{{Section: "/api", TotalPayloadSize: test.size}}})).To(Equal(test.text))
+			}
+
+			transition.Value, transition.Threshold = 0.1234, 0.05
+			Expect(templates.Render(NewAlertMessage(transition))).To(Equal("1m30s 12.3% 5%"))
+		})
+
+		It(`lets the summary templates render the default statistics of a section`, func() {
+			templates, err := ParseTemplates("chat", map[string]string{"summary": `{{.Title}}:{{range .Statistics}}{{if eq .Section "Total Traffic"}}{{template "statistics" .}}{{end}}{{end}}`})
+			Expect(err).ToNot(HaveOccurred())
+			statistics := []TrafficStatistics{{Section: "/api", Count: 1}, {Section: "Total Traffic", Count: 2}}
+			Expect(templates.Render(Message{Kind: SummaryMessage, Title: "Summary", Statistics: statistics})).To(Equal("Summary:" + statistics[1].String()))
+		})
+
+		It(`rejects a helper applied to a value that is not a number`, func() {
+			_, err := ParseTemplates("chat", map[string]string{"error": `{{bytes .Title}}`})
+			Expect(err).To(MatchError(ContainSubstring("not a number: Error")))
+		})
+	})
+})
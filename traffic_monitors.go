@@ -2,11 +2,18 @@ package main
 
 import (
 	"fmt"
-	"net/url"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	SummaryWindowFormat        = "Summary from %s to %s\n"
+	UpdatedSummaryWindowFormat = "Updated summary from %s to %s\n"
+	LateEventsFormat           = "Late Events: %d\n"
+)
+
 const SummaryStatisticsFormat = `
 Section: %s
 Average Payload: %f
@@ -25,14 +32,14 @@ type TrafficMonitor interface {
 }
 
 type TrafficStatistics struct {
-	Section            string
-	AveragePayloadSize float64
-	TotalPayloadSize   int64
-	Successes          int
-	Redirects          int
-	ClientFailures     int
-	ServerFailures     int
-	Count              int64
+	Section            string  `json:"section"`
+	AveragePayloadSize float64 `json:"average_payload_size"`
+	TotalPayloadSize   int64   `json:"total_payload_size"`
+	Successes          int     `json:"successes"`
+	Redirects          int     `json:"redirects"`
+	ClientFailures     int     `json:"client_failures"`
+	ServerFailures     int     `json:"server_failures"`
+	Count              int64   `json:"count"`
 }
 
 func (t *TrafficStatistics) String() string {
@@ -49,93 +56,311 @@ func (t *TrafficStatistics) String() string {
 	)
 }
 
+type LatePolicy int
+
+const (
+	CountLateEvents LatePolicy = iota
+	UpdateLateWindows
+)
+
+var latePolicies = map[string]LatePolicy{
+	"count":  CountLateEvents,
+	"update": UpdateLateWindows,
+}
+
+func ParseLatePolicy(name string) (LatePolicy, error) {
+	policy, ok := latePolicies[name]
+	if !ok {
+		return CountLateEvents, fmt.Errorf("unknown late event policy: %s", name)
+	}
+	return policy, nil
+}
+
+func (l LatePolicy) String() string {
+	for name, policy := range latePolicies {
+		if policy == l {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+type summaryWindow struct {
+	start      time.Time
+	end        time.Time
+	statistics map[string]*TrafficStatistics
+	updated    bool
+}
+
+type windowsByStart []*summaryWindow
+
+func (w windowsByStart) Len() int           { return len(w) }
+func (w windowsByStart) Less(i, j int) bool { return w[i].start.Before(w[j].start) }
+func (w windowsByStart) Swap(i, j int)      { w[i], w[j] = w[j], w[i] }
+
 type SummaryStatsTrafficMonitor struct {
 	duration     time.Duration
 	notification Notification
 
-	ticker *time.Ticker
-	events chan Event
+	clock       Clock
+	ticker      Ticker
+	events      *EventQueue
+	queueConfig QueueConfig
+	stopped     chan struct{}
 
-	statistics map[string]*TrafficStatistics
+	keys       []EventKey
+	lateness   time.Duration
+	latePolicy LatePolicy
+	statuses   []StatusReporter
+
+	windows      map[time.Time]*summaryWindow
+	emitted      map[time.Time]*summaryWindow
+	flushed      map[time.Time]*summaryWindow
+	maxEventTime time.Time
+	seenEvents   bool
+	recorded     bool
+	lateEvents   uint64
+	pendingLate  int
+}
+
+type MonitorOption func(*SummaryStatsTrafficMonitor)
+
+func GroupBy(keys ...EventKey) MonitorOption {
+	return func(s *SummaryStatsTrafficMonitor) {
+		s.keys = keys
+	}
+}
+
+func MonitorQueue(config QueueConfig) MonitorOption {
+	return func(s *SummaryStatsTrafficMonitor) {
+		s.queueConfig = config
+	}
+}
+
+func MonitorClock(clock Clock) MonitorOption {
+	return func(s *SummaryStatsTrafficMonitor) {
+		s.clock = clock
+	}
+}
+
+func AllowedLateness(lateness time.Duration) MonitorOption {
+	return func(s *SummaryStatsTrafficMonitor) {
+		s.lateness = lateness
+	}
+}
+
+func LateEvents(policy LatePolicy) MonitorOption {
+	return func(s *SummaryStatsTrafficMonitor) {
+		s.latePolicy = policy
+	}
+}
+
+func ReportStatus(reporters ...StatusReporter) MonitorOption {
+	return func(s *SummaryStatsTrafficMonitor) {
+		s.statuses = reporters
+	}
 }
 
-func NewSummaryStatsTrafficMonitor(duration time.Duration, notification Notification) *SummaryStatsTrafficMonitor {
+func NewSummaryStatsTrafficMonitor(duration time.Duration, notification Notification, options ...MonitorOption) *SummaryStatsTrafficMonitor {
 	monitor := &SummaryStatsTrafficMonitor{
 		duration:     duration,
 		notification: notification,
-		events:       make(chan Event),
-		statistics:   map[string]*TrafficStatistics{},
+		clock:        WallClock,
+		stopped:      make(chan struct{}),
+		keys:         []EventKey{SectionKey},
+		windows:      map[time.Time]*summaryWindow{},
+		emitted:      map[time.Time]*summaryWindow{},
+		flushed:      map[time.Time]*summaryWindow{},
 	}
-	go monitor.consumeEvents()
-	go monitor.publishStatistics()
+	for _, option := range options {
+		option(monitor)
+	}
+	monitor.events = NewEventQueue("monitor", monitor.queueConfig)
+	monitor.ticker = monitor.clock.NewTicker(duration)
+	go monitor.run()
 	return monitor
 }
 
 func (s *SummaryStatsTrafficMonitor) Monitor(event Event) {
-	s.events <- event
+	s.events.Push(event)
 }
 
 func (s *SummaryStatsTrafficMonitor) Stop() {
 	s.ticker.Stop()
-	close(s.events)
+	s.events.Close()
+	<-s.stopped
+}
+
+func (s *SummaryStatsTrafficMonitor) LateEvents() uint64 {
+	return atomic.LoadUint64(&s.lateEvents)
+}
+
+func (s *SummaryStatsTrafficMonitor) QueueStats() []QueueStats {
+	return []QueueStats{s.events.Stats()}
+}
+
+func (s *SummaryStatsTrafficMonitor) run() {
+	defer close(s.stopped)
+	for {
+		select {
+		case event, ok := <-s.events.Events():
+			if !ok {
+				s.publishWindows(true)
+				return
+			}
+			s.record(event)
+		case <-s.ticker.C():
+			s.publishWindows(!s.recorded)
+			s.recorded = false
+		}
+	}
+}
+
+func (s *SummaryStatsTrafficMonitor) watermark() time.Time {
+	return s.maxEventTime.Add(-s.lateness)
+}
+
+func (s *SummaryStatsTrafficMonitor) record(event Event) {
+	s.recorded = true
+	start := event.Time.Truncate(s.duration)
+	if s.seenEvents && !start.Add(s.duration).After(s.watermark()) {
+		s.recordLate(start, event)
+		return
+	}
+
+	window, ok := s.windows[start]
+	if flushed, reopened := s.flushed[start]; !ok && reopened {
+		window, ok = flushed, true
+		window.updated = true
+		s.windows[start] = window
+		delete(s.flushed, start)
+		delete(s.emitted, start)
+	}
+	if !ok {
+		window = &summaryWindow{start: start, end: start.Add(s.duration), statistics: map[string]*TrafficStatistics{}}
+		s.windows[start] = window
+	}
+	s.recordStatistics(window.statistics, event)
+
+	if !s.seenEvents || event.Time.After(s.maxEventTime) {
+		s.seenEvents = true
+		s.maxEventTime = event.Time
+		s.publishWindows(false)
+	}
 }
 
-func (s *SummaryStatsTrafficMonitor) summary() string {
-	statistics := make([]string, len(s.statistics))
-	i := 0
-	for _, statistic := range s.statistics {
-		statistics[i] = statistic.String()
-		i++
+func (s *SummaryStatsTrafficMonitor) recordLate(start time.Time, event Event) {
+	if window, ok := s.emitted[start]; ok && s.latePolicy == UpdateLateWindows {
+		s.recordStatistics(window.statistics, event)
+		window.updated = true
+		return
 	}
-	return strings.Join(statistics, "\n")
+	atomic.AddUint64(&s.lateEvents, 1)
+	s.pendingLate++
 }
 
-func (s *SummaryStatsTrafficMonitor) publishStatistics() {
-	s.ticker = time.NewTicker(s.duration)
-	for _ = range s.ticker.C {
-		if s.summary() != "" {
-			s.notification.Send(s.summary())
+func (s *SummaryStatsTrafficMonitor) recordStatistics(statistics map[string]*TrafficStatistics, event Event) {
+	for _, key := range s.keys {
+		if group := key(event); group != "" {
+			updateStatistics(statistics, group, event)
 		}
-		s.statistics = map[string]*TrafficStatistics{}
 	}
+	updateStatistics(statistics, "Total Traffic", event)
 }
 
-func (s *SummaryStatsTrafficMonitor) consumeEvents() {
-	for event := range s.events {
-		eventURL, err := url.Parse(event.Path)
-		if err != nil {
-			continue
+func (s *SummaryStatsTrafficMonitor) publishWindows(all bool) {
+	watermark := s.watermark()
+	var windows []*summaryWindow
+	for _, window := range s.emitted {
+		if window.updated {
+			windows = append(windows, window)
+		}
+	}
+	for start, window := range s.windows {
+		if all || !window.end.After(watermark) {
+			windows = append(windows, window)
+			delete(s.windows, start)
+		}
+	}
+	sort.Sort(windowsByStart(windows))
+
+	for _, window := range windows {
+		format := SummaryWindowFormat
+		if window.updated {
+			format = UpdatedSummaryWindowFormat
+			window.updated = false
+		}
+		message := Message{
+			Kind:       SummaryMessage,
+			Severity:   SeverityInfo,
+			Title:      strings.TrimSuffix(fmt.Sprintf(format, window.start.String(), window.end.String()), "\n"),
+			Values:     map[string]float64{"late_events": float64(s.pendingLate)},
+			At:         window.end,
+			Start:      window.start,
+			End:        window.end,
+			Statistics: statistics(window.statistics),
+			Statuses:   s.status(),
+		}
+		s.pendingLate = 0
+		s.notification.Send(message)
+		if window.end.After(watermark) {
+			s.flushed[window.start] = window
+		}
+		if s.latePolicy == UpdateLateWindows {
+			s.emitted[window.start] = window
+		}
+	}
+
+	for start, window := range s.flushed {
+		if !window.end.After(watermark) {
+			delete(s.flushed, start)
 		}
-		pathSections := strings.Split(eventURL.Path, "/")
-		if len(pathSections) >= 2 {
-			section := strings.Join(pathSections[0:2], "/")
-			s.updateStatistics(section, event)
+	}
+
+	for start, window := range s.emitted {
+		if !window.end.Add(s.duration).After(watermark) {
+			delete(s.emitted, start)
 		}
-		s.updateTotalStatistics(event)
 	}
 }
 
-func (s *SummaryStatsTrafficMonitor) updateStatistics(section string, event Event) {
-	if _, ok := s.statistics[section]; !ok {
-		s.statistics[section] = &TrafficStatistics{Section: section}
+func (s *SummaryStatsTrafficMonitor) status() []string {
+	statuses := make([]string, len(s.statuses))
+	for i, reporter := range s.statuses {
+		statuses[i] = reporter.Status()
+	}
+	return statuses
+}
+
+func statistics(sections map[string]*TrafficStatistics) []TrafficStatistics {
+	statistics := make([]TrafficStatistics, 0, len(sections))
+	for _, statistic := range sections {
+		statistics = append(statistics, *statistic)
+	}
+	sort.Slice(statistics, func(i, j int) bool {
+		return statistics[i].Section < statistics[j].Section
+	})
+	return statistics
+}
+
+func updateStatistics(statistics map[string]*TrafficStatistics, section string, event Event) {
+	if _, ok := statistics[section]; !ok {
+		statistics[section] = &TrafficStatistics{Section: section}
 	}
 
-	sectionStatistics := s.statistics[section]
+	sectionStatistics := statistics[section]
 	sectionStatistics.AveragePayloadSize = (float64(sectionStatistics.Count)*sectionStatistics.AveragePayloadSize + float64(event.PayloadSize)) / float64(sectionStatistics.Count+1)
 	sectionStatistics.TotalPayloadSize += int64(event.PayloadSize)
-	if event.StatusCode >= 200 && event.StatusCode < 300 {
+	switch StatusClass(event.StatusCode) {
+	case "2xx":
 		sectionStatistics.Successes += 1
-	} else if event.StatusCode >= 300 && event.StatusCode < 400 {
+	case "3xx":
 		sectionStatistics.Redirects += 1
-	} else if event.StatusCode >= 400 && event.StatusCode < 500 {
+	case "4xx":
 		sectionStatistics.ClientFailures += 1
-	} else if event.StatusCode >= 500 {
+	case "5xx":
 		sectionStatistics.ServerFailures += 1
 	}
 
 	sectionStatistics.Count += 1
 }
-
-func (s *SummaryStatsTrafficMonitor) updateTotalStatistics(event Event) {
-	s.updateStatistics("Total Traffic", event)
-}
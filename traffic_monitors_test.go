@@ -14,14 +14,18 @@ var _ = Describe(`SummaryStatsTrafficMonitor`, func() {
 		var (
 			trafficMonitor TrafficMonitor
 			notification   *notificationMock
+			clock          *FakeClock
+			start          time.Time
 
 			events []Event
 		)
 
 		BeforeEach(func() {
 			notification = new(notificationMock)
-			trafficMonitor = NewSummaryStatsTrafficMonitor(1*time.Second, notification)
-			currentTime := time.Now()
+			start = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+			clock = NewFakeClock(start)
+			trafficMonitor = NewSummaryStatsTrafficMonitor(1*time.Second, notification, MonitorClock(clock))
+			currentTime := start
 			events = []Event{
 				{
 					Path:        `/section1/page1`,
@@ -32,19 +36,19 @@ var _ = Describe(`SummaryStatsTrafficMonitor`, func() {
 				{
 					Path:        `/section1/page2`,
 					PayloadSize: 10,
-					Time:        currentTime.Add(1 * time.Second),
+					Time:        currentTime.Add(100 * time.Millisecond),
 					StatusCode:  301,
 				},
 				{
 					Path:        `/section1/page1`,
 					PayloadSize: 10,
-					Time:        currentTime.Add(1 * time.Second),
+					Time:        currentTime.Add(200 * time.Millisecond),
 					StatusCode:  404,
 				},
 				{
 					Path:        `/section2/page1`,
 					PayloadSize: 30,
-					Time:        currentTime.Add(2 * time.Second),
+					Time:        currentTime.Add(500 * time.Millisecond),
 					StatusCode:  201,
 				},
 			}
@@ -54,6 +58,14 @@ var _ = Describe(`SummaryStatsTrafficMonitor`, func() {
 			for _, event := range events {
 				trafficMonitor.Monitor(event)
 			}
+			trafficMonitor.Stop()
+		})
+
+		It(`sends the summary of the window of the event times`, func() {
+			Expect(notification.messages).To(HaveLen(1))
+			Expect(notification.message).To(HavePrefix(
+				fmt.Sprintf(SummaryWindowFormat, start.String(), start.Add(1*time.Second).String()),
+			))
 		})
 
 		It(`calculates the summary statistics`, func() {
@@ -81,7 +93,7 @@ var _ = Describe(`SummaryStatsTrafficMonitor`, func() {
 			totalServerFailures := 0
 			totalRedirects := 1
 
-			Eventually(func() string { return notification.message }, 2*time.Second, 500*time.Millisecond).Should(And(
+			Expect(notification.message).To(And(
 				ContainSubstring(fmt.Sprintf(
 					SummaryStatisticsFormat, "/section1", section1AvgPayloadSize, section1TotalPayloadSize, section1Successes, section1Redirects, section1ClientFailures, section1ServerFailures, 3,
 				)),
@@ -95,3 +107,193 @@ var _ = Describe(`SummaryStatsTrafficMonitor`, func() {
 		})
 	})
 })
+
+type statusMock string
+
+func (s statusMock) Status() string {
+	return string(s)
+}
+
+var _ = Describe(`SummaryStatsTrafficMonitor windows`, func() {
+	var (
+		trafficMonitor *SummaryStatsTrafficMonitor
+		notification   *notificationMock
+		clock          *FakeClock
+		options        []MonitorOption
+		start          time.Time
+	)
+
+	at := func(offset time.Duration) Event {
+		return Event{Path: `/section1/page1`, PayloadSize: 10, StatusCode: 200, Time: start.Add(offset)}
+	}
+
+	count := func(count int) string {
+		return fmt.Sprintf(SummaryStatisticsFormat, "Total Traffic", 10.0, 10*count, count, 0, 0, 0, count)
+	}
+
+	BeforeEach(func() {
+		notification = new(notificationMock)
+		start = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+		clock = NewFakeClock(start)
+		options = []MonitorOption{GroupBy(), MonitorClock(clock)}
+	})
+
+	JustBeforeEach(func() {
+		trafficMonitor = NewSummaryStatsTrafficMonitor(10*time.Second, notification, options...)
+	})
+
+	AfterEach(func() {
+		trafficMonitor.Stop()
+	})
+
+	It(`sends a window once an event passes its end`, func() {
+		trafficMonitor.Monitor(at(1 * time.Second))
+		trafficMonitor.Monitor(at(9 * time.Second))
+		trafficMonitor.Monitor(at(10 * time.Second))
+		Eventually(notification.Messages).Should(HaveLen(1))
+		Expect(notification.Last()).To(Equal(fmt.Sprintf(SummaryWindowFormat, start.String(), start.Add(10*time.Second).String()) + count(2)))
+
+		trafficMonitor.Stop()
+		Expect(notification.messages).To(HaveLen(2))
+		Expect(notification.message).To(HavePrefix(fmt.Sprintf(SummaryWindowFormat, start.Add(10*time.Second).String(), start.Add(20*time.Second).String())))
+	})
+
+	It(`splits a backfill into the windows of the event times`, func() {
+		for offset := time.Duration(0); offset < time.Minute; offset += 5 * time.Second {
+			trafficMonitor.Monitor(at(offset))
+		}
+		trafficMonitor.Stop()
+		Expect(notification.messages).To(HaveLen(6))
+		for i, message := range notification.messages {
+			windowStart := start.Add(time.Duration(i) * 10 * time.Second)
+			Expect(message).To(Equal(fmt.Sprintf(SummaryWindowFormat, windowStart.String(), windowStart.Add(10*time.Second).String()) + count(2)))
+		}
+	})
+
+	It(`sends the open windows when the events stop for a tick`, func() {
+		trafficMonitor.Monitor(at(1 * time.Second))
+		clock.Advance(10 * time.Second)
+		Consistently(notification.Messages, 100*time.Millisecond).Should(BeEmpty())
+		clock.Advance(10 * time.Second)
+		Eventually(notification.Messages).Should(HaveLen(1))
+	})
+
+	It(`sends a window flushed on a tick again as an update when it gets more events`, func() {
+		trafficMonitor.Monitor(at(1 * time.Second))
+		clock.Advance(10 * time.Second)
+		clock.Advance(10 * time.Second)
+		Eventually(notification.Messages).Should(HaveLen(1))
+
+		trafficMonitor.Monitor(at(2 * time.Second))
+		trafficMonitor.Monitor(at(10 * time.Second))
+		trafficMonitor.Stop()
+		Expect(notification.messages).To(HaveLen(3))
+		Expect(notification.messages[0]).To(Equal(fmt.Sprintf(SummaryWindowFormat, start.String(), start.Add(10*time.Second).String()) + count(1)))
+		Expect(notification.messages[1]).To(Equal(fmt.Sprintf(UpdatedSummaryWindowFormat, start.String(), start.Add(10*time.Second).String()) + count(2)))
+		Expect(notification.messages[2]).To(HavePrefix(fmt.Sprintf(SummaryWindowFormat, start.Add(10*time.Second).String(), start.Add(20*time.Second).String())))
+	})
+
+	Context(`with status reporters`, func() {
+		BeforeEach(func() {
+			options = append(options, ReportStatus(statusMock("SLO cart: 99.9% good\n"), statusMock("SLO api: no events\n")))
+		})
+
+		It(`appends their status to the summaries`, func() {
+			trafficMonitor.Monitor(at(1 * time.Second))
+			trafficMonitor.Stop()
+			Expect(notification.message).To(HaveSuffix(count(1) + "SLO cart: 99.9% good\nSLO api: no events\n"))
+		})
+	})
+
+	Context(`with an allowed lateness`, func() {
+		BeforeEach(func() {
+			options = append(options, AllowedLateness(5*time.Second))
+		})
+
+		It(`keeps the window open for out of order events`, func() {
+			trafficMonitor.Monitor(at(8 * time.Second))
+			trafficMonitor.Monitor(at(12 * time.Second))
+			trafficMonitor.Monitor(at(3 * time.Second))
+			trafficMonitor.Monitor(at(15 * time.Second))
+			Eventually(notification.Messages).Should(HaveLen(1))
+			Expect(notification.Last()).To(Equal(fmt.Sprintf(SummaryWindowFormat, start.String(), start.Add(10*time.Second).String()) + count(2)))
+			Expect(trafficMonitor.LateEvents()).To(BeZero())
+		})
+	})
+
+	Context(`when late events are counted`, func() {
+		It(`reports the late events with the next summary`, func() {
+			trafficMonitor.Monitor(at(8 * time.Second))
+			trafficMonitor.Monitor(at(12 * time.Second))
+			trafficMonitor.Monitor(at(3 * time.Second))
+			trafficMonitor.Stop()
+			Expect(notification.messages).To(HaveLen(2))
+			Expect(notification.message).To(Equal(
+				fmt.Sprintf(SummaryWindowFormat, start.Add(10*time.Second).String(), start.Add(20*time.Second).String()) + fmt.Sprintf(LateEventsFormat, 1) + count(1),
+			))
+			Expect(trafficMonitor.LateEvents()).To(Equal(uint64(1)))
+		})
+	})
+
+	Context(`when late events update their window`, func() {
+		BeforeEach(func() {
+			options = append(options, LateEvents(UpdateLateWindows))
+		})
+
+		It(`sends the updated summary of the window`, func() {
+			trafficMonitor.Monitor(at(8 * time.Second))
+			trafficMonitor.Monitor(at(12 * time.Second))
+			trafficMonitor.Monitor(at(3 * time.Second))
+			trafficMonitor.Stop()
+			Expect(notification.messages).To(HaveLen(3))
+			Expect(notification.messages[1]).To(Equal(fmt.Sprintf(UpdatedSummaryWindowFormat, start.String(), start.Add(10*time.Second).String()) + count(2)))
+			Expect(trafficMonitor.LateEvents()).To(BeZero())
+		})
+	})
+})
+
+var _ = Describe(`ParseLatePolicy`, func() {
+	It(`parses the policy names`, func() {
+		for name, expected := range map[string]LatePolicy{"count": CountLateEvents, "update": UpdateLateWindows} {
+			policy, err := ParseLatePolicy(name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(policy).To(Equal(expected))
+			Expect(policy.String()).To(Equal(name))
+		}
+	})
+
+	It(`rejects unknown policies`, func() {
+		_, err := ParseLatePolicy("drop")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe(`SummaryStatsTrafficMonitor grouped by browser`, func() {
+	var (
+		trafficMonitor TrafficMonitor
+		notification   *notificationMock
+		clock          *FakeClock
+	)
+
+	BeforeEach(func() {
+		notification = new(notificationMock)
+		clock = NewFakeClock(time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC))
+		trafficMonitor = NewSummaryStatsTrafficMonitor(1*time.Second, notification, GroupBy(BrowserKey, BotKey), MonitorClock(clock))
+	})
+
+	JustBeforeEach(func() {
+		trafficMonitor.Monitor(Event{Path: `/section1`, PayloadSize: 10, StatusCode: 200, Browser: "Chrome", Device: DesktopDevice})
+		trafficMonitor.Monitor(Event{Path: `/section1`, PayloadSize: 20, StatusCode: 200, Browser: "Googlebot", Device: BotDevice, Bot: true})
+		trafficMonitor.Stop()
+	})
+
+	It(`breaks the summary statistics down by the event keys`, func() {
+		Expect(notification.message).To(And(
+			ContainSubstring(fmt.Sprintf(SummaryStatisticsFormat, "browser:Chrome", 10.0, 10, 1, 0, 0, 0, 1)),
+			ContainSubstring(fmt.Sprintf(SummaryStatisticsFormat, "browser:Googlebot", 20.0, 20, 1, 0, 0, 0, 1)),
+			ContainSubstring(fmt.Sprintf(SummaryStatisticsFormat, "bot:true", 20.0, 20, 1, 0, 0, 0, 1)),
+			ContainSubstring(fmt.Sprintf(SummaryStatisticsFormat, "bot:false", 10.0, 10, 1, 0, 0, 0, 1)),
+			Not(ContainSubstring("Section: /section1")),
+		))
+	})
+})
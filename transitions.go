@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+type AlertState int
+
+const (
+	AlertInactive AlertState = iota
+	AlertPending
+	AlertFiring
+	AlertResolved
+)
+
+var alertStateNames = map[AlertState]string{
+	AlertInactive: "inactive",
+	AlertPending:  "pending",
+	AlertFiring:   "firing",
+	AlertResolved: "resolved",
+}
+
+func (a AlertState) String() string {
+	return alertStateNames[a]
+}
+
+func (a AlertState) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *AlertState) UnmarshalText(text []byte) error {
+	for state, name := range alertStateNames {
+		if name == string(text) {
+			*a = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown alert state: %s", text)
+}
+
+type AlertTransition struct {
+	Alert     string        `json:"alert"`
+	Label     string        `json:"label,omitempty"`
+	From      AlertState    `json:"from"`
+	To        AlertState    `json:"to"`
+	At        time.Time     `json:"at"`
+	Since     time.Time     `json:"since"`
+	Condition string        `json:"condition"`
+	Subject   string        `json:"subject"`
+	Details   string        `json:"details,omitempty"`
+	Value     float64       `json:"value"`
+	Threshold float64       `json:"threshold"`
+	Window    time.Duration `json:"-"`
+}
+
+func (a AlertTransition) Labels() map[string]string {
+	return map[string]string{"alert": a.Alert, "group": a.Label, "subject": a.Subject}
+}
+
+func (a AlertTransition) Title() string {
+	switch a.To {
+	case AlertFiring:
+		return a.Condition + " generated an alert"
+	case AlertResolved:
+		if a.Subject == "" {
+			return "Alert returned to normal"
+		}
+		return capitalize(a.Subject) + " returned to normal"
+	}
+	return fmt.Sprintf("%s is %s", a.Condition, a.To)
+}
+
+func capitalize(text string) string {
+	if text == "" {
+		return text
+	}
+	first, size := utf8.DecodeRuneInString(text)
+	return string(unicode.ToUpper(first)) + text[size:]
+}
+
+func (a AlertTransition) Severity() Severity {
+	switch a.To {
+	case AlertFiring:
+		return SeverityCritical
+	case AlertPending:
+		return SeverityWarning
+	}
+	return SeverityInfo
+}
+
+func (a AlertTransition) String() string {
+	details := ""
+	if a.Details != "" {
+		details = " - " + a.Details
+	}
+	if a.To == AlertFiring || a.To == AlertResolved {
+		return fmt.Sprintf("%s%s, triggered at %s\n", a.Title(), details, a.At.String())
+	}
+	return fmt.Sprintf("%s%s, since %s\n", a.Title(), details, a.At.String())
+}
+
+type TransitionNotification interface {
+	Notification
+	SendTransition(AlertTransition)
+}
+
+type AlertLifecycle struct {
+	alert        string
+	label        string
+	window       time.Duration
+	pendingFor   time.Duration
+	minFiring    time.Duration
+	notification Notification
+
+	state AlertState
+	since time.Time
+}
+
+func newAlertLifecycle(alert string, window time.Duration, notification Notification, options alertOptions) *AlertLifecycle {
+	return &AlertLifecycle{
+		alert:        alert,
+		label:        options.label,
+		window:       window,
+		pendingFor:   options.pendingFor,
+		minFiring:    options.minFiring,
+		notification: notification,
+	}
+}
+
+func (a *AlertLifecycle) State() AlertState {
+	return a.state
+}
+
+func (a *AlertLifecycle) Since() time.Time {
+	return a.since
+}
+
+func (a *AlertLifecycle) Firing() bool {
+	return a.state == AlertFiring
+}
+
+func (a *AlertLifecycle) Evaluate(at time.Time, breached, cleared bool, describe func(*AlertTransition)) {
+	switch a.state {
+	case AlertInactive, AlertResolved:
+		if !breached {
+			return
+		}
+		if a.pendingFor > 0 {
+			a.transition(at, AlertPending, describe)
+			return
+		}
+		a.transition(at, AlertFiring, describe)
+	case AlertPending:
+		if !breached {
+			a.transition(at, AlertInactive, describe)
+		} else if at.Sub(a.since) >= a.pendingFor {
+			a.transition(at, AlertFiring, describe)
+		}
+	case AlertFiring:
+		if cleared && at.Sub(a.since) >= a.minFiring {
+			a.transition(at, AlertResolved, describe)
+		}
+	}
+}
+
+func (a *AlertLifecycle) transition(at time.Time, state AlertState, describe func(*AlertTransition)) {
+	transition := AlertTransition{
+		Alert:  a.alert,
+		Label:  a.label,
+		From:   a.state,
+		To:     state,
+		At:     at,
+		Since:  a.since,
+		Window: a.window,
+	}
+	describe(&transition)
+	a.state, a.since = state, at
+
+	if notification, ok := a.notification.(TransitionNotification); ok {
+		notification.SendTransition(transition)
+	} else if state == AlertFiring || state == AlertResolved {
+		a.notification.Send(NewAlertMessage(transition))
+	}
+}
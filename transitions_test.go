@@ -0,0 +1,124 @@
+package main_test
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/wchan2/redwood"
+)
+
+type transitionsMock struct {
+	notificationMock
+	transitions []AlertTransition
+}
+
+func (t *transitionsMock) SendTransition(transition AlertTransition) {
+	t.transitions = append(t.transitions, transition)
+	t.Send(NewAlertMessage(transition))
+}
+
+var _ = Describe(`AlertLifecycle`, func() {
+	var (
+		notification *transitionsMock
+		start        time.Time
+	)
+
+	BeforeEach(func() {
+		notification = new(transitionsMock)
+		start = time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	hits := func(alert Alert, at time.Time, count int) {
+		for i := 0; i < count; i++ {
+			alert.Check(Event{Time: at})
+		}
+	}
+
+	It(`waits in pending until the condition held for the "for" duration`, func() {
+		alert := NewTotalTrafficAlert(2, time.Minute, notification, AlertFor(30*time.Second))
+		hits(alert, start, 2)
+		Expect(alert.State()).To(Equal(AlertPending))
+
+		hits(alert, start.Add(20*time.Second), 1)
+		Expect(alert.State()).To(Equal(AlertPending))
+		hits(alert, start.Add(30*time.Second), 1)
+		Expect(alert.State()).To(Equal(AlertFiring))
+
+		Expect(notification.transitions).To(HaveLen(2))
+		Expect(notification.transitions[0].From).To(Equal(AlertInactive))
+		Expect(notification.transitions[0].To).To(Equal(AlertPending))
+		Expect(notification.transitions[1]).To(Equal(AlertTransition{
+			Alert:     "traffic",
+			From:      AlertPending,
+			To:        AlertFiring,
+			At:        start.Add(30 * time.Second),
+			Since:     start,
+			Condition: "High traffic",
+			Subject:   "traffic",
+			Details:   "hits = 4",
+			Value:     4,
+			Threshold: 2,
+			Window:    time.Minute,
+		}))
+	})
+
+	It(`goes back to inactive when the condition stops holding while pending`, func() {
+		alert := NewTotalTrafficAlert(2, time.Minute, notification, AlertFor(30*time.Second))
+		hits(alert, start, 2)
+		hits(alert, start.Add(2*time.Minute), 1)
+		Expect(alert.State()).To(Equal(AlertInactive))
+		Expect(alert.Triggered()).To(BeFalse())
+	})
+
+	It(`keeps firing for the minimum firing time`, func() {
+		alert := NewTotalTrafficAlert(2, time.Minute, notification, AlertMinFiring(5*time.Minute))
+		hits(alert, start, 2)
+		hits(alert, start.Add(2*time.Minute), 1)
+		Expect(alert.State()).To(Equal(AlertFiring))
+		hits(alert, start.Add(5*time.Minute), 1)
+		Expect(alert.State()).To(Equal(AlertResolved))
+	})
+
+	It(`only resolves once the traffic gets back under the clear threshold`, func() {
+		alert := NewTotalTrafficAlert(10, time.Minute, notification, AlertHysteresis(0.5))
+		hits(alert, start, 10)
+		Expect(alert.State()).To(Equal(AlertFiring))
+
+		hits(alert, start.Add(55*time.Second), 6)
+		hits(alert, start.Add(111*time.Second), 1)
+		Expect(alert.State()).To(Equal(AlertFiring))
+
+		later := start.Add(171 * time.Second)
+		hits(alert, later, 1)
+		Expect(alert.State()).To(Equal(AlertResolved))
+		Expect(notification.message).To(Equal(fmt.Sprintf("Traffic returned to normal, triggered at %s\n", later.String())))
+	})
+
+	It(`only sends the firing and resolved transitions to plain notifications`, func() {
+		plain := new(notificationMock)
+		alert := NewTotalTrafficAlert(1, time.Minute, plain, AlertFor(time.Second))
+		hits(alert, start, 1)
+		Expect(plain.messages).To(BeEmpty())
+		hits(alert, start.Add(time.Second), 1)
+		Expect(plain.messages).To(HaveLen(1))
+	})
+})
+
+var _ = Describe(`AlertTransition`, func() {
+	It(`renders the firing and resolved transitions`, func() {
+		at := time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+		transition := AlertTransition{To: AlertFiring, At: at, Condition: "High error rate on /api", Subject: "error rate on /api", Details: "errors = 3 of 4 requests (75.0%)"}
+		Expect(transition.String()).To(Equal(fmt.Sprintf("High error rate on /api generated an alert - errors = 3 of 4 requests (75.0%%), triggered at %s\n", at.String())))
+
+		transition.To = AlertResolved
+		Expect(transition.String()).To(Equal(fmt.Sprintf("Error rate on /api returned to normal - errors = 3 of 4 requests (75.0%%), triggered at %s\n", at.String())))
+		Expect(AlertPending.String()).To(Equal("pending"))
+	})
+
+	It(`renders a resolved transition without a subject`, func() {
+		Expect(AlertTransition{To: AlertResolved}.Title()).To(Equal("Alert returned to normal"))
+		Expect(AlertTransition{To: AlertResolved, Subject: "été traffic"}.Title()).To(Equal("Été traffic returned to normal"))
+	})
+})